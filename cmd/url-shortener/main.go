@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -12,54 +13,282 @@ import (
 
 	"github.com/hard-gainer/url-shortener/internal/api"
 	"github.com/hard-gainer/url-shortener/internal/config"
+	"github.com/hard-gainer/url-shortener/internal/idgen"
 	"github.com/hard-gainer/url-shortener/internal/logger"
+	"github.com/hard-gainer/url-shortener/internal/migrator"
 	"github.com/hard-gainer/url-shortener/internal/service"
 	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/hard-gainer/url-shortener/internal/storage/breaker"
 	"github.com/hard-gainer/url-shortener/internal/storage/memory"
 	"github.com/hard-gainer/url-shortener/internal/storage/postgres"
+	"github.com/hard-gainer/url-shortener/internal/transfer"
 )
 
 func main() {
 	logger.InitLogger()
 	cfg := config.InitConfig()
+	logger.SetLevel(cfg.LogLevel)
 
 	storageType := flag.String("storage", "postgres", "Storage type")
+	exportFile := flag.String("export", "", "Export all urls to the file and exit, - is the standard output")
+	importFile := flag.String("import", "", "Import urls from the file and exit, - is the standard input")
+	transferFormat := flag.String("format", "jsonl", "Format of the export and import files: jsonl or csv")
+	migrateTo := flag.String("migrate-to", "", "Copy all urls to the storage of the type, verify them and exit")
+	dualWrite := flag.String("dual-write", "", "Mirror created urls to the storage of the type and copy the existing ones in background")
+	checkpointFile := flag.String("migrate-checkpoint", "migration.checkpoint", "File the migration progress is saved to")
+	recanonicalize := flag.Bool("recanonicalize", false, "Update the canonical urls of stored urls and exit")
 	flag.Parse()
 
 	slog.Info("initializing storage", "storage type", *storageType)
-	var repo storage.Repository
+	repo, err := newStorage(*storageType, cfg)
+	if err != nil {
+		slog.Error("failed to initialize storage", "error", err)
+		os.Exit(1)
+	}
 
-	switch *storageType {
-	case "memory":
-		var err error
-		repo, err = memory.NewMemory()
+	canonicalizer := service.Canonicalizer{
+		StripTracking:  cfg.URLConfig.StripTrackingParams,
+		TrackingParams: cfg.URLConfig.TrackingParams,
+	}
+
+	policies, err := service.NewPolicySet(service.DefaultPolicy)
+	if cfg.GeneratorConfig.PoliciesFile != "" {
+		policies, err = service.LoadPolicies(cfg.GeneratorConfig.PoliciesFile)
+	}
+	if err != nil {
+		slog.Error("failed to load code policies", "error", err)
+		os.Exit(1)
+	}
+	policies.DefaultMaxLength(cfg.GeneratorConfig.MaxLength)
+	if cfg.GeneratorConfig.CaseInsensitive {
+		policies.FoldCase()
+	}
+
+	var validator *service.CodeValidator
+	if cfg.GeneratorConfig.ValidateCodes {
+		validator = service.NewCodeValidator(policies, cfg.GeneratorConfig.ReservedPaths)
+	}
+
+	destinations, err := newDestinationPolicy(cfg, true)
+	if err != nil {
+		slog.Error("failed to initialize destination policy", "error", err)
+		os.Exit(1)
+	}
+	// imports don't resolve host names, a lookup per row would make
+	// large imports take hours
+	importDestinations, err := newDestinationPolicy(cfg, false)
+	if err != nil {
+		slog.Error("failed to initialize destination policy", "error", err)
+		os.Exit(1)
+	}
+
+	blocklist := service.NewBlocklist()
+	if err := blocklist.Load(cfg.RuntimeConfig.BlocklistPath); err != nil {
+		slog.Error("failed to load blocklist", "error", err)
+		os.Exit(1)
+	}
+
+	// imported urls are checked like the shortened ones
+	importOpts := []transfer.ImportOption{
+		transfer.WithCanonicalizer(canonicalizer),
+		transfer.WithDestinationPolicy(importDestinations),
+		transfer.WithBlocklist(blocklist),
+	}
+	if validator != nil {
+		importOpts = append(importOpts, transfer.WithCodeValidator(validator))
+	}
+
+	if *exportFile != "" || *importFile != "" {
+		err := runTransfer(repo, *exportFile, *importFile, *transferFormat, importOpts...)
+		if err == nil && *importFile != "" && cfg.MemoryConfig.SnapshotFile != "" {
+			if snapshotter, ok := repo.(storage.Snapshotter); ok {
+				_, err = snapshotter.Snapshot(context.Background())
+			}
+		}
+		repo.Close()
 		if err != nil {
-			slog.Error("failed to initialize memory storage", "error", err)
+			slog.Error("transfer failed", "error", err)
 			os.Exit(1)
 		}
-	case "postgres":
-		var err error
-		repo, err = postgres.NewPostgres(cfg)
+		return
+	}
+
+	if *recanonicalize {
+		err := runRecanonicalize(repo, canonicalizer)
+		repo.Close()
 		if err != nil {
-			slog.Error("failed to initialize storage", "error", err)
+			slog.Error("recanonicalization failed", "error", err)
 			os.Exit(1)
 		}
-	default:
-		slog.Error("unknown storage type", "error", *storageType)
+		return
+	}
+
+	if *migrateTo != "" {
+		err := runMigration(repo, *storageType, *migrateTo, *checkpointFile, cfg)
+		repo.Close()
+		if err != nil {
+			slog.Error("migration failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	generator, err := newCodeGenerator(cfg.GeneratorConfig, repo, policies.Default())
+	if err != nil {
+		slog.Error("failed to initialize code generator", "error", err)
 		os.Exit(1)
 	}
 
+	var codePool *service.CodePool
+	if cfg.GeneratorConfig.PoolSize > 0 {
+		reserver, ok := repo.(service.CodeReserver)
+		if !ok {
+			slog.Error("storage does not support code reservation")
+			os.Exit(1)
+		}
+		codePool, err = service.NewCodePool(generator, reserver,
+			cfg.GeneratorConfig.PoolSize, cfg.GeneratorConfig.PoolLowWater, cfg.GeneratorConfig.PoolBatchSize)
+		if err != nil {
+			slog.Error("failed to initialize code pool", "error", err)
+			os.Exit(1)
+		}
+		generator = codePool
+	}
+
+	usage, _ := repo.(storage.UsageReporter)
+	exporter, _ := repo.(storage.Exporter)
+	importer, _ := repo.(storage.Importer)
+	var snapshotter storage.Snapshotter
+	if cfg.MemoryConfig.SnapshotFile != "" {
+		snapshotter, _ = repo.(storage.Snapshotter)
+	}
+
+	// codes saved past the service, by imports and mirroring,
+	// are added to the code filter as well
+	var codeFilter *service.CodeFilter
+	var dualWriterOpts []migrator.DualWriterOption
+	if cfg.CodeFilterConfig.FalsePositiveRate > 0 {
+		if exporter == nil {
+			slog.Error("storage does not support listing codes for the code filter")
+			os.Exit(1)
+		}
+		// only the memory storage is written by this process alone,
+		// misses of shared storages are looked up
+		var filterOpts []service.CodeFilterOption
+		if *storageType == "memory" {
+			filterOpts = append(filterOpts, service.WithSingleWriter())
+		}
+		codeFilter, err = service.NewCodeFilter(context.Background(), exporter, int64(cfg.CodeFilterConfig.Capacity),
+			cfg.CodeFilterConfig.FalsePositiveRate, cfg.GeneratorConfig.CaseInsensitive, filterOpts...)
+		if err != nil {
+			slog.Error("failed to build code filter", "error", err)
+			os.Exit(1)
+		}
+		importOpts = append(importOpts, transfer.WithOnSaved(codeFilter.Add))
+		dualWriterOpts = append(dualWriterOpts, migrator.WithOnSaved(codeFilter.Add))
+	}
+
+	var urlMigrator *migrator.Migrator
+	if *dualWrite != "" {
+		if *dualWrite == *storageType {
+			slog.Error("dual write storage is the same as the main one", "storage type", *dualWrite)
+			os.Exit(1)
+		}
+
+		secondary, err := newStorage(*dualWrite, cfg)
+		if err != nil {
+			slog.Error("failed to initialize dual write storage", "error", err)
+			os.Exit(1)
+		}
+		urlMigrator, err = migrator.New(repo, secondary, migrator.WithCheckpointFile(*checkpointFile))
+		if err != nil {
+			slog.Error("failed to initialize migration", "error", err)
+			os.Exit(1)
+		}
+		repo = migrator.NewDualWriter(repo, secondary.(migrator.Destination), dualWriterOpts...)
+		slog.Info("dual writes enabled", "storage type", *dualWrite)
+	}
+
+	var breakerOpts []breaker.Option
+	if cfg.GeneratorConfig.CaseInsensitive {
+		breakerOpts = append(breakerOpts, breaker.WithCaseInsensitiveCodes())
+	}
+	repo = breaker.NewBreaker(repo, cfg.BreakerConfig, breakerOpts...)
 	defer repo.Close()
+	// admin imports go through the breaker and are mirrored during dual writes
+	if importer != nil {
+		importer = repo.(storage.Importer)
+	}
 	slog.Info("storage successfully intialized")
 
-	urlService := service.NewURLService(repo)
+	serviceOpts := []service.Option{
+		service.WithCodeGenerator(generator),
+		service.WithPolicies(policies),
+		service.WithCollisionThreshold(cfg.GeneratorConfig.CollisionThreshold, cfg.GeneratorConfig.CollisionWindow),
+		service.WithCanonicalizer(canonicalizer),
+	}
+	if validator != nil {
+		serviceOpts = append(serviceOpts, service.WithCodeValidator(validator))
+	}
+	serviceOpts = append(serviceOpts,
+		service.WithDestinationPolicy(destinations),
+		service.WithBlocklist(blocklist),
+	)
+
+	if codeFilter != nil {
+		serviceOpts = append(serviceOpts, service.WithCodeFilter(codeFilter))
+	}
+
+	urlService := service.NewURLService(repo, serviceOpts...)
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	if cfg.GeneratorConfig.KeyspaceStatsInterval > 0 {
+		go service.MonitorKeyspace(monitorCtx, urlService, cfg.GeneratorConfig.KeyspaceStatsInterval)
+	}
+	if codeFilter != nil && cfg.CodeFilterConfig.RefreshInterval > 0 {
+		go service.RefreshCodeFilter(monitorCtx, codeFilter, cfg.CodeFilterConfig.RefreshInterval)
+	}
+	if urlMigrator != nil {
+		go func() {
+			if _, err := urlMigrator.Run(monitorCtx); err != nil {
+				slog.Error("migration failed", "error", err)
+			}
+		}()
+	}
+
+	rateLimiter := api.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+
+	reloader := config.NewReloader(config.EnvFile, cfg.RuntimeConfig)
+	reloader.Subscribe(func(rc config.RuntimeConfig) {
+		logger.SetLevel(rc.LogLevel)
+		rateLimiter.SetLimits(rc.RateLimitRPS, rc.RateLimitBurst)
+	})
 
 	server := api.NewServer(":" + cfg.AppConfig.Port)
-    server.WithMiddleware(api.LoggingMiddleware)
+	server.WithMiddleware(rateLimiter.Middleware)
+	server.WithMiddleware(api.LoggingMiddleware)
 
 	urlHandler := api.NewURLHandler(urlService, cfg.AppConfig.URL)
 	urlHandler.RegisterRoutes(server.Mux())
 
+	if cfg.AppConfig.AdminToken != "" {
+		adminHandler := api.NewAdminHandler(urlService, cfg.AppConfig.AdminToken)
+		if usage != nil {
+			adminHandler.WithUsage(usage)
+		}
+		if snapshotter != nil {
+			adminHandler.WithSnapshotter(snapshotter)
+		}
+		adminHandler.WithTransfer(exporter, importer, importOpts...)
+		if urlMigrator != nil {
+			adminHandler.WithMigrator(urlMigrator)
+		}
+		adminHandler.RegisterRoutes(server.Mux())
+	} else {
+		slog.Info("ADMIN_TOKEN is not set, admin endpoints are disabled")
+	}
+
 	go func() {
 		if err := server.Run(); err != nil && err != http.ErrServerClosed {
 			slog.Error("server error", "error", err)
@@ -67,9 +296,24 @@ func main() {
 		}
 	}()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("reloading config")
+			if _, err := reloader.Reload(); err != nil {
+				slog.Error("failed to reload config, keeping the current one", "error", err)
+			}
+			if err := blocklist.Load(reloader.Current().BlocklistPath); err != nil {
+				slog.Error("failed to reload blocklist, keeping the current one", "error", err)
+			}
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	signal.Stop(hup)
 	slog.Info("shutting down server")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -79,5 +323,215 @@ func main() {
 		slog.Error("server shutdown error", "error", err)
 	}
 
+	// the pool release and the snapshot get their own time,
+	// the server shutdown may have used up all of it
+	stateCtx, stateCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stateCancel()
+
+	if codePool != nil {
+		if err := codePool.Close(stateCtx); err != nil {
+			slog.Error("failed to release code pool", "error", err)
+		}
+	}
+
+	if snapshotter != nil {
+		if saved, err := snapshotter.Snapshot(stateCtx); err != nil {
+			slog.Error("failed to save snapshot", "error", err)
+		} else {
+			slog.Info("saved snapshot", "urls", saved)
+		}
+	}
+
 	slog.Info("server exited properly")
 }
+
+// newStorage creates the storage of the type kind
+func newStorage(kind string, cfg *config.Config) (storage.Repository, error) {
+	switch kind {
+	case "memory":
+		opts := []memory.Option{
+			memory.WithShards(cfg.MemoryConfig.Shards),
+			memory.WithLimits(int64(cfg.MemoryConfig.MaxLinks), int64(cfg.MemoryConfig.MaxBytes),
+				memory.EvictionPolicy(cfg.MemoryConfig.EvictionPolicy)),
+		}
+		if cfg.MemoryConfig.SnapshotFile != "" {
+			opts = append(opts, memory.WithSnapshotFile(cfg.MemoryConfig.SnapshotFile))
+		}
+		if cfg.GeneratorConfig.CaseInsensitive {
+			opts = append(opts, memory.WithCaseInsensitiveCodes())
+		}
+		return memory.NewMemory(opts...)
+	case "postgres":
+		return postgres.NewPostgres(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", kind)
+	}
+}
+
+// newDestinationPolicy creates the policy of urls which may be shortened,
+// host names are resolved only if resolve is set as well
+func newDestinationPolicy(cfg *config.Config, resolve bool) (*service.DestinationPolicy, error) {
+	rules := []service.DestinationRule{
+		service.AllowSchemes(cfg.URLConfig.AllowedSchemes...),
+	}
+	if cfg.URLConfig.MaxLength > 0 {
+		rules = append(rules, service.MaxURLLength(cfg.URLConfig.MaxLength))
+	}
+
+	hosts, err := service.FilterHosts(cfg.URLConfig.AllowedHosts, cfg.URLConfig.DeniedHosts)
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, hosts)
+
+	self, err := service.DenySelf(cfg.AppConfig.URL)
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, self)
+	if !cfg.URLConfig.AllowPrivateAddresses {
+		rules = append(rules, service.DenyPrivateAddresses(resolve && cfg.URLConfig.ResolveHosts))
+	}
+
+	return service.NewDestinationPolicy(rules...), nil
+}
+
+// newCodeGenerator creates the code generator selected in cfg
+func newCodeGenerator(cfg config.GeneratorConfig, repo storage.Repository, policy service.CodePolicy) (service.CodeGenerator, error) {
+	switch cfg.Strategy {
+	case "random":
+		return service.NewRandomGenerator(policy.Length, policy.Alphabet), nil
+	case "sequence":
+		ids, ok := repo.(service.IDSource)
+		if !ok {
+			return nil, fmt.Errorf("storage does not provide a sequence")
+		}
+		return service.NewSequenceGenerator(ids, []byte(cfg.Key), policy.Length, policy.Alphabet)
+	case "snowflake":
+		if policy.Capacity() <= idgen.MaxID {
+			return nil, fmt.Errorf("policy %q has too few codes for snowflake ids", policy.Name)
+		}
+		ids, err := idgen.NewSnowflake(int64(cfg.NodeID))
+		if err != nil {
+			return nil, err
+		}
+		return service.NewSequenceGenerator(ids, []byte(cfg.Key), policy.Length, policy.Alphabet)
+	default:
+		return nil, fmt.Errorf("unknown code generator %q", cfg.Strategy)
+	}
+}
+
+// runTransfer exports the urls of repo to exportFile and imports the urls
+// of importFile into repo, - means the standard output or input
+func runTransfer(repo storage.Repository, exportFile, importFile, formatName string, opts ...transfer.ImportOption) error {
+	ctx := context.Background()
+
+	format, err := transfer.ParseFormat(formatName)
+	if err != nil {
+		return err
+	}
+
+	if exportFile != "" {
+		exporter, ok := repo.(storage.Exporter)
+		if !ok {
+			return fmt.Errorf("storage does not support export")
+		}
+
+		out := os.Stdout
+		if exportFile != "-" {
+			if out, err = os.Create(exportFile); err != nil {
+				return err
+			}
+		}
+
+		exported, err := transfer.Export(ctx, exporter, out, format)
+		if exportFile != "-" {
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		slog.Info("exported urls", "count", exported, "file", exportFile)
+	}
+
+	if importFile != "" {
+		importer, ok := repo.(storage.Importer)
+		if !ok {
+			return fmt.Errorf("storage does not support import")
+		}
+
+		in := os.Stdin
+		if importFile != "-" {
+			if in, err = os.Open(importFile); err != nil {
+				return err
+			}
+			defer in.Close()
+		}
+
+		report, err := transfer.Import(ctx, importer, in, format, opts...)
+		for _, e := range report.Errors {
+			slog.Warn("url not imported", "line", e.Line, "short_url", e.ShortURL, "error", e.Error)
+		}
+		slog.Info("imported urls", "imported", report.Imported,
+			"conflicts", report.Conflicts, "invalid", report.Invalid, "file", importFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runRecanonicalize updates the canonical urls of the urls stored in repo
+func runRecanonicalize(repo storage.Repository, canonicalizer service.Canonicalizer) error {
+	exporter, ok := repo.(storage.Exporter)
+	recanonicalizer, canUpdate := repo.(storage.Recanonicalizer)
+	if !ok || !canUpdate {
+		return fmt.Errorf("storage does not support recanonicalization")
+	}
+
+	report, err := service.Recanonicalize(context.Background(), exporter, recanonicalizer, canonicalizer)
+	slog.Info("recanonicalized urls", "updated", report.Updated,
+		"standalone", report.Standalone, "invalid", report.Invalid)
+	return err
+}
+
+// runMigration copies the urls of repo to the storage of the type target
+// and verifies that both storages have the same urls
+func runMigration(repo storage.Repository, source, target, checkpointFile string, cfg *config.Config) error {
+	ctx := context.Background()
+
+	if source == target {
+		return fmt.Errorf("source and destination are the same storage")
+	}
+
+	dst, err := newStorage(target, cfg)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	m, err := migrator.New(repo, dst, migrator.WithCheckpointFile(checkpointFile))
+	if err != nil {
+		return err
+	}
+
+	parity, err := m.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	if snapshotter, ok := dst.(storage.Snapshotter); ok && cfg.MemoryConfig.SnapshotFile != "" {
+		if _, err := snapshotter.Snapshot(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !parity.Match {
+		return fmt.Errorf("storages differ: source has %d urls, destination has %d",
+			parity.Source.Count, parity.Destination.Count)
+	}
+	return nil
+}
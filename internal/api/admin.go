@@ -0,0 +1,340 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/metrics"
+	"github.com/hard-gainer/url-shortener/internal/migrator"
+	"github.com/hard-gainer/url-shortener/internal/service"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/hard-gainer/url-shortener/internal/transfer"
+)
+
+// transferTimeout limits the export and the import, they stream all urls
+// and take longer than the server timeouts of ordinary requests
+const transferTimeout = time.Hour
+
+// AdminHandler handles administrative requests
+type AdminHandler struct {
+	urlService  service.URLService
+	usage       storage.UsageReporter
+	snapshotter storage.Snapshotter
+	exporter    storage.Exporter
+	importer    storage.Importer
+	importOpts  []transfer.ImportOption
+	migrator    *migrator.Migrator
+	token       string
+}
+
+// NewAdminHandler creates a new admin handler, its routes
+// require token as a bearer token
+func NewAdminHandler(urlService service.URLService, token string) *AdminHandler {
+	return &AdminHandler{
+		urlService: urlService,
+		token:      token,
+	}
+}
+
+// WithUsage enables the storage usage endpoint
+func (h *AdminHandler) WithUsage(usage storage.UsageReporter) {
+	h.usage = usage
+}
+
+// WithSnapshotter enables the snapshot endpoint
+func (h *AdminHandler) WithSnapshotter(snapshotter storage.Snapshotter) {
+	h.snapshotter = snapshotter
+}
+
+// WithTransfer enables the export and import endpoints, opts configure imports
+func (h *AdminHandler) WithTransfer(exporter storage.Exporter, importer storage.Importer, opts ...transfer.ImportOption) {
+	h.exporter = exporter
+	h.importer = importer
+	h.importOpts = opts
+}
+
+// WithMigrator enables the migration status and verification endpoints
+func (h *AdminHandler) WithMigrator(m *migrator.Migrator) {
+	h.migrator = m
+}
+
+// RegisterRoutes registers the handler's routes
+func (h *AdminHandler) RegisterRoutes(mux *http.ServeMux) {
+	// metrics include the command line and the internal counters
+	mux.Handle("GET /debug/vars", h.requireToken(expvar.Handler()))
+	mux.Handle("GET /api/admin/stats", h.requireToken(http.HandlerFunc(h.GetStats)))
+	if h.usage != nil {
+		mux.Handle("GET /api/admin/storage", h.requireToken(http.HandlerFunc(h.GetStorageUsage)))
+	}
+	if h.snapshotter != nil {
+		mux.Handle("POST /api/admin/snapshot", h.requireToken(http.HandlerFunc(h.CreateSnapshot)))
+	}
+	if h.exporter != nil {
+		mux.Handle("GET /api/admin/export", h.requireToken(http.HandlerFunc(h.ExportURLs)))
+	}
+	if h.importer != nil {
+		mux.Handle("POST /api/admin/import", h.requireToken(http.HandlerFunc(h.ImportURLs)))
+	}
+	if h.migrator != nil {
+		mux.Handle("GET /api/admin/migration", h.requireToken(http.HandlerFunc(h.GetMigration)))
+		mux.Handle("POST /api/admin/migration/verify", h.requireToken(http.HandlerFunc(h.VerifyMigration)))
+	}
+}
+
+// PolicyStatsResponse is code generation statistics of a policy
+type PolicyStatsResponse struct {
+	Policy         string  `json:"policy"`
+	Length         int     `json:"length"`
+	Capacity       float64 `json:"capacity"`
+	MaxUtilisation float64 `json:"max_utilisation"`
+	Attempts       int64   `json:"attempts"`
+	Collisions     int64   `json:"collisions"`
+	CollisionRate  float64 `json:"collision_rate"`
+}
+
+// StatsResponse is the response body of the stats request
+type StatsResponse struct {
+	Links    int64                 `json:"links"`
+	Policies []PolicyStatsResponse `json:"policies"`
+}
+
+// GetStats returns the number of links, keyspace utilisation bounds and
+// collision rates of the code policies
+func (h *AdminHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.urlService.Stats(r.Context())
+	if err != nil {
+		if renderUnavailable(w, err) {
+			return
+		}
+
+		slog.Error("failed to get stats", "error", err)
+		renderError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := StatsResponse{Links: stats.Links}
+	for _, p := range stats.Policies {
+		resp.Policies = append(resp.Policies, PolicyStatsResponse(p))
+	}
+
+	renderJSON(w, resp, http.StatusOK)
+}
+
+// StorageUsageResponse is the response body of the storage usage request
+type StorageUsageResponse struct {
+	Links     int64  `json:"links"`
+	Bytes     int64  `json:"bytes"`
+	MaxLinks  int64  `json:"max_links"`
+	MaxBytes  int64  `json:"max_bytes"`
+	Policy    string `json:"policy"`
+	Evictions int64  `json:"evictions"`
+}
+
+// GetStorageUsage returns the space used by the storage and its limits
+func (h *AdminHandler) GetStorageUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.usage.Usage(r.Context())
+	if err != nil {
+		slog.Error("failed to get storage usage", "error", err)
+		renderError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, StorageUsageResponse(usage), http.StatusOK)
+}
+
+// SnapshotResponse is the response body of the snapshot request
+type SnapshotResponse struct {
+	Links int64 `json:"links"`
+}
+
+// CreateSnapshot saves the stored urls to the snapshot file
+func (h *AdminHandler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	saved, err := h.snapshotter.Snapshot(r.Context())
+	if err != nil {
+		slog.Error("failed to save snapshot", "error", err)
+		renderError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, SnapshotResponse{Links: saved}, http.StatusOK)
+}
+
+// ExportURLs streams all urls in the format of the format query parameter
+func (h *AdminHandler) ExportURLs(w http.ResponseWriter, r *http.Request) {
+	format, err := transfer.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		renderError(w, "Unknown format", http.StatusBadRequest)
+		return
+	}
+
+	extendDeadlines(w, transferTimeout)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=urls.%s", format))
+
+	exported, err := transfer.Export(r.Context(), h.exporter, w, format)
+	if err != nil {
+		// the status is already sent, the client gets a truncated file
+		slog.Error("failed to export urls", "error", err, "exported", exported)
+		return
+	}
+
+	slog.Info("exported urls", "count", exported, "format", format)
+}
+
+// extendDeadlines lets the request be read and answered within timeout
+// instead of the server timeouts
+func extendDeadlines(w http.ResponseWriter, timeout time.Duration) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(timeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		slog.Warn("failed to extend the read deadline", "error", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		slog.Warn("failed to extend the write deadline", "error", err)
+	}
+}
+
+// RowErrorResponse is a row which was not imported
+type RowErrorResponse struct {
+	Line     int64  `json:"line"`
+	ShortURL string `json:"short_url,omitempty"`
+	Error    string `json:"error"`
+}
+
+// ImportResponse is the response body of the import request
+type ImportResponse struct {
+	Imported  int64              `json:"imported"`
+	Conflicts int64              `json:"conflicts"`
+	Invalid   int64              `json:"invalid"`
+	Errors    []RowErrorResponse `json:"errors,omitempty"`
+}
+
+// ImportURLs saves urls from the request body in the format of the format
+// query parameter keeping their short urls
+func (h *AdminHandler) ImportURLs(w http.ResponseWriter, r *http.Request) {
+	format, err := transfer.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		renderError(w, "Unknown format", http.StatusBadRequest)
+		return
+	}
+
+	extendDeadlines(w, transferTimeout)
+	ctx := service.WithRequestHost(r.Context(), r.Host)
+	report, err := transfer.Import(ctx, h.importer, r.Body, format, h.importOpts...)
+	if err != nil {
+		if renderUnavailable(w, err) {
+			return
+		}
+
+		if errors.Is(err, transfer.ErrInvalidFile) {
+			renderError(w, validationMessage(err, transfer.ErrInvalidFile), http.StatusBadRequest)
+			return
+		}
+
+		slog.Error("failed to import urls", "error", err, "imported", report.Imported)
+		renderError(w, "Failed to import URLs", http.StatusInternalServerError)
+		return
+	}
+
+	resp := ImportResponse{
+		Imported:  report.Imported,
+		Conflicts: report.Conflicts,
+		Invalid:   report.Invalid,
+	}
+	for _, e := range report.Errors {
+		resp.Errors = append(resp.Errors, RowErrorResponse(e))
+	}
+
+	renderJSON(w, resp, http.StatusOK)
+}
+
+// ChecksumResponse is the checksum of the urls of a storage
+type ChecksumResponse struct {
+	Links  int64  `json:"links"`
+	Digest string `json:"digest"`
+}
+
+// ParityResponse is the comparison of the source and destination storages
+type ParityResponse struct {
+	Source      ChecksumResponse `json:"source"`
+	Destination ChecksumResponse `json:"destination"`
+	Match       bool             `json:"match"`
+}
+
+// MigrationResponse is the response body of the migration status request
+type MigrationResponse struct {
+	Running   bool            `json:"running"`
+	LastID    int64           `json:"last_id"`
+	Copied    int64           `json:"copied"`
+	Present   int64           `json:"present"`
+	Conflicts int64           `json:"conflicts"`
+	Mirrored  int64           `json:"mirrored"`
+	Failures  int64           `json:"mirror_failures"`
+	Parity    *ParityResponse `json:"parity,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// GetMigration returns the progress of the migration and the last parity check
+func (h *AdminHandler) GetMigration(w http.ResponseWriter, r *http.Request) {
+	status := h.migrator.Status()
+
+	resp := MigrationResponse{
+		Running:   status.Running,
+		LastID:    status.Checkpoint.LastID,
+		Copied:    status.Checkpoint.Copied,
+		Present:   status.Checkpoint.Present,
+		Conflicts: status.Checkpoint.Conflicts,
+		Mirrored:  metrics.MigrationMirrored.Value(),
+		Failures:  metrics.MigrationMirrorFailures.Value(),
+		Error:     status.Error,
+	}
+	if status.Parity != nil {
+		parity := newParityResponse(*status.Parity)
+		resp.Parity = &parity
+	}
+
+	renderJSON(w, resp, http.StatusOK)
+}
+
+// VerifyMigration compares the checksums of the source and destination storages
+func (h *AdminHandler) VerifyMigration(w http.ResponseWriter, r *http.Request) {
+	parity, err := h.migrator.Verify(r.Context())
+	if err != nil {
+		if renderUnavailable(w, err) {
+			return
+		}
+
+		slog.Error("failed to verify migration", "error", err)
+		renderError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, newParityResponse(parity), http.StatusOK)
+}
+
+func newParityResponse(parity migrator.Parity) ParityResponse {
+	return ParityResponse{
+		Source:      ChecksumResponse{Links: parity.Source.Count, Digest: parity.Source.Digest},
+		Destination: ChecksumResponse{Links: parity.Destination.Count, Digest: parity.Destination.Digest},
+		Match:       parity.Match,
+	}
+}
+
+// requireToken rejects requests without the admin bearer token
+func (h *AdminHandler) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			renderError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -6,7 +6,9 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hard-gainer/url-shortener/internal/service"
 	"github.com/hard-gainer/url-shortener/internal/storage"
@@ -33,9 +35,16 @@ func (h *URLHandler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /{shortURL}", h.HandleRequest)
 }
 
+// APIKeyHeader is a header with the API key of the client
+const APIKeyHeader = "X-API-Key"
+
 // ShortenURLRequest is the request body for shortening a URL
 type ShortenURLRequest struct {
-	URL string `json:"url"`
+	URL       string `json:"url"`
+	Alias     string `json:"alias,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// ForceNew creates a new code even if the URL is already shortened
+	ForceNew bool `json:"force_new,omitempty"`
 }
 
 // ShortenURLResponse is the response body for shortening a URL
@@ -69,8 +78,41 @@ func (h *URLHandler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shortURL, err := h.urlService.ShortenURL(r.Context(), originalURL)
+	opts := []service.ShortenOption{
+		service.WithAlias(strings.TrimSpace(req.Alias)),
+		service.WithNamespace(strings.TrimSpace(req.Namespace)),
+		service.WithAPIKey(r.Header.Get(APIKeyHeader)),
+	}
+	if req.ForceNew {
+		opts = append(opts, service.WithForceNew())
+	}
+
+	ctx := service.WithRequestHost(r.Context(), r.Host)
+	shortURL, err := h.urlService.ShortenURL(ctx, originalURL, opts...)
 	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidAlias):
+			renderError(w, validationMessage(err, service.ErrInvalidAlias), http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrInvalidDestination):
+			renderError(w, validationMessage(err, service.ErrInvalidDestination), http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrUnknownNamespace):
+			renderError(w, "Unknown namespace", http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrAliasTaken):
+			renderError(w, "Alias is already taken", http.StatusConflict)
+			return
+		case errors.Is(err, storage.ErrOriginalURLExists):
+			renderError(w, "URL is already shortened with another code", http.StatusConflict)
+			return
+		case errors.Is(err, storage.ErrStorageFull):
+			renderError(w, "Storage is full", http.StatusInsufficientStorage)
+			return
+		}
+		if renderUnavailable(w, err) {
+			return
+		}
 		slog.Error("failed to shorten URL", "error", err)
 		renderError(w, "Failed to shorten URL", http.StatusInternalServerError)
 		return
@@ -100,6 +142,9 @@ func (h *URLHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 			http.NotFound(w, r)
 			return
 		}
+		if renderUnavailable(w, err) {
+			return
+		}
 
 		slog.Error("failed to get original URL", "error", err, "short_url", shortURL)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -123,6 +168,9 @@ func (h *URLHandler) GetURLInfo(w http.ResponseWriter, r *http.Request) {
 			renderError(w, "Short URL not found", http.StatusNotFound)
 			return
 		}
+		if renderUnavailable(w, err) {
+			return
+		}
 
 		slog.Error("failed to get URL info", "error", err, "short_url", shortURL)
 		renderError(w, "Internal Server Error", http.StatusInternalServerError)
@@ -151,3 +199,27 @@ func renderError(w http.ResponseWriter, message string, status int) {
 	resp := ErrorResponse{Error: message}
 	renderJSON(w, resp, status)
 }
+
+// renderUnavailable renders 503 with Retry-After if err reports that
+// the storage is unavailable, it returns false for other errors
+func renderUnavailable(w http.ResponseWriter, err error) bool {
+	var unavailable *storage.UnavailableError
+	if !errors.As(err, &unavailable) {
+		return false
+	}
+
+	seconds := int((unavailable.RetryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	renderError(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+	return true
+}
+
+// validationMessage returns the part of err message starting with
+// the sentinel, so the internal operation names are not exposed
+func validationMessage(err, sentinel error) string {
+	msg := err.Error()
+	if i := strings.Index(msg, sentinel.Error()); i >= 0 {
+		msg = msg[i:]
+	}
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucketIdleTimeout is how long a client bucket is kept after its last request
+const bucketIdleTimeout = 10 * time.Minute
+
+// RateLimiter limits the number of requests per client using token buckets
+type RateLimiter struct {
+	mutex     sync.Mutex
+	rps       float64
+	burst     int
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket is a token bucket of a single client
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// NewRateLimiter creates a new RateLimiter, rps equal to 0 disables limiting
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rps:       rps,
+		burst:     burst,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetLimits changes the limits for all clients. Buckets are kept, so
+// clients don't get a full burst back; tokens above the new burst are
+// dropped. Setting the current limits does nothing.
+func (rl *RateLimiter) SetLimits(rps float64, burst int) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if rl.rps == rps && rl.burst == burst {
+		return
+	}
+
+	rl.rps = rps
+	rl.burst = burst
+	for _, b := range rl.buckets {
+		b.tokens = min(b.tokens, float64(burst))
+	}
+}
+
+// Middleware rejects requests of clients which exceeded the limit
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := rl.allow(clientIP(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			renderError(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from the client bucket, it returns false and
+// the time until the next token if the bucket is empty
+func (rl *RateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if rl.rps <= 0 {
+		return true, 0
+	}
+
+	if now.Sub(rl.lastSweep) > bucketIdleTimeout {
+		for key, b := range rl.buckets {
+			if now.Sub(b.lastSeen) > bucketIdleTimeout {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	b, exists := rl.buckets[client]
+	if !exists {
+		b = &bucket{tokens: float64(rl.burst), lastSeen: now}
+		rl.buckets[client] = b
+	}
+
+	b.tokens += now.Sub(b.lastSeen).Seconds() * rl.rps
+	if b.tokens > float64(rl.burst) {
+		b.tokens = float64(rl.burst)
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rps * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// clientIP returns the address of the client without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
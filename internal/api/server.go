@@ -27,9 +27,10 @@ func NewServer(addr string) *Server {
 	}
 }
 
-// WithMiddleware sets middleware for the server
+// WithMiddleware wraps the server handler with middleware,
+// the middleware added last is executed first
 func (s *Server) WithMiddleware(middleware func(http.Handler) http.Handler) {
-    s.server.Handler = middleware(s.mux)
+	s.server.Handler = middleware(s.server.Handler)
 }
 
 // Run starts the HTTP server
@@ -0,0 +1,92 @@
+// Package bloom contains a concurrent Bloom filter of strings
+package bloom
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"sync/atomic"
+)
+
+// Filter is a set of strings which may report a string it doesn't
+// contain but never misses a string it does. It is safe for concurrent use.
+type Filter struct {
+	bits     []atomic.Uint64
+	size     uint64
+	hashes   int
+	capacity uint64
+	seeds    [2]maphash.Seed
+	added    atomic.Uint64
+}
+
+// New creates a filter which reports strings it doesn't contain with the
+// rate fpRate while it holds up to capacity strings
+func New(capacity uint64, fpRate float64) (*Filter, error) {
+	if capacity == 0 {
+		return nil, fmt.Errorf("capacity must be positive")
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		return nil, fmt.Errorf("false positive rate must be between 0 and 1, got %v", fpRate)
+	}
+
+	size := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	size = (size + 63) / 64 * 64
+	hashes := int(math.Round(float64(size) / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	return &Filter{
+		bits:     make([]atomic.Uint64, size/64),
+		size:     size,
+		hashes:   hashes,
+		capacity: capacity,
+		seeds:    [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}, nil
+}
+
+// Add adds s to the filter
+func (f *Filter) Add(s string) {
+	h1, h2 := f.hash(s)
+	added := false
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.size
+		word, mask := &f.bits[bit/64], uint64(1)<<(bit%64)
+		if word.Load()&mask == 0 && word.Or(mask)&mask == 0 {
+			added = true
+		}
+	}
+	if added {
+		f.added.Add(1)
+	}
+}
+
+// MayContain reports false if s was definitely not added
+func (f *Filter) MayContain(s string) bool {
+	h1, h2 := f.hash(s)
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.size
+		if f.bits[bit/64].Load()&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Capacity returns the number of strings the filter is sized for
+func (f *Filter) Capacity() uint64 {
+	return f.capacity
+}
+
+// Added returns the number of distinct strings added, a string is counted
+// when it sets a bit, so strings added again are not counted and neither
+// are the rare new strings whose bits are all set already
+func (f *Filter) Added() uint64 {
+	return f.added.Load()
+}
+
+// hash returns two independent hashes of s combined into the bit positions,
+// the second one is odd so that positions don't repeat
+func (f *Filter) hash(s string) (uint64, uint64) {
+	return maphash.String(f.seeds[0], s), maphash.String(f.seeds[1], s) | 1
+}
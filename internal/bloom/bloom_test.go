@@ -0,0 +1,81 @@
+package bloom
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_Validation(t *testing.T) {
+	_, err := New(0, 0.01)
+	assert.Error(t, err)
+
+	_, err = New(100, 0)
+	assert.Error(t, err)
+
+	_, err = New(100, 1)
+	assert.Error(t, err)
+}
+
+func TestFilter_NoFalseNegatives(t *testing.T) {
+	f, err := New(10000, 0.01)
+	require.NoError(t, err)
+
+	for i := 0; i < 10000; i++ {
+		f.Add(fmt.Sprintf("code%d", i))
+	}
+	for i := 0; i < 10000; i++ {
+		assert.True(t, f.MayContain(fmt.Sprintf("code%d", i)))
+	}
+	assert.InDelta(t, 10000, f.Added(), 100)
+
+	added := f.Added()
+	for i := 0; i < 100; i++ {
+		f.Add(fmt.Sprintf("code%d", i))
+	}
+	assert.Equal(t, added, f.Added(), "strings added again are not counted")
+}
+
+func TestFilter_FalsePositiveRate(t *testing.T) {
+	const n = 10000
+	f, err := New(n, 0.01)
+	require.NoError(t, err)
+
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprintf("code%d", i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if f.MayContain(fmt.Sprintf("missing%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, float64(falsePositives)/n, 0.02)
+}
+
+func TestFilter_ConcurrentAdd(t *testing.T) {
+	f, err := New(8000, 0.01)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				f.Add(fmt.Sprintf("code%d-%d", w, i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < 8; w++ {
+		for i := 0; i < 1000; i++ {
+			require.True(t, f.MayContain(fmt.Sprintf("code%d-%d", w, i)))
+		}
+	}
+}
@@ -1,21 +1,56 @@
 package config
 
 import (
+	"fmt"
+	"log/slog"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// EnvFile is a file the config is read from
+const EnvFile = ".env"
+
+// processEnv are the variables set in the environment of the process before
+// EnvFile is loaded, they take precedence over the file at startup and on reload
+var processEnv = environKeys()
+
+// environKeys returns the names of the variables of the process environment
+func environKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		if key, _, ok := strings.Cut(kv, "="); ok {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
 // Config is a main config
 type Config struct {
 	AppConfig
 	DBConfig
+	MemoryConfig
+	BreakerConfig
+	GeneratorConfig
+	CodeFilterConfig
+	URLConfig
+	RuntimeConfig
 }
 
 // AppConfig is a config with specific app information
 type AppConfig struct {
+	// URL is the public url of the service, short urls in responses are
+	// absolute with it and relative if it is empty
 	URL  string
 	Port string
+	// AdminToken is a bearer token of the admin endpoints,
+	// they are disabled if it is empty
+	AdminToken string
 }
 
 // DBConfig  is a config with specific database information
@@ -26,35 +61,538 @@ type DBConfig struct {
 	Host     string
 	Port     string
 	Name     string
+
+	// MaxConns is a maximum size of the connection pool, 0 means pgx default
+	MaxConns int32
+	// MinConns is a number of connections kept open even when idle
+	MinConns int32
+	// MaxConnLifetime is a duration after which a connection is recreated
+	MaxConnLifetime time.Duration
+	// MaxConnIdleTime is a duration after which an idle connection is closed
+	MaxConnIdleTime time.Duration
+	// HealthCheckPeriod is an interval between health checks of idle connections
+	HealthCheckPeriod time.Duration
+	// ReadTimeout limits a single read query, 0 means no limit
+	ReadTimeout time.Duration
+	// WriteTimeout limits a single write transaction, 0 means no limit
+	WriteTimeout time.Duration
+	// PoolStatsInterval is an interval of publishing pool statistics
+	PoolStatsInterval time.Duration
+
+	// ReplicaURLs are connection strings of read replicas
+	ReplicaURLs []string
+	// ReadYourWritesWindow is a duration after a write during which
+	// the written url is read from the primary
+	ReadYourWritesWindow time.Duration
+	// ReplicaRetryInterval is a duration a failed replica is not used for
+	ReplicaRetryInterval time.Duration
+
+	// RetryMaxAttempts is a maximum number of attempts of an operation
+	// failed with a transient error, 1 disables retries
+	RetryMaxAttempts int
+	// RetryBaseDelay is a delay before the first retry, it doubles every attempt
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay is a maximum delay between retries
+	RetryMaxDelay time.Duration
+}
+
+// MemoryConfig is a config of the in-memory storage
+type MemoryConfig struct {
+	// Shards is a number of independently locked parts of the storage,
+	// 0 means the default
+	Shards int
+	// MaxLinks is a maximum number of stored urls, 0 means no limit
+	MaxLinks int
+	// MaxBytes is a maximum approximate memory used by urls, 0 means no limit
+	MaxBytes int
+	// EvictionPolicy is an action taken when the storage is full:
+	// reject, lru or oldest
+	EvictionPolicy string
+	// SnapshotFile is a file the storage is restored from at startup
+	// and saved to on shutdown, snapshots are disabled if it is empty
+	SnapshotFile string
+}
+
+// BreakerConfig is a config of the circuit breaker around the storage
+type BreakerConfig struct {
+	// FailureThreshold is a number of consecutive failures which opens
+	// the breaker, 0 disables the breaker
+	FailureThreshold int
+	// OpenTimeout is a duration the breaker stays open before probing the storage
+	OpenTimeout time.Duration
+	// HalfOpenProbes is a number of successful probes which close the breaker
+	HalfOpenProbes int
+	// CacheSize is a number of recently read urls served while the breaker is open
+	CacheSize int
+}
+
+// GeneratorConfig is a config of the short url generation
+type GeneratorConfig struct {
+	// Strategy is a name of the code generator: random, sequence or snowflake
+	Strategy string
+	// Key is a secret which shuffles sequence-based codes
+	Key string
+	// NodeID is an id of this instance used by the snowflake generator,
+	// it must be unique among instances sharing the storage
+	NodeID int
+
+	// PoliciesFile is a path to the JSON file with code policies,
+	// the default policy is used if it is empty
+	PoliciesFile string
+	// CaseInsensitive makes codes differing only in case the same code,
+	// generated codes are lowercase then
+	CaseInsensitive bool
+	// ValidateCodes answers requests of codes no policy could issue with
+	// not found without querying the storage
+	ValidateCodes bool
+	// ReservedPaths are paths which are never issued as codes,
+	// the default list is used if it is empty
+	ReservedPaths []string
+
+	// MaxLength is a length up to which generated codes are lengthened when
+	// they collide too often, it applies to the policies which don't set
+	// their own max length; 0 disables it
+	MaxLength int
+	// CollisionThreshold is a share of colliding codes at which codes are lengthened
+	CollisionThreshold float64
+	// CollisionWindow is a number of recent shortenings the collision rate is computed over
+	CollisionWindow int
+	// KeyspaceStatsInterval is an interval of publishing keyspace utilisation bounds
+	KeyspaceStatsInterval time.Duration
+
+	// PoolSize is a number of codes reserved ahead of time, 0 disables the pool
+	PoolSize int
+	// PoolLowWater is a number of codes left at which the pool is refilled
+	PoolLowWater int
+	// PoolBatchSize is a number of codes reserved at once
+	PoolBatchSize int
+	// PoolReservationTTL is an age at which reserved codes are freed,
+	// e.g. the ones left by a crashed instance; 0 keeps them forever
+	PoolReservationTTL time.Duration
+}
+
+// CodeFilterConfig is a config of the Bloom filter of stored short urls
+type CodeFilterConfig struct {
+	// FalsePositiveRate is a share of missing codes looked up in the storage
+	// anyway, 0 disables the filter
+	FalsePositiveRate float64
+	// Capacity is a number of urls the filter is sized for at startup,
+	// it grows with the storage
+	Capacity int
+	// RefreshInterval is an interval of adding urls created by other
+	// instances and imports
+	RefreshInterval time.Duration
+}
+
+// URLConfig is a config of the handling of original urls
+type URLConfig struct {
+	// StripTrackingParams removes tracking query parameters when urls
+	// are compared for deduplication
+	StripTrackingParams bool
+	// TrackingParams are the removed parameters, a trailing * matches any suffix,
+	// the default list is used if it is empty
+	TrackingParams []string
+
+	// AllowedSchemes are the schemes of urls which may be shortened
+	AllowedSchemes []string
+	// AllowedHosts are patterns of hosts which may be shortened, * matches
+	// any part of a name; any host is allowed if it is empty
+	AllowedHosts []string
+	// DeniedHosts are patterns of hosts which may not be shortened
+	DeniedHosts []string
+	// AllowPrivateAddresses allows urls pointing at loopback and private addresses
+	AllowPrivateAddresses bool
+	// ResolveHosts resolves host names to reject names of private addresses
+	ResolveHosts bool
+	// MaxLength is a maximum length of a shortened url, 0 means no limit
+	MaxLength int
+}
+
+// RuntimeConfig is a config with settings which can be changed
+// without restarting the service
+type RuntimeConfig struct {
+	LogLevel       slog.Level
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// BlocklistPath is a file with words which are never issued as codes,
+	// the file is read again on every reload
+	BlocklistPath string
 }
 
 // InitConfig creates a new Config
 func InitConfig() *Config {
-	if err := godotenv.Load(); err != nil {
+	if err := godotenv.Load(EnvFile); err != nil {
 		panic("No .env file found")
 	}
 
-	dbURL := os.Getenv("DB_URL")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
 	appPort := os.Getenv("APP_PORT")
 
+	dbCfg, err := loadDBConfig(os.Getenv)
+	if err != nil {
+		panic(err)
+	}
+
+	memoryCfg := MemoryConfig{
+		EvictionPolicy: os.Getenv("MEMORY_EVICTION_POLICY"),
+		SnapshotFile:   os.Getenv("MEMORY_SNAPSHOT_FILE"),
+	}
+	if memoryCfg.Shards, err = parseInt(os.Getenv, "MEMORY_SHARDS", 0); err != nil {
+		panic(err)
+	}
+	if memoryCfg.MaxLinks, err = parseInt(os.Getenv, "MEMORY_MAX_LINKS", 0); err != nil {
+		panic(err)
+	}
+	if memoryCfg.MaxBytes, err = parseInt(os.Getenv, "MEMORY_MAX_BYTES", 0); err != nil {
+		panic(err)
+	}
+
+	breakerCfg, err := loadBreakerConfig(os.Getenv)
+	if err != nil {
+		panic(err)
+	}
+
+	generatorCfg := GeneratorConfig{
+		Strategy:      os.Getenv("CODE_GENERATOR"),
+		Key:           os.Getenv("CODE_GENERATOR_KEY"),
+		PoliciesFile:  os.Getenv("CODE_POLICIES_FILE"),
+		ReservedPaths: parseList(os.Getenv("CODE_RESERVED_PATHS")),
+	}
+	if generatorCfg.Strategy == "" {
+		generatorCfg.Strategy = "random"
+	}
+	if generatorCfg.CaseInsensitive, err = parseBool(os.Getenv, "CODE_CASE_INSENSITIVE", false); err != nil {
+		panic(err)
+	}
+	if generatorCfg.ValidateCodes, err = parseBool(os.Getenv, "CODE_VALIDATION", true); err != nil {
+		panic(err)
+	}
+	if generatorCfg.NodeID, err = parseInt(os.Getenv, "NODE_ID", 0); err != nil {
+		panic(err)
+	}
+	if generatorCfg.MaxLength, err = parseInt(os.Getenv, "CODE_MAX_LENGTH", 0); err != nil {
+		panic(err)
+	}
+	if generatorCfg.CollisionThreshold, err = parseFloat(os.Getenv, "CODE_COLLISION_THRESHOLD", 0); err != nil {
+		panic(err)
+	}
+	if generatorCfg.CollisionWindow, err = parseInt(os.Getenv, "CODE_COLLISION_WINDOW", 1000); err != nil {
+		panic(err)
+	}
+	if generatorCfg.KeyspaceStatsInterval, err = parseDuration(os.Getenv, "CODE_KEYSPACE_STATS_INTERVAL", time.Minute); err != nil {
+		panic(err)
+	}
+	if generatorCfg.PoolSize, err = parseInt(os.Getenv, "CODE_POOL_SIZE", 0); err != nil {
+		panic(err)
+	}
+	if generatorCfg.PoolLowWater, err = parseInt(os.Getenv, "CODE_POOL_LOW_WATER", generatorCfg.PoolSize/4); err != nil {
+		panic(err)
+	}
+	if generatorCfg.PoolBatchSize, err = parseInt(os.Getenv, "CODE_POOL_BATCH_SIZE", 100); err != nil {
+		panic(err)
+	}
+	if generatorCfg.PoolReservationTTL, err = parseDuration(os.Getenv, "CODE_POOL_RESERVATION_TTL", 24*time.Hour); err != nil {
+		panic(err)
+	}
+
+	codeFilterCfg, err := loadCodeFilterConfig(os.Getenv)
+	if err != nil {
+		panic(err)
+	}
+
+	urlCfg := URLConfig{
+		TrackingParams: parseList(os.Getenv("URL_TRACKING_PARAMS")),
+	}
+	if urlCfg.StripTrackingParams, err = parseBool(os.Getenv, "URL_STRIP_TRACKING_PARAMS", false); err != nil {
+		panic(err)
+	}
+	if err := loadDestinationConfig(os.Getenv, &urlCfg); err != nil {
+		panic(err)
+	}
+
+	runtimeCfg, err := loadRuntimeConfig(os.Getenv)
+	if err != nil {
+		panic(err)
+	}
+
 	cfg := &Config{
 		AppConfig: AppConfig{
-			Port: appPort,
-		},
-		DBConfig: DBConfig{
-			URL:      dbURL,
-			User:     dbUser,
-			Password: dbPassword,
-			Host:     dbHost,
-			Port:     dbPort,
-			Name:     dbName,
+			URL:        strings.TrimSuffix(os.Getenv("APP_URL"), "/"),
+			Port:       appPort,
+			AdminToken: os.Getenv("ADMIN_TOKEN"),
 		},
+		DBConfig:         dbCfg,
+		MemoryConfig:     memoryCfg,
+		BreakerConfig:    breakerCfg,
+		GeneratorConfig:  generatorCfg,
+		CodeFilterConfig: codeFilterCfg,
+		URLConfig:        urlCfg,
+		RuntimeConfig:    runtimeCfg,
 	}
 
 	return cfg
 }
+
+// loadDBConfig reads the DBConfig using getenv
+func loadDBConfig(getenv func(string) string) (DBConfig, error) {
+	const op = "config.loadDBConfig"
+
+	cfg := DBConfig{
+		URL:      getenv("DB_URL"),
+		User:     getenv("DB_USER"),
+		Password: getenv("DB_PASSWORD"),
+		Host:     getenv("DB_HOST"),
+		Port:     getenv("DB_PORT"),
+		Name:     getenv("DB_NAME"),
+	}
+
+	cfg.ReplicaURLs = parseList(getenv("DB_REPLICA_URLS"))
+
+	maxConns, err := parseInt(getenv, "DB_MAX_CONNS", 0)
+	if err != nil {
+		return DBConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	minConns, err := parseInt(getenv, "DB_MIN_CONNS", 0)
+	if err != nil {
+		return DBConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if maxConns < 0 || minConns < 0 || (maxConns > 0 && minConns > maxConns) {
+		return DBConfig{}, fmt.Errorf("%s: invalid pool size: min %d, max %d", op, minConns, maxConns)
+	}
+	cfg.MaxConns = int32(maxConns)
+	cfg.MinConns = int32(minConns)
+
+	retryAttempts, err := parseInt(getenv, "DB_RETRY_MAX_ATTEMPTS", 3)
+	if err != nil {
+		return DBConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if retryAttempts < 1 {
+		return DBConfig{}, fmt.Errorf("%s: DB_RETRY_MAX_ATTEMPTS must be at least 1, got %d", op, retryAttempts)
+	}
+	cfg.RetryMaxAttempts = retryAttempts
+
+	durations := []struct {
+		key    string
+		target *time.Duration
+		def    time.Duration
+	}{
+		{"DB_MAX_CONN_LIFETIME", &cfg.MaxConnLifetime, 0},
+		{"DB_MAX_CONN_IDLE_TIME", &cfg.MaxConnIdleTime, 0},
+		{"DB_HEALTH_CHECK_PERIOD", &cfg.HealthCheckPeriod, 0},
+		{"DB_READ_TIMEOUT", &cfg.ReadTimeout, 2 * time.Second},
+		{"DB_WRITE_TIMEOUT", &cfg.WriteTimeout, 5 * time.Second},
+		{"DB_POOL_STATS_INTERVAL", &cfg.PoolStatsInterval, 15 * time.Second},
+		{"DB_READ_YOUR_WRITES_WINDOW", &cfg.ReadYourWritesWindow, 5 * time.Second},
+		{"DB_REPLICA_RETRY_INTERVAL", &cfg.ReplicaRetryInterval, 10 * time.Second},
+		{"DB_RETRY_BASE_DELAY", &cfg.RetryBaseDelay, 50 * time.Millisecond},
+		{"DB_RETRY_MAX_DELAY", &cfg.RetryMaxDelay, time.Second},
+	}
+	for _, d := range durations {
+		value, err := parseDuration(getenv, d.key, d.def)
+		if err != nil {
+			return DBConfig{}, fmt.Errorf("%s: %w", op, err)
+		}
+		*d.target = value
+	}
+
+	return cfg, nil
+}
+
+// loadBreakerConfig reads the BreakerConfig using getenv
+func loadBreakerConfig(getenv func(string) string) (BreakerConfig, error) {
+	const op = "config.loadBreakerConfig"
+	var cfg BreakerConfig
+	var err error
+
+	if cfg.FailureThreshold, err = parseInt(getenv, "STORAGE_BREAKER_FAILURE_THRESHOLD", 5); err != nil {
+		return BreakerConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.OpenTimeout, err = parseDuration(getenv, "STORAGE_BREAKER_OPEN_TIMEOUT", 10*time.Second); err != nil {
+		return BreakerConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.HalfOpenProbes, err = parseInt(getenv, "STORAGE_BREAKER_HALF_OPEN_PROBES", 3); err != nil {
+		return BreakerConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.CacheSize, err = parseInt(getenv, "STORAGE_BREAKER_CACHE_SIZE", 10000); err != nil {
+		return BreakerConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if cfg.FailureThreshold < 0 || cfg.HalfOpenProbes < 1 || cfg.CacheSize < 0 {
+		return BreakerConfig{}, fmt.Errorf("%s: invalid breaker settings %+v", op, cfg)
+	}
+
+	return cfg, nil
+}
+
+// loadCodeFilterConfig reads the CodeFilterConfig using getenv
+func loadCodeFilterConfig(getenv func(string) string) (CodeFilterConfig, error) {
+	const op = "config.loadCodeFilterConfig"
+	var cfg CodeFilterConfig
+	var err error
+
+	if cfg.FalsePositiveRate, err = parseFloat(getenv, "CODE_FILTER_FALSE_POSITIVE_RATE", 0); err != nil {
+		return CodeFilterConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.Capacity, err = parseInt(getenv, "CODE_FILTER_CAPACITY", 1000000); err != nil {
+		return CodeFilterConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.RefreshInterval, err = parseDuration(getenv, "CODE_FILTER_REFRESH_INTERVAL", 30*time.Second); err != nil {
+		return CodeFilterConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if cfg.FalsePositiveRate < 0 || cfg.FalsePositiveRate >= 1 {
+		return CodeFilterConfig{}, fmt.Errorf("%s: CODE_FILTER_FALSE_POSITIVE_RATE must be in [0, 1), got %v", op, cfg.FalsePositiveRate)
+	}
+	if cfg.Capacity < 1 {
+		return CodeFilterConfig{}, fmt.Errorf("%s: CODE_FILTER_CAPACITY must be positive, got %d", op, cfg.Capacity)
+	}
+
+	return cfg, nil
+}
+
+// loadDestinationConfig reads the rules of shortened urls into cfg using getenv
+func loadDestinationConfig(getenv func(string) string, cfg *URLConfig) error {
+	const op = "config.loadDestinationConfig"
+	var err error
+
+	cfg.AllowedSchemes = parseList(getenv("URL_ALLOWED_SCHEMES"))
+	if len(cfg.AllowedSchemes) == 0 {
+		cfg.AllowedSchemes = []string{"http", "https"}
+	}
+	cfg.AllowedHosts = parseList(getenv("URL_ALLOWED_HOSTS"))
+	cfg.DeniedHosts = parseList(getenv("URL_DENIED_HOSTS"))
+
+	if cfg.AllowPrivateAddresses, err = parseBool(getenv, "URL_ALLOW_PRIVATE_ADDRESSES", false); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.ResolveHosts, err = parseBool(getenv, "URL_RESOLVE_HOSTS", false); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.MaxLength, err = parseInt(getenv, "URL_MAX_LENGTH", 4096); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if cfg.MaxLength < 0 {
+		return fmt.Errorf("%s: URL_MAX_LENGTH must not be negative, got %d", op, cfg.MaxLength)
+	}
+
+	return nil
+}
+
+// loadRuntimeConfig reads and validates the RuntimeConfig using getenv
+func loadRuntimeConfig(getenv func(string) string) (RuntimeConfig, error) {
+	const op = "config.loadRuntimeConfig"
+	var cfg RuntimeConfig
+
+	if level := getenv("LOG_LEVEL"); level != "" {
+		if err := cfg.LogLevel.UnmarshalText([]byte(level)); err != nil {
+			return RuntimeConfig{}, fmt.Errorf("%s: invalid LOG_LEVEL %q: %w", op, level, err)
+		}
+	}
+
+	rps, err := parseFloat(getenv, "RATE_LIMIT_RPS", 0)
+	if err != nil {
+		return RuntimeConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	cfg.RateLimitRPS = rps
+
+	defaultBurst := 0
+	if rps > 0 {
+		defaultBurst = max(1, int(math.Ceil(rps)))
+	}
+	burst, err := parseInt(getenv, "RATE_LIMIT_BURST", defaultBurst)
+	if err != nil {
+		return RuntimeConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+	cfg.RateLimitBurst = burst
+
+	cfg.BlocklistPath = strings.TrimSpace(getenv("BLOCKLIST_FILE"))
+
+	if err := cfg.Validate(); err != nil {
+		return RuntimeConfig{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that the RuntimeConfig values are usable
+func (cfg RuntimeConfig) Validate() error {
+	if cfg.RateLimitRPS < 0 {
+		return fmt.Errorf("RATE_LIMIT_RPS must not be negative, got %v", cfg.RateLimitRPS)
+	}
+	if cfg.RateLimitBurst < 0 {
+		return fmt.Errorf("RATE_LIMIT_BURST must not be negative, got %d", cfg.RateLimitBurst)
+	}
+	if cfg.RateLimitRPS > 0 && cfg.RateLimitBurst < 1 {
+		return fmt.Errorf("RATE_LIMIT_BURST must be at least 1 when rate limiting is enabled")
+	}
+	return nil
+}
+
+// parseInt reads an integer variable, returning def if it is not set
+func parseInt(getenv func(string) string, key string, def int) (int, error) {
+	value := strings.TrimSpace(getenv(key))
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return n, nil
+}
+
+// parseList splits a comma-separated value skipping empty items
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseBool reads a boolean variable, returning def if it is not set
+func parseBool(getenv func(string) string, key string, def bool) (bool, error) {
+	value := strings.TrimSpace(getenv(key))
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return b, nil
+}
+
+// parseFloat reads a float variable, returning def if it is not set
+func parseFloat(getenv func(string) string, key string, def float64) (float64, error) {
+	value := strings.TrimSpace(getenv(key))
+	if value == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return f, nil
+}
+
+// parseDuration reads a duration variable, returning def if it is not set
+func parseDuration(getenv func(string) string, key string, def time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(getenv(key))
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s must not be negative, got %s", key, value)
+	}
+	return d, nil
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/joho/godotenv"
+)
+
+// Reloader keeps the current RuntimeConfig and replaces it on reload
+type Reloader struct {
+	path        string
+	processEnv  map[string]bool
+	current     atomic.Pointer[RuntimeConfig]
+	mutex       sync.Mutex
+	subscribers []func(RuntimeConfig)
+}
+
+// Change describes a single setting changed by a reload
+type Change struct {
+	Field string
+	Old   any
+	New   any
+}
+
+// NewReloader creates a new Reloader which reads the file at path
+func NewReloader(path string, initial RuntimeConfig) *Reloader {
+	r := &Reloader{path: path, processEnv: processEnv}
+	r.current.Store(&initial)
+	return r
+}
+
+// Current returns the RuntimeConfig which is in effect
+func (r *Reloader) Current() RuntimeConfig {
+	return *r.current.Load()
+}
+
+// Subscribe registers fn to be called with the new RuntimeConfig after every
+// successful reload
+func (r *Reloader) Subscribe(fn func(RuntimeConfig)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Reload re-reads the config file and swaps the RuntimeConfig if it is valid.
+// As at startup, variables set in the process environment take precedence
+// over the file, other settings are read from the file only, so a setting
+// removed from the file returns to its default.
+func (r *Reloader) Reload() ([]Change, error) {
+	const op = "config.Reloader.Reload"
+
+	env, err := godotenv.Read(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	getenv := func(key string) string {
+		if r.processEnv[key] {
+			return os.Getenv(key)
+		}
+		return env[key]
+	}
+
+	next, err := loadRuntimeConfig(getenv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	prev := r.current.Swap(&next)
+	changes := diff(*prev, next)
+
+	for _, c := range changes {
+		slog.Info("config changed", "field", c.Field, "old", c.Old, "new", c.New)
+	}
+	if len(changes) == 0 {
+		slog.Info("config reloaded without changes")
+		return nil, nil
+	}
+
+	for _, fn := range r.subscribers {
+		fn(next)
+	}
+
+	return changes, nil
+}
+
+// diff returns the list of settings which differ between prev and next
+func diff(prev, next RuntimeConfig) []Change {
+	var changes []Change
+
+	add := func(field string, old, new any) {
+		if old != new {
+			changes = append(changes, Change{Field: field, Old: old, New: new})
+		}
+	}
+
+	add("LOG_LEVEL", prev.LogLevel.String(), next.LogLevel.String())
+	add("RATE_LIMIT_RPS", prev.RateLimitRPS, next.RateLimitRPS)
+	add("RATE_LIMIT_BURST", prev.RateLimitBurst, next.RateLimitBurst)
+	add("BLOCKLIST_FILE", prev.BlocklistPath, next.BlocklistPath)
+
+	return changes
+}
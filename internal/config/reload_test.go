@@ -0,0 +1,111 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeEnvFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestReloader_Reload_AppliesChanges(t *testing.T) {
+	path := writeEnvFile(t, "LOG_LEVEL=debug\nRATE_LIMIT_RPS=5\nRATE_LIMIT_BURST=10\n")
+	reloader := NewReloader(path, RuntimeConfig{LogLevel: slog.LevelInfo})
+
+	var notified RuntimeConfig
+	reloader.Subscribe(func(rc RuntimeConfig) { notified = rc })
+
+	changes, err := reloader.Reload()
+
+	require.NoError(t, err)
+	assert.Len(t, changes, 3)
+	assert.Equal(t, slog.LevelDebug, reloader.Current().LogLevel)
+	assert.Equal(t, 5.0, reloader.Current().RateLimitRPS)
+	assert.Equal(t, 10, reloader.Current().RateLimitBurst)
+	assert.Equal(t, reloader.Current(), notified)
+}
+
+func TestReloader_Reload_NoChanges(t *testing.T) {
+	path := writeEnvFile(t, "LOG_LEVEL=info\n")
+	reloader := NewReloader(path, RuntimeConfig{LogLevel: slog.LevelInfo})
+
+	called := false
+	reloader.Subscribe(func(RuntimeConfig) { called = true })
+
+	changes, err := reloader.Reload()
+
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.False(t, called)
+}
+
+func TestReloader_Reload_InvalidConfigKeepsCurrent(t *testing.T) {
+	path := writeEnvFile(t, "LOG_LEVEL=loud\n")
+	initial := RuntimeConfig{LogLevel: slog.LevelWarn, RateLimitRPS: 1, RateLimitBurst: 1}
+	reloader := NewReloader(path, initial)
+
+	_, err := reloader.Reload()
+
+	require.Error(t, err)
+	assert.Equal(t, initial, reloader.Current())
+}
+
+func TestReloader_Reload_BlocklistPath(t *testing.T) {
+	path := writeEnvFile(t, "BLOCKLIST_FILE=blocklist.txt\n")
+	reloader := NewReloader(path, RuntimeConfig{})
+
+	changes, err := reloader.Reload()
+
+	require.NoError(t, err)
+	assert.Equal(t, []Change{{Field: "BLOCKLIST_FILE", Old: "", New: "blocklist.txt"}}, changes)
+	assert.Equal(t, "blocklist.txt", reloader.Current().BlocklistPath)
+}
+
+func TestReloader_Reload_MissingFile(t *testing.T) {
+	reloader := NewReloader(filepath.Join(t.TempDir(), "missing.env"), RuntimeConfig{})
+
+	_, err := reloader.Reload()
+
+	require.Error(t, err)
+}
+
+func TestRuntimeConfig_Validate(t *testing.T) {
+	assert.NoError(t, RuntimeConfig{}.Validate())
+	assert.NoError(t, RuntimeConfig{RateLimitRPS: 2, RateLimitBurst: 1}.Validate())
+	assert.Error(t, RuntimeConfig{RateLimitRPS: -1}.Validate())
+	assert.Error(t, RuntimeConfig{RateLimitRPS: 2, RateLimitBurst: 0}.Validate())
+}
+
+func TestReloader_Reload_ProcessEnvTakesPrecedence(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("RATE_LIMIT_RPS", "7")
+	path := writeEnvFile(t, "LOG_LEVEL=debug\n")
+	reloader := NewReloader(path, RuntimeConfig{LogLevel: slog.LevelWarn})
+	reloader.processEnv = map[string]bool{"LOG_LEVEL": true}
+
+	_, err := reloader.Reload()
+
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, reloader.Current().LogLevel, "the process environment wins over the file")
+	assert.Equal(t, 0.0, reloader.Current().RateLimitRPS, "variables loaded from the file earlier are not used")
+}
+
+func TestLoadRuntimeConfig_DefaultBurst(t *testing.T) {
+	cfg, err := loadRuntimeConfig(func(key string) string {
+		if key == "RATE_LIMIT_RPS" {
+			return "0.5"
+		}
+		return ""
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, cfg.RateLimitBurst)
+}
@@ -0,0 +1,143 @@
+// Package idgen contains node-aware generators of unique ids
+package idgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// timestampBits is a number of bits of the tick since Epoch
+	timestampBits = 37
+	// nodeBits is a number of bits of the node id
+	nodeBits = 10
+	// sequenceBits is a number of bits of the sequence within a tick
+	sequenceBits = 12
+
+	// MaxNodeID is the largest allowed node id
+	MaxNodeID = 1<<nodeBits - 1
+	// MaxID is the largest id which can be generated
+	MaxID = 1<<(timestampBits+nodeBits+sequenceBits) - 1
+
+	maxSequence  = 1<<sequenceBits - 1
+	maxTimestamp = 1<<timestampBits - 1
+
+	// Tick is a resolution of the timestamp part of an id
+	Tick = 10 * time.Millisecond
+	// MaxClockRollback is the largest backward clock jump which is waited out,
+	// larger jumps make NextID fail
+	MaxClockRollback = time.Second
+)
+
+// Epoch is a starting point of the id timestamps
+var Epoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ErrClockMovedBackwards is returned when the clock jumped back by more than MaxClockRollback
+var ErrClockMovedBackwards = errors.New("clock moved backwards")
+
+// Snowflake generates ids composed of a timestamp, a node id and a sequence.
+// Ids of different nodes never collide, so codes built from them are unique
+// across instances sharing the same storage. An id takes 59 bits, which fits
+// into the space of 10-symbol codes from the 63-symbol charset.
+type Snowflake struct {
+	mutex    sync.Mutex
+	nodeID   int64
+	lastTick int64
+	sequence int64
+	now      func() time.Time
+}
+
+// NewSnowflake creates a generator for the node with nodeID
+func NewSnowflake(nodeID int64) (*Snowflake, error) {
+	const op = "idgen.NewSnowflake"
+
+	if nodeID < 0 || nodeID > MaxNodeID {
+		return nil, fmt.Errorf("%s: node id must be between 0 and %d, got %d", op, MaxNodeID, nodeID)
+	}
+
+	return &Snowflake{
+		nodeID:   nodeID,
+		lastTick: -1,
+		now:      time.Now,
+	}, nil
+}
+
+// NextID returns the next unique id. It waits for the next tick when
+// the sequence of the current one is exhausted and waits out small
+// backward jumps of the clock.
+func (s *Snowflake) NextID(ctx context.Context) (int64, error) {
+	const op = "idgen.Snowflake.NextID"
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		tick := s.currentTick()
+		if tick > maxTimestamp {
+			return 0, fmt.Errorf("%s: timestamp is out of range", op)
+		}
+
+		switch {
+		case tick < s.lastTick:
+			behind := time.Duration(s.lastTick-tick) * Tick
+			if behind > MaxClockRollback {
+				return 0, fmt.Errorf("%s: %w by %s", op, ErrClockMovedBackwards, behind)
+			}
+			if err := s.wait(ctx, behind); err != nil {
+				return 0, err
+			}
+		case tick > s.lastTick:
+			s.lastTick = tick
+			s.sequence = 0
+			return s.compose(), nil
+		case s.sequence < maxSequence:
+			s.sequence++
+			return s.compose(), nil
+		default:
+			if err := s.wait(ctx, s.untilNextTick()); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// compose builds an id from the current state
+func (s *Snowflake) compose() int64 {
+	return s.lastTick<<(nodeBits+sequenceBits) | s.nodeID<<sequenceBits | s.sequence
+}
+
+// currentTick returns the number of ticks since Epoch
+func (s *Snowflake) currentTick() int64 {
+	return int64(s.now().Sub(Epoch) / Tick)
+}
+
+// untilNextTick returns the time left until the next tick
+func (s *Snowflake) untilNextTick() time.Duration {
+	elapsed := s.now().Sub(Epoch)
+	return Tick - elapsed%Tick
+}
+
+// wait sleeps for d unless ctx is done earlier
+func (s *Snowflake) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Decompose splits id into its tick, node id and sequence
+func Decompose(id int64) (tick, nodeID, sequence int64) {
+	return id >> (nodeBits + sequenceBits), id >> sequenceBits & MaxNodeID, id & maxSequence
+}
@@ -0,0 +1,145 @@
+package idgen
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shiftedClock is a real clock shifted by an adjustable offset
+type shiftedClock struct {
+	offset atomic.Int64
+}
+
+func (c *shiftedClock) Now() time.Time {
+	return time.Now().Add(time.Duration(c.offset.Load()))
+}
+
+func TestNewSnowflake_NodeIDValidation(t *testing.T) {
+	_, err := NewSnowflake(-1)
+	assert.Error(t, err)
+
+	_, err = NewSnowflake(MaxNodeID + 1)
+	assert.Error(t, err)
+
+	_, err = NewSnowflake(MaxNodeID)
+	assert.NoError(t, err)
+}
+
+func TestSnowflake_IDsAreIncreasing(t *testing.T) {
+	s, err := NewSnowflake(7)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	previous := int64(-1)
+	for i := 0; i < 10000; i++ {
+		id, err := s.NextID(ctx)
+		require.NoError(t, err)
+		require.Greater(t, id, previous)
+		previous = id
+
+		_, nodeID, _ := Decompose(id)
+		require.Equal(t, int64(7), nodeID)
+	}
+}
+
+func TestSnowflake_UniqueAcrossConcurrentNodes(t *testing.T) {
+	const (
+		nodes      = 8
+		goroutines = 4
+		perRoutine = 5000
+	)
+	ctx := context.Background()
+
+	var seen sync.Map
+	var duplicates atomic.Int64
+	var wg sync.WaitGroup
+
+	for node := int64(0); node < nodes; node++ {
+		s, err := NewSnowflake(node)
+		require.NoError(t, err)
+
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perRoutine; i++ {
+					id, err := s.NextID(ctx)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if _, loaded := seen.LoadOrStore(id, struct{}{}); loaded {
+						duplicates.Add(1)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	assert.Zero(t, duplicates.Load())
+}
+
+func TestSnowflake_SequenceExhaustionWaitsForNextTick(t *testing.T) {
+	s, err := NewSnowflake(1)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	ticks := make(map[int64]int)
+	for i := 0; i < 3*(maxSequence+1); i++ {
+		id, err := s.NextID(ctx)
+		require.NoError(t, err)
+
+		tick, _, _ := Decompose(id)
+		ticks[tick]++
+	}
+
+	for tick, count := range ticks {
+		assert.LessOrEqual(t, count, maxSequence+1, "tick %d", tick)
+	}
+}
+
+func TestSnowflake_WaitsOutSmallClockRollback(t *testing.T) {
+	s, err := NewSnowflake(1)
+	require.NoError(t, err)
+	clock := &shiftedClock{}
+	s.now = clock.Now
+	ctx := context.Background()
+
+	first, err := s.NextID(ctx)
+	require.NoError(t, err)
+
+	clock.offset.Store(int64(-50 * time.Millisecond))
+
+	second, err := s.NextID(ctx)
+	require.NoError(t, err)
+	assert.Greater(t, second, first)
+}
+
+func TestSnowflake_FailsOnLargeClockRollback(t *testing.T) {
+	s, err := NewSnowflake(1)
+	require.NoError(t, err)
+	clock := &shiftedClock{}
+	s.now = clock.Now
+	ctx := context.Background()
+
+	_, err = s.NextID(ctx)
+	require.NoError(t, err)
+
+	clock.offset.Store(int64(-2 * MaxClockRollback))
+
+	_, err = s.NextID(ctx)
+	assert.ErrorIs(t, err, ErrClockMovedBackwards)
+}
+
+func TestSnowflake_IDFitsCodeSpace(t *testing.T) {
+	id := int64(maxTimestamp)<<(nodeBits+sequenceBits) | MaxNodeID<<sequenceBits | maxSequence
+
+	assert.Less(t, uint64(id), uint64(984930291881790849), "63^10 codes must cover every id")
+}
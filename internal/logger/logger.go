@@ -5,7 +5,15 @@ import (
 	"os"
 )
 
+// level is a log level shared by the default logger, it can be changed at runtime
+var level slog.LevelVar
+
 func InitLogger() {
-	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &level}))
 	slog.SetDefault(logger)
 }
+
+// SetLevel changes the minimal level of the default logger
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
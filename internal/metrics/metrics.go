@@ -0,0 +1,79 @@
+// Package metrics contains the service metrics published with expvar
+package metrics
+
+import "expvar"
+
+var (
+	// DBPoolTotalConns is a number of connections in the pool
+	DBPoolTotalConns = expvar.NewInt("db_pool_total_conns")
+	// DBPoolAcquiredConns is a number of connections currently in use
+	DBPoolAcquiredConns = expvar.NewInt("db_pool_acquired_conns")
+	// DBPoolIdleConns is a number of idle connections in the pool
+	DBPoolIdleConns = expvar.NewInt("db_pool_idle_conns")
+	// DBPoolMaxConns is a maximum size of the pool
+	DBPoolMaxConns = expvar.NewInt("db_pool_max_conns")
+	// DBPoolEmptyAcquires is a number of acquires which had to wait for a connection
+	DBPoolEmptyAcquires = expvar.NewInt("db_pool_empty_acquires_total")
+	// DBPoolAcquireWaitMs is a total time spent waiting for a connection
+	DBPoolAcquireWaitMs = expvar.NewInt("db_pool_acquire_wait_ms_total")
+	// DBQueryTimeouts is a number of queries cancelled by the per-operation timeout
+	DBQueryTimeouts = expvar.NewInt("db_query_timeouts_total")
+	// DBRetries is a number of database operations repeated after a transient error
+	DBRetries = expvar.NewInt("db_retries_total")
+
+	// StorageBreakerState is a state of the storage circuit breaker:
+	// 0 is closed, 1 is open, 2 is half-open
+	StorageBreakerState = expvar.NewInt("storage_breaker_state")
+	// StorageBreakerRejected is a number of requests rejected by the open breaker
+	StorageBreakerRejected = expvar.NewInt("storage_breaker_rejected_total")
+	// StorageBreakerCacheHits is a number of redirects served from the last-known cache
+	StorageBreakerCacheHits = expvar.NewInt("storage_breaker_cache_hits_total")
+
+	// CodePoolHits is a number of codes taken from the pre-allocated pool
+	CodePoolHits = expvar.NewInt("code_pool_hits_total")
+	// CodePoolMisses is a number of codes generated because the pool was empty
+	CodePoolMisses = expvar.NewInt("code_pool_misses_total")
+	// CodePoolReserved is a number of codes reserved for the pool
+	CodePoolReserved = expvar.NewInt("code_pool_reserved_total")
+
+	// CodeAttempts is a number of generated codes tried by ShortenURL
+	CodeAttempts = expvar.NewInt("code_attempts_total")
+	// CodeCollisions is a number of generated codes which were already used
+	CodeCollisions = expvar.NewInt("code_collisions_total")
+	// CodeCollisionRate is a share of colliding codes over the recent
+	// ShortenURL calls by code policy
+	CodeCollisionRate = expvar.NewMap("code_collision_rate")
+	// CodeLength is a current length of generated codes by code policy
+	CodeLength = expvar.NewMap("code_length")
+	// CodeKeyspaceMaxUtilisation is an upper bound of the share of used codes
+	// by code policy, all the stored links are counted against every policy
+	CodeKeyspaceMaxUtilisation = expvar.NewMap("code_keyspace_max_utilisation")
+
+	// CodeValidationRejected is a number of lookups of codes no policy could issue
+	CodeValidationRejected = expvar.NewInt("code_validation_rejected_total")
+	// CodeFilterRejected is a number of lookups of missing codes answered without the storage
+	CodeFilterRejected = expvar.NewInt("code_filter_rejected_total")
+	// CodeFilterFalsePositives is a number of missing codes the code filter passed to the storage
+	CodeFilterFalsePositives = expvar.NewInt("code_filter_false_positives_total")
+
+	// MigrationCopied is a number of urls copied to the destination storage
+	MigrationCopied = expvar.NewInt("migration_copied_total")
+	// MigrationMirrored is a number of created urls mirrored to the secondary storage
+	MigrationMirrored = expvar.NewInt("migration_mirrored_total")
+	// MigrationMirrorFailures is a number of created urls which failed to be mirrored
+	MigrationMirrorFailures = expvar.NewInt("migration_mirror_failures_total")
+)
+
+// SetFloat sets the value of key in m
+func SetFloat(m *expvar.Map, key string, value float64) {
+	v := new(expvar.Float)
+	v.Set(value)
+	m.Set(key, v)
+}
+
+// SetInt sets the value of key in m
+func SetInt(m *expvar.Map, key string, value int64) {
+	v := new(expvar.Int)
+	v.Set(value)
+	m.Set(key, v)
+}
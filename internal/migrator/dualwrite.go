@@ -0,0 +1,157 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/metrics"
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+)
+
+// mirrorTimeout limits a mirror write, so a slow secondary storage
+// doesn't slow down shortening; urls not mirrored in time are copied
+// by the migrator
+const mirrorTimeout = 2 * time.Second
+
+// DualWriter is a repository which serves requests from the primary storage
+// and mirrors every created url to the secondary one during a cutover.
+// A failed mirror doesn't fail the request, it is logged and counted,
+// the url is copied by the next run of the migrator.
+type DualWriter struct {
+	primary   storage.Repository
+	secondary Destination
+	onSaved   func(shortURL string)
+}
+
+// DualWriterOption configures a DualWriter
+type DualWriterOption func(*DualWriter)
+
+// WithOnSaved calls fn with the short url of every url saved
+// to the primary storage through the DualWriter
+func WithOnSaved(fn func(shortURL string)) DualWriterOption {
+	return func(d *DualWriter) {
+		d.onSaved = fn
+	}
+}
+
+// NewDualWriter wraps primary to mirror created urls to secondary
+func NewDualWriter(primary storage.Repository, secondary Destination, opts ...DualWriterOption) storage.Repository {
+	d := &DualWriter{
+		primary:   primary,
+		secondary: secondary,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// GetURL retrieves the url from the primary storage
+func (d *DualWriter) GetURL(ctx context.Context, shortURL string) (models.Url, error) {
+	return d.primary.GetURL(ctx, shortURL)
+}
+
+// SaveURL saves url to the primary storage and mirrors it
+func (d *DualWriter) SaveURL(ctx context.Context, url models.Url) (int64, error) {
+	id, err := d.primary.SaveURL(ctx, url)
+	if err != nil {
+		return id, err
+	}
+
+	url.Id = id
+	if url.CanonicalURL == "" {
+		url.CanonicalURL = url.OriginalURL
+	}
+	d.mirror(ctx, url)
+
+	return id, nil
+}
+
+// GetOrCreate gets or creates url in the primary storage and mirrors it if it was created
+func (d *DualWriter) GetOrCreate(ctx context.Context, url models.Url) (models.Url, bool, error) {
+	saved, created, err := d.primary.GetOrCreate(ctx, url)
+	if err == nil && created {
+		d.mirror(ctx, saved)
+	}
+	return saved, created, err
+}
+
+// SaveURLs imports urls to the primary storage and mirrors the saved ones,
+// so imports during a cutover reach the secondary storage as well
+func (d *DualWriter) SaveURLs(ctx context.Context, urls []models.Url) ([]error, error) {
+	const op = "migrator.DualWriter.SaveURLs"
+
+	importer, ok := d.primary.(storage.Importer)
+	if !ok {
+		return nil, fmt.Errorf("%s: primary storage doesn't support import", op)
+	}
+
+	errs, err := importer.SaveURLs(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	saved := make([]models.Url, 0, len(urls))
+	for i, url := range urls {
+		if errs[i] == nil {
+			saved = append(saved, url)
+		}
+	}
+	d.mirror(ctx, saved...)
+
+	return errs, nil
+}
+
+// mirror saves urls to the secondary storage within mirrorTimeout,
+// the request being cancelled after the primary write doesn't stop it
+func (d *DualWriter) mirror(ctx context.Context, urls ...models.Url) {
+	const op = "migrator.DualWriter.mirror"
+
+	if len(urls) == 0 {
+		return
+	}
+
+	if d.onSaved != nil {
+		for _, url := range urls {
+			d.onSaved(url.ShortURL)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mirrorTimeout)
+	defer cancel()
+
+	errs, err := d.secondary.SaveURLs(ctx, urls)
+	if err != nil {
+		metrics.MigrationMirrorFailures.Add(int64(len(urls)))
+		slog.Error("failed to mirror urls", "count", len(urls), "error", fmt.Errorf("%s: %w", op, err))
+		return
+	}
+
+	for i, url := range urls {
+		if errs[i] != nil && !sameURL(ctx, d.secondary, url) {
+			metrics.MigrationMirrorFailures.Add(1)
+			slog.Error("failed to mirror url", "short_url", url.ShortURL, "error", fmt.Errorf("%s: %w", op, errs[i]))
+			continue
+		}
+		metrics.MigrationMirrored.Add(1)
+	}
+}
+
+// OriginalURLExists checks the primary storage
+func (d *DualWriter) OriginalURLExists(ctx context.Context, canonicalURL string) (string, bool, error) {
+	return d.primary.OriginalURLExists(ctx, canonicalURL)
+}
+
+// CountURLs counts urls of the primary storage
+func (d *DualWriter) CountURLs(ctx context.Context) (int64, error) {
+	return d.primary.CountURLs(ctx)
+}
+
+// Close closes both storages
+func (d *DualWriter) Close() {
+	d.primary.Close()
+	d.secondary.Close()
+}
@@ -0,0 +1,365 @@
+// Package migrator copies urls between storages without downtime
+package migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/metrics"
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+)
+
+// DefaultBatchSize is a number of urls copied at once
+const DefaultBatchSize = 500
+
+// Source is a storage urls are copied from
+type Source interface {
+	storage.Repository
+	storage.Exporter
+}
+
+// Destination is a storage urls are copied to
+type Destination interface {
+	storage.Repository
+	storage.Exporter
+	storage.Importer
+}
+
+// Checkpoint is the progress of a copy, it is saved after every batch
+// so an interrupted copy resumes after the last copied url
+type Checkpoint struct {
+	// LastID is the id of the last copied url in the source
+	LastID int64 `json:"last_id"`
+	// Copied is the number of urls saved to the destination
+	Copied int64 `json:"copied"`
+	// Present is the number of urls the destination already had
+	Present int64 `json:"present"`
+	// Conflicts is the number of urls whose short or original url
+	// is used by another url in the destination
+	Conflicts int64     `json:"conflicts"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Checksum is an order-independent digest of urls, storages with the same
+// urls have the same checksum. Ids and creation times are not included,
+// they may differ between storages.
+type Checksum struct {
+	Count  int64
+	Digest string
+}
+
+// Parity is the result of the comparison of the storages
+type Parity struct {
+	Source      Checksum
+	Destination Checksum
+	Match       bool
+}
+
+// Status is the state of the migration
+type Status struct {
+	Running    bool
+	Checkpoint Checkpoint
+	// Parity is the result of the last verification, nil if there was none
+	Parity *Parity
+	// Error is the error of the last run
+	Error string
+}
+
+// Migrator copies urls from a source storage to a destination one
+type Migrator struct {
+	src            Source
+	dst            Destination
+	checkpointFile string
+	batchSize      int
+
+	mutex  sync.Mutex
+	status Status
+}
+
+// Option configures the migrator
+type Option func(*Migrator)
+
+// WithCheckpointFile sets the file the progress is saved to,
+// the copy starts from the beginning every time without it
+func WithCheckpointFile(path string) Option {
+	return func(m *Migrator) {
+		m.checkpointFile = path
+	}
+}
+
+// WithBatchSize sets the number of urls copied at once
+func WithBatchSize(n int) Option {
+	return func(m *Migrator) {
+		if n > 0 {
+			m.batchSize = n
+		}
+	}
+}
+
+// New creates a migrator from src to dst, both must support
+// listing urls and dst must support saving them in batches
+func New(src, dst storage.Repository, opts ...Option) (*Migrator, error) {
+	const op = "migrator.New"
+
+	source, ok := src.(Source)
+	if !ok {
+		return nil, fmt.Errorf("%s: source storage does not support export", op)
+	}
+	destination, ok := dst.(Destination)
+	if !ok {
+		return nil, fmt.Errorf("%s: destination storage does not support import", op)
+	}
+
+	m := &Migrator{
+		src:       source,
+		dst:       destination,
+		batchSize: DefaultBatchSize,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m, nil
+}
+
+// Status returns the state of the migration
+func (m *Migrator) Status() Status {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.status
+}
+
+// Run copies urls and verifies parity, the result is reported by Status
+func (m *Migrator) Run(ctx context.Context) (Parity, error) {
+	m.setStatus(func(s *Status) {
+		s.Running = true
+		s.Error = ""
+	})
+
+	parity, err := m.run(ctx)
+
+	m.setStatus(func(s *Status) {
+		s.Running = false
+		if err != nil {
+			s.Error = err.Error()
+		}
+	})
+	return parity, err
+}
+
+func (m *Migrator) run(ctx context.Context) (Parity, error) {
+	if _, err := m.Copy(ctx); err != nil {
+		return Parity{}, err
+	}
+	return m.Verify(ctx)
+}
+
+// Copy copies the urls created after the checkpoint. Urls the destination
+// already has are skipped, so a copy may be repeated and may run while new
+// urls are dual-written.
+func (m *Migrator) Copy(ctx context.Context) (Checkpoint, error) {
+	const op = "migrator.Migrator.Copy"
+
+	checkpoint, err := m.loadCheckpoint()
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("%s: %w", op, err)
+	}
+	m.setStatus(func(s *Status) { s.Checkpoint = checkpoint })
+
+	if checkpoint.LastID > 0 {
+		slog.Info("resuming migration", "last_id", checkpoint.LastID, "copied", checkpoint.Copied)
+	}
+
+	batch := make([]models.Url, 0, m.batchSize)
+	err = m.src.ForEachURL(ctx, checkpoint.LastID, func(url models.Url) error {
+		batch = append(batch, url)
+		if len(batch) < m.batchSize {
+			return nil
+		}
+
+		err := m.copyBatch(ctx, batch, &checkpoint)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil && len(batch) > 0 {
+		err = m.copyBatch(ctx, batch, &checkpoint)
+	}
+	if err != nil {
+		return checkpoint, fmt.Errorf("%s: %w", op, err)
+	}
+
+	slog.Info("migration copy finished", "copied", checkpoint.Copied,
+		"present", checkpoint.Present, "conflicts", checkpoint.Conflicts)
+	return checkpoint, nil
+}
+
+// copyBatch saves batch to the destination and advances the checkpoint
+func (m *Migrator) copyBatch(ctx context.Context, batch []models.Url, checkpoint *Checkpoint) error {
+	errs, err := m.dst.SaveURLs(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	for i, err := range errs {
+		url := batch[i]
+		switch {
+		case err == nil:
+			checkpoint.Copied++
+			metrics.MigrationCopied.Add(1)
+		case sameURL(ctx, m.dst, url):
+			checkpoint.Present++
+		default:
+			checkpoint.Conflicts++
+			slog.Warn("url conflicts with the destination", "short_url", url.ShortURL, "error", err)
+		}
+	}
+
+	checkpoint.LastID = batch[len(batch)-1].Id
+	checkpoint.UpdatedAt = time.Now()
+	if err := m.saveCheckpoint(*checkpoint); err != nil {
+		return err
+	}
+
+	m.setStatus(func(s *Status) { s.Checkpoint = *checkpoint })
+	return nil
+}
+
+// Verify compares the checksums of the storages
+func (m *Migrator) Verify(ctx context.Context) (Parity, error) {
+	const op = "migrator.Migrator.Verify"
+
+	var parity Parity
+	var err error
+	if parity.Source, err = ChecksumOf(ctx, m.src); err != nil {
+		return Parity{}, fmt.Errorf("%s: source: %w", op, err)
+	}
+	if parity.Destination, err = ChecksumOf(ctx, m.dst); err != nil {
+		return Parity{}, fmt.Errorf("%s: destination: %w", op, err)
+	}
+	parity.Match = parity.Source == parity.Destination
+
+	if parity.Match {
+		slog.Info("storages are in parity", "urls", parity.Source.Count)
+	} else {
+		slog.Warn("storages differ", "source", parity.Source, "destination", parity.Destination)
+	}
+
+	m.setStatus(func(s *Status) { s.Parity = &parity })
+	return parity, nil
+}
+
+// ChecksumOf computes the checksum of the urls of src. The digests
+// of urls are combined with xor, so the order of urls doesn't matter.
+func ChecksumOf(ctx context.Context, src storage.Exporter) (Checksum, error) {
+	var count int64
+	var digest [sha256.Size]byte
+
+	err := src.ForEachURL(ctx, 0, func(url models.Url) error {
+		sum := urlDigest(url)
+		for i := range digest {
+			digest[i] ^= sum[i]
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return Checksum{}, err
+	}
+
+	return Checksum{Count: count, Digest: hex.EncodeToString(digest[:])}, nil
+}
+
+// urlDigest returns the digest of the fields of url compared between storages
+func urlDigest(url models.Url) [sha256.Size]byte {
+	canonicalURL := url.CanonicalURL
+	if canonicalURL == "" {
+		canonicalURL = url.OriginalURL
+	}
+
+	standalone := "0"
+	if url.Standalone {
+		standalone = "1"
+	}
+
+	return sha256.Sum256([]byte(url.ShortURL + "\x00" + url.OriginalURL + "\x00" + canonicalURL + "\x00" + standalone))
+}
+
+// sameURL checks if dst already has url under the same short url
+func sameURL(ctx context.Context, dst storage.Repository, url models.Url) bool {
+	stored, err := dst.GetURL(ctx, url.ShortURL)
+	if err != nil {
+		return false
+	}
+	return urlDigest(stored) == urlDigest(url)
+}
+
+func (m *Migrator) setStatus(update func(*Status)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	update(&m.status)
+}
+
+// loadCheckpoint reads the checkpoint file, a missing file is an empty checkpoint
+func (m *Migrator) loadCheckpoint() (Checkpoint, error) {
+	var checkpoint Checkpoint
+	if m.checkpointFile == "" {
+		return checkpoint, nil
+	}
+
+	data, err := os.ReadFile(m.checkpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("invalid checkpoint %s: %w", m.checkpointFile, err)
+	}
+	return checkpoint, nil
+}
+
+// saveCheckpoint replaces the checkpoint file atomically
+func (m *Migrator) saveCheckpoint(checkpoint Checkpoint) error {
+	if m.checkpointFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.checkpointFile), filepath.Base(m.checkpointFile)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.checkpointFile)
+}
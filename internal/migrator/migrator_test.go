@@ -0,0 +1,186 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/hard-gainer/url-shortener/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRepo creates a memory repository with n urls
+func newRepo(t *testing.T, n int) storage.Repository {
+	t.Helper()
+
+	repo, err := memory.NewMemory()
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		_, err := repo.SaveURL(context.Background(), models.Url{
+			ShortURL:    fmt.Sprintf("code%d", i),
+			OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+		})
+		require.NoError(t, err)
+	}
+	return repo
+}
+
+func TestMigrator_Copy(t *testing.T) {
+	ctx := context.Background()
+	src := newRepo(t, 10)
+	dst := newRepo(t, 0)
+
+	m, err := New(src, dst, WithBatchSize(3))
+	require.NoError(t, err)
+
+	checkpoint, err := m.Copy(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), checkpoint.Copied)
+	assert.Equal(t, int64(10), checkpoint.LastID)
+
+	parity, err := m.Verify(ctx)
+	require.NoError(t, err)
+	assert.True(t, parity.Match)
+	assert.Equal(t, int64(10), parity.Destination.Count)
+}
+
+func TestMigrator_ResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	checkpointFile := filepath.Join(t.TempDir(), "migration.checkpoint")
+	src := newRepo(t, 5)
+	dst := newRepo(t, 0)
+
+	m, err := New(src, dst, WithCheckpointFile(checkpointFile))
+	require.NoError(t, err)
+	_, err = m.Copy(ctx)
+	require.NoError(t, err)
+
+	_, err = src.SaveURL(ctx, models.Url{ShortURL: "late", OriginalURL: "https://example.org"})
+	require.NoError(t, err)
+
+	m, err = New(src, dst, WithCheckpointFile(checkpointFile))
+	require.NoError(t, err)
+	checkpoint, err := m.Copy(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), checkpoint.Copied, "only the new url is copied")
+	assert.Equal(t, int64(6), checkpoint.LastID)
+
+	parity, err := m.Verify(ctx)
+	require.NoError(t, err)
+	assert.True(t, parity.Match)
+}
+
+func TestMigrator_PresentAndConflicts(t *testing.T) {
+	ctx := context.Background()
+	src := newRepo(t, 3)
+	dst := newRepo(t, 1)
+	_, err := dst.SaveURL(ctx, models.Url{ShortURL: "code1", OriginalURL: "https://example.org"})
+	require.NoError(t, err)
+
+	m, err := New(src, dst)
+	require.NoError(t, err)
+
+	checkpoint, err := m.Copy(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), checkpoint.Copied)
+	assert.Equal(t, int64(1), checkpoint.Present)
+	assert.Equal(t, int64(1), checkpoint.Conflicts)
+
+	parity, err := m.Verify(ctx)
+	require.NoError(t, err)
+	assert.False(t, parity.Match)
+}
+
+func TestChecksumOf_IgnoresOrderAndIDs(t *testing.T) {
+	ctx := context.Background()
+	urls := []models.Url{
+		{ShortURL: "a", OriginalURL: "https://example.com/a"},
+		{ShortURL: "b", OriginalURL: "https://example.com/b", Standalone: true},
+	}
+
+	first, err := memory.NewMemory()
+	require.NoError(t, err)
+	second, err := memory.NewMemory()
+	require.NoError(t, err)
+	for i := range urls {
+		_, err := first.SaveURL(ctx, urls[i])
+		require.NoError(t, err)
+		_, err = second.SaveURL(ctx, urls[len(urls)-1-i])
+		require.NoError(t, err)
+	}
+
+	firstSum, err := ChecksumOf(ctx, first.(storage.Exporter))
+	require.NoError(t, err)
+	secondSum, err := ChecksumOf(ctx, second.(storage.Exporter))
+	require.NoError(t, err)
+	assert.Equal(t, firstSum, secondSum)
+}
+
+func TestDualWriter_MirrorsCreatedURLs(t *testing.T) {
+	ctx := context.Background()
+	primary := newRepo(t, 2)
+	secondary := newRepo(t, 0)
+
+	var notified []string
+	repo := NewDualWriter(primary, secondary.(Destination), WithOnSaved(func(shortURL string) {
+		notified = append(notified, shortURL)
+	}))
+
+	saved, created, err := repo.GetOrCreate(ctx, models.Url{ShortURL: "new", OriginalURL: "https://example.org"})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	mirrored, err := secondary.GetURL(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, saved.OriginalURL, mirrored.OriginalURL)
+
+	_, created, err = repo.GetOrCreate(ctx, models.Url{ShortURL: "other", OriginalURL: "https://example.org"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	count, err := secondary.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "saved", OriginalURL: "https://example.net"})
+	require.NoError(t, err)
+	mirrored, err = secondary.GetURL(ctx, "saved")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.net", mirrored.CanonicalURL)
+	assert.Equal(t, []string{"new", "saved"}, notified)
+
+	m, err := New(primary, secondary)
+	require.NoError(t, err)
+	parity, err := m.Run(ctx)
+	require.NoError(t, err)
+	assert.True(t, parity.Match)
+	assert.Equal(t, int64(4), parity.Destination.Count)
+	assert.Equal(t, int64(2), m.Status().Checkpoint.Present, "the mirrored urls are not copied twice")
+}
+
+func TestDualWriter_MirrorsImportedURLs(t *testing.T) {
+	ctx := context.Background()
+	primary := newRepo(t, 1)
+	secondary := newRepo(t, 0)
+
+	repo := NewDualWriter(primary, secondary.(Destination))
+	importer, ok := repo.(storage.Importer)
+	require.True(t, ok)
+
+	errs, err := importer.SaveURLs(ctx, []models.Url{
+		{ShortURL: "imported", OriginalURL: "https://example.org"},
+		{ShortURL: "code0", OriginalURL: "https://example.net"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], storage.ErrURLMappingExists)
+
+	mirrored, err := secondary.GetURL(ctx, "imported")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.org", mirrored.OriginalURL)
+	_, err = secondary.GetURL(ctx, "code0")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound, "a conflicting url is not mirrored")
+}
@@ -19,17 +19,33 @@ func (m *RepositoryMock) GetURL(ctx context.Context, shortURL string) (models.Ur
 }
 
 // SaveURL is a mock of SaveURL
-func (m *RepositoryMock) SaveURL(ctx context.Context, shortURL, originalURL string) (int64, error) {
-	args := m.Called(ctx, shortURL, originalURL)
+func (m *RepositoryMock) SaveURL(ctx context.Context, url models.Url) (int64, error) {
+	args := m.Called(ctx, url)
 	return args.Get(0).(int64), args.Error(1)
 }
 
+// GetOrCreate is a mock of GetOrCreate, the returned url may be
+// given as a func(models.Url) models.Url of the passed one
+func (m *RepositoryMock) GetOrCreate(ctx context.Context, url models.Url) (models.Url, bool, error) {
+	args := m.Called(ctx, url)
+	if fn, ok := args.Get(0).(func(models.Url) models.Url); ok {
+		return fn(url), args.Bool(1), args.Error(2)
+	}
+	return args.Get(0).(models.Url), args.Bool(1), args.Error(2)
+}
+
 // OriginalURLExists is a mock of OriginalURLExists
-func (m *RepositoryMock) OriginalURLExists(ctx context.Context, originalURL string) (string, bool, error) {
-	args := m.Called(ctx, originalURL)
+func (m *RepositoryMock) OriginalURLExists(ctx context.Context, canonicalURL string) (string, bool, error) {
+	args := m.Called(ctx, canonicalURL)
 	return args.String(0), args.Bool(1), args.Error(2)
 }
 
+// CountURLs is a mock of CountURLs
+func (m *RepositoryMock) CountURLs(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // Close is a mock of Close
 func (m *RepositoryMock) Close() {
 	m.Called()
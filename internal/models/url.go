@@ -6,5 +6,10 @@ type Url struct {
 	Id          int64
 	ShortURL    string
 	OriginalURL string
-	CreatedAt   time.Time
+	// CanonicalURL is the canonical form of OriginalURL used for deduplication
+	CanonicalURL string
+	// Standalone urls are not deduplicated, other urls with the same
+	// canonical form may exist and they are not returned for it
+	Standalone bool
+	CreatedAt  time.Time
 }
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// leetspeak maps symbols to the letter they are read as, letters
+// which look alike are mapped to one of them
+var leetspeak = map[rune]rune{
+	'0': 'o',
+	'1': 'i', 'l': 'i', '!': 'i', '|': 'i',
+	'3': 'e',
+	'4': 'a', '@': 'a',
+	'5': 's', '$': 's',
+	'7': 't', '+': 't',
+	'8': 'b',
+	'9': 'g',
+}
+
+// Blocklist rejects codes which are reserved words or contain offensive
+// ones. Words are compared ignoring case, leetspeak and separators, so
+// "Adm1n" matches "admin" and "b_4_d" matches "bad". It is safe for
+// concurrent use and may be reloaded while in use.
+type Blocklist struct {
+	words atomic.Pointer[blockedWords]
+}
+
+// blockedWords are the normalized words of a blocklist file
+type blockedWords struct {
+	// exact are words which are blocked only as a whole code
+	exact map[string]bool
+	// contained are words which are blocked anywhere in a code
+	contained []string
+}
+
+// NewBlocklist creates an empty blocklist
+func NewBlocklist() *Blocklist {
+	b := &Blocklist{}
+	b.words.Store(&blockedWords{exact: make(map[string]bool)})
+	return b
+}
+
+// Load replaces the words with the ones of the file at path, the list
+// is emptied if path is empty and kept if the file can't be read.
+// The file has a word per line, a word starting with = is blocked only
+// as a whole code, other words are blocked anywhere in a code; empty
+// lines and lines starting with # are skipped.
+func (b *Blocklist) Load(path string) error {
+	const op = "service.Blocklist.Load"
+
+	words := &blockedWords{exact: make(map[string]bool)}
+	if path == "" {
+		b.words.Store(words)
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if word, exact := strings.CutPrefix(line, "="); exact {
+			if word = normalizeWord(word); word != "" {
+				words.exact[word] = true
+			}
+			continue
+		}
+		if word := normalizeWord(line); word != "" {
+			words.contained = append(words.contained, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	b.words.Store(words)
+	return nil
+}
+
+// Blocked reports whether code is a blocked word or contains one
+func (b *Blocklist) Blocked(code string) bool {
+	words := b.words.Load()
+	if len(words.exact) == 0 && len(words.contained) == 0 {
+		return false
+	}
+
+	code = normalizeWord(code)
+	if words.exact[code] {
+		return true
+	}
+	for _, word := range words.contained {
+		if strings.Contains(code, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeWord lowercases s, replaces leetspeak symbols by letters
+// and drops separators and other symbols
+func normalizeWord(s string) string {
+	var normalized strings.Builder
+	for _, c := range strings.ToLower(s) {
+		if letter, exists := leetspeak[c]; exists {
+			c = letter
+		}
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			normalized.WriteRune(c)
+		}
+	}
+	return normalized.String()
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/mocks"
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeBlocklist(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// codeList is a code generator returning codes in order
+type codeList struct {
+	codes []string
+}
+
+func (g *codeList) Generate(context.Context) (string, error) {
+	code := g.codes[0]
+	g.codes = g.codes[1:]
+	return code, nil
+}
+
+// reservedCodeList is a code list whose codes are reserved like the ones of a pool
+type reservedCodeList struct {
+	codeList
+	released []string
+}
+
+func (g *reservedCodeList) Release(ctx context.Context, codes []string) error {
+	g.released = append(g.released, codes...)
+	return nil
+}
+
+func TestBlocklist_Blocked(t *testing.T) {
+	b := NewBlocklist()
+	require.NoError(t, b.Load(writeBlocklist(t, "# reserved\n=admin\n=login\n\nbad\n")))
+
+	tests := map[string]bool{
+		"admin":      true,
+		"ADM1N":      true,
+		"l0g1n":      true,
+		"admin2":     false,
+		"mybadlink":  true,
+		"my_B4D_url": true,
+		"b-a-d":      true,
+		"good":       false,
+		"ba2d":       false,
+	}
+	for code, blocked := range tests {
+		assert.Equal(t, blocked, b.Blocked(code), code)
+	}
+}
+
+func TestBlocklist_Reload(t *testing.T) {
+	path := writeBlocklist(t, "bad\n")
+	b := NewBlocklist()
+	require.NoError(t, b.Load(path))
+	assert.True(t, b.Blocked("bad"))
+
+	require.NoError(t, os.WriteFile(path, []byte("worse\n"), 0o600))
+	require.NoError(t, b.Load(path))
+	assert.False(t, b.Blocked("bad"))
+	assert.True(t, b.Blocked("worse"))
+
+	assert.Error(t, b.Load(filepath.Join(t.TempDir(), "missing.txt")))
+	assert.True(t, b.Blocked("worse"), "the list is kept if the file can't be read")
+
+	require.NoError(t, b.Load(""))
+	assert.False(t, b.Blocked("worse"))
+}
+
+func TestShortenURL_BlockedCodes(t *testing.T) {
+	b := NewBlocklist()
+	require.NoError(t, b.Load(writeBlocklist(t, "bad\n")))
+
+	mockRepo := new(mocks.RepositoryMock)
+	generator := &codeList{codes: []string{"xxB4dxxxxx", "goodcode12"}}
+	service := NewURLService(mockRepo, WithCodeGenerator(generator), WithBlocklist(b))
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	mockRepo.On("OriginalURLExists", ctx, originalURL).Return("", false, nil)
+	mockRepo.On("GetOrCreate", ctx, urlWith("goodcode12", originalURL)).Return(asCreated, true, nil)
+
+	shortURL, err := service.ShortenURL(ctx, originalURL)
+	require.NoError(t, err)
+	assert.Equal(t, "goodcode12", shortURL)
+
+	_, err = service.ShortenURL(ctx, originalURL, WithAlias("my_bad_link"))
+	assert.ErrorIs(t, err, ErrInvalidAlias)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestShortenURL_SkippedCodesAreReleasedAndNotRetries(t *testing.T) {
+	b := NewBlocklist()
+	require.NoError(t, b.Load(writeBlocklist(t, "bad\n")))
+
+	mockRepo := new(mocks.RepositoryMock)
+	generator := &reservedCodeList{codeList: codeList{
+		codes: []string{"xxB4dxxxxx", "taken00001", "xxbadxxxxx", "taken00002", "goodcode12"},
+	}}
+	service := NewURLService(mockRepo, WithCodeGenerator(generator), WithBlocklist(b))
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	mockRepo.On("OriginalURLExists", ctx, originalURL).Return("", false, nil)
+	for _, code := range []string{"taken00001", "taken00002"} {
+		mockRepo.On("GetOrCreate", ctx, urlWith(code, originalURL)).Return(models.Url{}, false, storage.ErrURLMappingExists)
+	}
+	mockRepo.On("GetOrCreate", ctx, urlWith("goodcode12", originalURL)).Return(asCreated, true, nil)
+
+	shortURL, err := service.ShortenURL(ctx, originalURL)
+	require.NoError(t, err)
+	assert.Equal(t, "goodcode12", shortURL)
+	assert.Equal(t, []string{"xxB4dxxxxx", "taken00001", "xxbadxxxxx", "taken00002"}, generator.released,
+		"the skipped and the colliding codes are released")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestShortenURL_CodesOfConcurrentShorteningsAreReleased(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	generator := &reservedCodeList{codeList: codeList{codes: []string{"unused1234"}}}
+	service := NewURLService(mockRepo, WithCodeGenerator(generator))
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	mockRepo.On("OriginalURLExists", ctx, originalURL).Return("", false, nil)
+	mockRepo.On("GetOrCreate", ctx, urlWith("unused1234", originalURL)).
+		Return(models.Url{Id: 1, ShortURL: "winner1234", OriginalURL: originalURL}, false, nil)
+
+	shortURL, err := service.ShortenURL(ctx, originalURL)
+	require.NoError(t, err)
+	assert.Equal(t, "winner1234", shortURL)
+	assert.Equal(t, []string{"unused1234"}, generator.released)
+	mockRepo.AssertExpectations(t)
+}
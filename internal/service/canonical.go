@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+)
+
+// DefaultTrackingParams are query parameters which only track the visitor,
+// a trailing * matches any suffix
+var DefaultTrackingParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "yclid", "msclkid", "mc_cid", "mc_eid", "_ga", "igshid",
+}
+
+// Canonicalizer brings urls to the canonical form, urls which differ
+// only in insignificant details have the same canonical form
+type Canonicalizer struct {
+	// StripTracking removes TrackingParams from the query
+	StripTracking bool
+	// TrackingParams are the removed parameters, DefaultTrackingParams if empty
+	TrackingParams []string
+}
+
+// Canonicalize returns the canonical form of rawURL: the scheme and the host
+// are lowercased, the default port is removed, percent-encoding is normalized
+// and the query parameters are sorted by name
+func (c Canonicalizer) Canonicalize(rawURL string) (string, error) {
+	const op = "service.Canonicalizer.Canonicalize"
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if u.Opaque != "" {
+		return scheme + ":" + u.Opaque, nil
+	}
+
+	var b strings.Builder
+	if scheme != "" {
+		b.WriteString(scheme)
+		b.WriteString(":")
+	}
+	if u.Host != "" || u.User != nil {
+		b.WriteString("//")
+		if u.User != nil {
+			b.WriteString(u.User.String())
+			b.WriteString("@")
+		}
+		b.WriteString(canonicalHost(scheme, u.Host))
+	}
+
+	path := normalizeEscapes(u.EscapedPath())
+	if path == "" && u.Host != "" {
+		path = "/"
+	}
+	b.WriteString(path)
+
+	if query := c.canonicalQuery(u.RawQuery); query != "" {
+		b.WriteString("?")
+		b.WriteString(query)
+	}
+	if u.Fragment != "" {
+		b.WriteString("#")
+		b.WriteString(normalizeEscapes(u.EscapedFragment()))
+	}
+
+	return b.String(), nil
+}
+
+// canonicalHost lowercases host and removes the default port of scheme
+func canonicalHost(scheme, host string) string {
+	host = strings.ToLower(host)
+
+	switch {
+	case scheme == "http" && strings.HasSuffix(host, ":80"):
+		return strings.TrimSuffix(host, ":80")
+	case scheme == "https" && strings.HasSuffix(host, ":443"):
+		return strings.TrimSuffix(host, ":443")
+	default:
+		return host
+	}
+}
+
+// canonicalQuery normalizes the parameters of rawQuery, removes the tracking
+// ones and sorts them by name keeping the order of values of the same name
+func (c Canonicalizer) canonicalQuery(rawQuery string) string {
+	type param struct {
+		name string
+		raw  string
+	}
+
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+
+		raw = normalizeEscapes(raw)
+		name, _, _ := strings.Cut(raw, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if c.StripTracking && c.isTracking(name) {
+			continue
+		}
+
+		params = append(params, param{name: name, raw: raw})
+	}
+
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].name < params[j].name
+	})
+
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.raw
+	}
+	return strings.Join(parts, "&")
+}
+
+// isTracking checks if the parameter name is a tracking one
+func (c Canonicalizer) isTracking(name string) bool {
+	patterns := c.TrackingParams
+	if len(patterns) == 0 {
+		patterns = DefaultTrackingParams
+	}
+
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeEscapes decodes percent-encoded unreserved symbols
+// and uppercases the hex digits of the other escapes
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+// isUnreserved checks if c may be used in urls without escaping
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// RecanonicalizeReport is the result of Recanonicalize
+type RecanonicalizeReport struct {
+	// Updated is the number of urls whose canonical url was changed
+	Updated int64
+	// Standalone is the number of updated urls which became standalone
+	// because another url has the same canonical url
+	Standalone int64
+	// Invalid is the number of urls which can't be canonicalized
+	Invalid int64
+}
+
+// Recanonicalize sets the canonical url of every stored url to its form
+// by c, e.g. for the urls saved before canonicalization or after the
+// tracking parameters were changed. Urls are processed in the order of
+// ids, so of urls with the same canonical url the earliest one stays
+// deduplicated unless a later url already is.
+func Recanonicalize(ctx context.Context, src storage.Exporter, dst storage.Recanonicalizer, c Canonicalizer) (RecanonicalizeReport, error) {
+	const op = "service.Recanonicalize"
+	var report RecanonicalizeReport
+
+	err := src.ForEachURL(ctx, 0, func(u models.Url) error {
+		canonicalURL, err := c.Canonicalize(u.OriginalURL)
+		if err != nil {
+			slog.Warn("url can't be canonicalized", "short_url", u.ShortURL, "error", err)
+			report.Invalid++
+			return nil
+		}
+		if canonicalURL == u.CanonicalURL {
+			return nil
+		}
+
+		standalone, err := dst.SetCanonicalURL(ctx, u.Id, canonicalURL)
+		if err != nil {
+			return err
+		}
+		report.Updated++
+		if standalone && !u.Standalone {
+			report.Standalone++
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/mocks"
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalizer_Canonicalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		c        Canonicalizer
+		input    string
+		expected string
+	}{
+		{
+			name:     "scheme and host case",
+			input:    "HTTPS://Example.COM/Path",
+			expected: "https://example.com/Path",
+		},
+		{
+			name:     "default port",
+			input:    "http://example.com:80/a",
+			expected: "http://example.com/a",
+		},
+		{
+			name:     "other port",
+			input:    "https://example.com:8443/a",
+			expected: "https://example.com:8443/a",
+		},
+		{
+			name:     "empty path",
+			input:    "https://example.com",
+			expected: "https://example.com/",
+		},
+		{
+			name:     "percent-encoding",
+			input:    "https://example.com/%7euser/a%2fb?q=%e2%82%ac",
+			expected: "https://example.com/~user/a%2Fb?q=%E2%82%AC",
+		},
+		{
+			name:     "sorted query",
+			input:    "https://example.com/a?b=1&a=2&c=3&a=1",
+			expected: "https://example.com/a?a=2&a=1&b=1&c=3",
+		},
+		{
+			name:     "tracking params kept",
+			input:    "https://example.com/?utm_source=x&id=1",
+			expected: "https://example.com/?id=1&utm_source=x",
+		},
+		{
+			name:     "tracking params stripped",
+			c:        Canonicalizer{StripTracking: true},
+			input:    "https://example.com/?utm_source=x&UTM_Medium=y&fbclid=z&id=1",
+			expected: "https://example.com/?id=1",
+		},
+		{
+			name:     "custom tracking params",
+			c:        Canonicalizer{StripTracking: true, TrackingParams: []string{"ref"}},
+			input:    "https://example.com/?ref=x&utm_source=y",
+			expected: "https://example.com/?utm_source=y",
+		},
+		{
+			name:     "fragment",
+			input:    "https://example.com/#Section%2d1",
+			expected: "https://example.com/#Section-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canonical, err := tt.c.Canonicalize(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, canonical)
+		})
+	}
+}
+
+func TestCanonicalizer_SameForEquivalentURLs(t *testing.T) {
+	var c Canonicalizer
+
+	first, err := c.Canonicalize("HTTPS://Example.com/a?b=1&a=2")
+	require.NoError(t, err)
+	second, err := c.Canonicalize("https://example.com:443/a?a=2&b=1")
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+}
+
+func TestShortenURL_DeduplicatesCanonicalURL(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("OriginalURLExists", ctx, "https://example.com/a?a=2&b=1").
+		Return("existing123", true, nil)
+
+	shortURL, err := service.ShortenURL(ctx, "HTTPS://Example.com/a?b=1&a=2")
+
+	require.NoError(t, err)
+	assert.Equal(t, "existing123", shortURL)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "GetOrCreate")
+}
+
+// urlList is an exporter and recanonicalizer of urls kept in a slice
+type urlList struct {
+	urls []models.Url
+}
+
+func (l *urlList) ForEachURL(ctx context.Context, afterID int64, fn func(models.Url) error) error {
+	for _, u := range l.urls {
+		if u.Id > afterID {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *urlList) SetCanonicalURL(ctx context.Context, id int64, canonicalURL string) (bool, error) {
+	for i := range l.urls {
+		if l.urls[i].Id != id {
+			continue
+		}
+		for _, other := range l.urls {
+			if other.Id != id && other.CanonicalURL == canonicalURL && !other.Standalone {
+				l.urls[i].Standalone = true
+			}
+		}
+		l.urls[i].CanonicalURL = canonicalURL
+		return l.urls[i].Standalone, nil
+	}
+	return false, nil
+}
+
+func TestRecanonicalize(t *testing.T) {
+	list := &urlList{urls: []models.Url{
+		{Id: 1, ShortURL: "first", OriginalURL: "HTTPS://Example.com/?b=2&a=1", CanonicalURL: "HTTPS://Example.com/?b=2&a=1"},
+		{Id: 2, ShortURL: "second", OriginalURL: "https://example.com:443/?a=1&b=2", CanonicalURL: "https://example.com:443/?a=1&b=2"},
+		{Id: 3, ShortURL: "third", OriginalURL: "https://example.org/", CanonicalURL: "https://example.org/"},
+		{Id: 4, ShortURL: "broken", OriginalURL: "://broken", CanonicalURL: "://broken"},
+	}}
+
+	report, err := Recanonicalize(context.Background(), list, list, Canonicalizer{})
+	require.NoError(t, err)
+
+	assert.Equal(t, RecanonicalizeReport{Updated: 2, Standalone: 1, Invalid: 1}, report)
+	assert.Equal(t, "https://example.com/?a=1&b=2", list.urls[0].CanonicalURL)
+	assert.False(t, list.urls[0].Standalone)
+	assert.Equal(t, "https://example.com/?a=1&b=2", list.urls[1].CanonicalURL)
+	assert.True(t, list.urls[1].Standalone)
+}
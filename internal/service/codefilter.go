@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/bloom"
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+)
+
+// refreshOverlap is a number of ids before the last seen one which are
+// scanned again on refresh, urls of concurrent transactions may become
+// visible after urls with larger ids
+const refreshOverlap = 1000
+
+// CodeFilter tells which short urls are definitely not stored, so lookups
+// of random codes don't reach the storage. It is built from the storage,
+// updated with the codes created by the service and refreshed periodically
+// with the codes created by imports and other instances. Misses are answered
+// without the storage only if this process is the single writer of it, with
+// a shared storage they are looked up. Codes removed from the storage stay
+// in the filter and are looked up as before.
+type CodeFilter struct {
+	src      storage.Exporter
+	fpRate   float64
+	foldCase bool
+	// singleWriter is set when only this process writes to the storage,
+	// otherwise codes missing in the filter may be stored already
+	singleWriter bool
+
+	filter atomic.Pointer[bloom.Filter]
+	// external is set when the last refresh found codes created past
+	// the filter, codes it doesn't know may be stored then
+	external atomic.Bool
+
+	// refreshMutex serializes refreshes, lastID is guarded by it
+	refreshMutex sync.Mutex
+	lastID       int64
+
+	// addMutex guards pending, codes added while the filter is rebuilt
+	// are kept in pending and added to the new filter
+	addMutex sync.Mutex
+	pending  []string
+}
+
+// CodeFilterOption configures a CodeFilter
+type CodeFilterOption func(*CodeFilter)
+
+// WithSingleWriter tells the filter that only this process writes to the
+// storage, e.g. to the memory storage, so it knows every stored code
+func WithSingleWriter() CodeFilterOption {
+	return func(f *CodeFilter) {
+		f.singleWriter = true
+	}
+}
+
+// NewCodeFilter builds a filter of the short urls of src sized for at least
+// capacity urls, fpRate is the share of missing codes looked up anyway.
+// The filter is rebuilt twice as large when the storage outgrows it.
+func NewCodeFilter(ctx context.Context, src storage.Exporter, capacity int64, fpRate float64, foldCase bool, opts ...CodeFilterOption) (*CodeFilter, error) {
+	const op = "service.NewCodeFilter"
+
+	f := &CodeFilter{
+		src:      src,
+		fpRate:   fpRate,
+		foldCase: foldCase,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if err := f.rebuild(ctx, uint64(max(capacity, 1))); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return f, nil
+}
+
+// MayExist reports false if shortURL is not in the filter
+func (f *CodeFilter) MayExist(shortURL string) bool {
+	return f.filter.Load().MayContain(f.key(shortURL))
+}
+
+// Complete reports whether the filter knows every stored code, so a code
+// it doesn't contain is definitely not stored. Only a filter of the single
+// writer of the storage does, and only while its last refresh found no codes
+// created past it, e.g. by an import; codes created by other instances
+// aren't known until the next refresh.
+func (f *CodeFilter) Complete() bool {
+	return f.singleWriter && !f.external.Load()
+}
+
+// Add adds a stored short url
+func (f *CodeFilter) Add(shortURL string) {
+	key := f.key(shortURL)
+
+	f.addMutex.Lock()
+	defer f.addMutex.Unlock()
+
+	if f.pending != nil {
+		f.pending = append(f.pending, key)
+	}
+	f.filter.Load().Add(key)
+}
+
+// Refresh adds the short urls stored since the last refresh,
+// the filter is rebuilt if it is full
+func (f *CodeFilter) Refresh(ctx context.Context) error {
+	const op = "service.CodeFilter.Refresh"
+
+	f.refreshMutex.Lock()
+	defer f.refreshMutex.Unlock()
+
+	filter := f.filter.Load()
+	if filter.Added() > filter.Capacity() {
+		slog.Info("code filter is full, rebuilding", "capacity", filter.Capacity())
+		if err := f.rebuildLocked(ctx, filter.Capacity()*2); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return nil
+	}
+
+	external := false
+	err := f.src.ForEachURL(ctx, max(f.lastID-refreshOverlap, 0), func(url models.Url) error {
+		if !f.MayExist(url.ShortURL) {
+			external = true
+			f.Add(url.ShortURL)
+		}
+		f.lastID = max(f.lastID, url.Id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if external != f.external.Load() {
+		slog.Info("code filter found codes created elsewhere", "found", external)
+	}
+	f.external.Store(external)
+	return nil
+}
+
+// rebuild builds a new filter of capacity urls from the storage
+func (f *CodeFilter) rebuild(ctx context.Context, capacity uint64) error {
+	f.refreshMutex.Lock()
+	defer f.refreshMutex.Unlock()
+
+	return f.rebuildLocked(ctx, capacity)
+}
+
+func (f *CodeFilter) rebuildLocked(ctx context.Context, capacity uint64) error {
+	start := time.Now()
+
+	f.addMutex.Lock()
+	f.pending = make([]string, 0)
+	f.addMutex.Unlock()
+
+	var codes []string
+	var lastID int64
+	err := f.src.ForEachURL(ctx, 0, func(url models.Url) error {
+		codes = append(codes, f.key(url.ShortURL))
+		lastID = max(lastID, url.Id)
+		return nil
+	})
+	if err == nil {
+		capacity = max(capacity, uint64(len(codes))*2)
+	}
+
+	var filter *bloom.Filter
+	if err == nil {
+		filter, err = bloom.New(capacity, f.fpRate)
+	}
+	if err != nil {
+		f.addMutex.Lock()
+		f.pending = nil
+		f.addMutex.Unlock()
+		return err
+	}
+
+	for _, code := range codes {
+		filter.Add(code)
+	}
+
+	f.addMutex.Lock()
+	for _, code := range f.pending {
+		filter.Add(code)
+	}
+	f.pending = nil
+	f.filter.Store(filter)
+	f.addMutex.Unlock()
+
+	f.lastID = lastID
+	slog.Info("built code filter", "urls", len(codes), "capacity", capacity, "duration", time.Since(start))
+	return nil
+}
+
+// key brings shortURL to the form it is stored in
+func (f *CodeFilter) key(shortURL string) string {
+	if f.foldCase {
+		return strings.ToLower(shortURL)
+	}
+	return shortURL
+}
+
+// RefreshCodeFilter refreshes f every interval until ctx is done
+func RefreshCodeFilter(ctx context.Context, f *CodeFilter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.Refresh(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("failed to refresh code filter", "error", err)
+			}
+		}
+	}
+}
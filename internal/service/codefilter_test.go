@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/mocks"
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/hard-gainer/url-shortener/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeFilter_BuiltFromStorage(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewMemory()
+	require.NoError(t, err)
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "Stored1", OriginalURL: "https://example.com"})
+	require.NoError(t, err)
+
+	filter, err := NewCodeFilter(ctx, repo.(storage.Exporter), 100, 0.001, true)
+	require.NoError(t, err)
+
+	assert.True(t, filter.MayExist("stored1"), "codes are folded")
+	assert.False(t, filter.MayExist("missing"))
+
+	filter.Add("added1")
+	assert.True(t, filter.MayExist("added1"))
+}
+
+func TestCodeFilter_Refresh(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewMemory()
+	require.NoError(t, err)
+
+	filter, err := NewCodeFilter(ctx, repo.(storage.Exporter), 4, 0.001, false)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: fmt.Sprintf("code%d", i), OriginalURL: fmt.Sprintf("https://example.com/%d", i)})
+		require.NoError(t, err)
+	}
+	assert.False(t, filter.MayExist("code0"), "urls saved past the service are unknown until refresh")
+
+	require.NoError(t, filter.Refresh(ctx))
+	require.NoError(t, filter.Refresh(ctx), "the second refresh rebuilds the full filter")
+	assert.GreaterOrEqual(t, filter.filter.Load().Capacity(), uint64(40))
+	for i := 0; i < 20; i++ {
+		assert.True(t, filter.MayExist(fmt.Sprintf("code%d", i)))
+	}
+}
+
+func TestGetOriginalURL_CodeFilter(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewMemory()
+	require.NoError(t, err)
+	filter, err := NewCodeFilter(ctx, repo.(storage.Exporter), 100, 0.001, false, WithSingleWriter())
+	require.NoError(t, err)
+
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo, WithCodeFilter(filter))
+
+	_, err = service.GetOriginalURL(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound)
+	mockRepo.AssertNotCalled(t, "GetURL")
+
+	mockRepo.On("OriginalURLExists", ctx, "https://example.com/").Return("", false, nil)
+	mockRepo.On("GetOrCreate", ctx, urlWith("", "https://example.com/")).Return(asCreated, true, nil)
+	shortURL, err := service.ShortenURL(ctx, "https://example.com/")
+	require.NoError(t, err)
+
+	mockRepo.On("GetURL", ctx, shortURL).Return(models.Url{ShortURL: shortURL, OriginalURL: "https://example.com/"}, nil)
+	originalURL, err := service.GetOriginalURL(ctx, shortURL)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/", originalURL)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetOriginalURL_CodeFilterWithExternalWrites(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewMemory()
+	require.NoError(t, err)
+	filter, err := NewCodeFilter(ctx, repo.(storage.Exporter), 100, 0.001, false, WithSingleWriter())
+	require.NoError(t, err)
+	assert.True(t, filter.Complete())
+
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "imported1", OriginalURL: "https://example.com/1"})
+	require.NoError(t, err)
+	require.NoError(t, filter.Refresh(ctx))
+	assert.False(t, filter.Complete(), "codes were created past the filter")
+
+	service := NewURLService(repo, WithCodeFilter(filter))
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "imported2", OriginalURL: "https://example.com/2"})
+	require.NoError(t, err)
+
+	originalURL, err := service.GetOriginalURL(ctx, "imported2")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/2", originalURL)
+	assert.True(t, filter.MayExist("imported2"), "found codes are added to the filter")
+
+	require.NoError(t, filter.Refresh(ctx))
+	assert.True(t, filter.Complete(), "the refresh found no new codes")
+	_, err = service.GetOriginalURL(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound)
+}
+
+func TestCodeFilter_RefreshOverlapIsNotCountedAgain(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewMemory()
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: fmt.Sprintf("code%d", i), OriginalURL: fmt.Sprintf("https://example.com/%d", i)})
+		require.NoError(t, err)
+	}
+
+	filter, err := NewCodeFilter(ctx, repo.(storage.Exporter), 30, 0.001, false)
+	require.NoError(t, err)
+	capacity := filter.filter.Load().Capacity()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, filter.Refresh(ctx))
+	}
+	assert.Equal(t, capacity, filter.filter.Load().Capacity(), "the filter is not rebuilt")
+	assert.LessOrEqual(t, filter.filter.Load().Added(), uint64(20))
+}
+
+func TestGetOriginalURL_SharedStorageMissesAreLookedUp(t *testing.T) {
+	ctx := context.Background()
+	repo, err := memory.NewMemory()
+	require.NoError(t, err)
+	filter, err := NewCodeFilter(ctx, repo.(storage.Exporter), 100, 0.001, false)
+	require.NoError(t, err)
+	assert.False(t, filter.Complete(), "other instances may create codes")
+
+	service := NewURLService(repo, WithCodeFilter(filter))
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "elsewhere", OriginalURL: "https://example.com/"})
+	require.NoError(t, err)
+
+	originalURL, err := service.GetOriginalURL(ctx, "elsewhere")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/", originalURL)
+}
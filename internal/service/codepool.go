@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/hard-gainer/url-shortener/internal/metrics"
+)
+
+// CodeReserver reserves codes in the storage so nobody else can take them
+type CodeReserver interface {
+	// ReserveCodes reserves the free codes and returns them
+	ReserveCodes(ctx context.Context, codes []string) ([]string, error)
+	// ReleaseCodes returns unused reserved codes
+	ReleaseCodes(ctx context.Context, codes []string) error
+}
+
+// CodePool hands out codes generated and reserved ahead of time.
+// A background worker refills the pool in batches when it runs low,
+// so shortening doesn't wait for code generation.
+type CodePool struct {
+	generator CodeGenerator
+	reserver  CodeReserver
+	codes     chan string
+	lowWater  int
+	batchSize int
+	refill    chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewCodePool creates a pool of size codes taken from generator and reserved
+// with reserver, it is refilled by batchSize codes when fewer than lowWater are left
+func NewCodePool(generator CodeGenerator, reserver CodeReserver, size, lowWater, batchSize int) (*CodePool, error) {
+	const op = "service.NewCodePool"
+
+	if size <= 0 || lowWater < 0 || lowWater >= size || batchSize <= 0 {
+		return nil, fmt.Errorf("%s: invalid pool size %d, low water %d or batch size %d", op, size, lowWater, batchSize)
+	}
+
+	p := &CodePool{
+		generator: generator,
+		reserver:  reserver,
+		codes:     make(chan string, size),
+		lowWater:  lowWater,
+		batchSize: batchSize,
+		refill:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.run()
+	p.triggerRefill()
+
+	return p, nil
+}
+
+// Generate returns a reserved code, falling back to the generator
+// when the pool is empty
+func (p *CodePool) Generate(ctx context.Context) (string, error) {
+	select {
+	case code := <-p.codes:
+		metrics.CodePoolHits.Add(1)
+		if len(p.codes) < p.lowWater {
+			p.triggerRefill()
+		}
+		return code, nil
+	default:
+		metrics.CodePoolMisses.Add(1)
+		p.triggerRefill()
+		return p.generator.Generate(ctx)
+	}
+}
+
+// Release returns codes taken from the pool which weren't used,
+// so they don't stay reserved
+func (p *CodePool) Release(ctx context.Context, codes []string) error {
+	const op = "service.CodePool.Release"
+
+	if err := p.reserver.ReleaseCodes(ctx, codes); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Close stops the refill and releases the codes left in the pool
+func (p *CodePool) Close(ctx context.Context) error {
+	const op = "service.CodePool.Close"
+	var err error
+
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+
+		var unused []string
+		for len(p.codes) > 0 {
+			unused = append(unused, <-p.codes)
+		}
+		if len(unused) == 0 {
+			return
+		}
+
+		if releaseErr := p.reserver.ReleaseCodes(ctx, unused); releaseErr != nil {
+			err = fmt.Errorf("%s: %w", op, releaseErr)
+			return
+		}
+		slog.Info("released unused reserved codes", "count", len(unused))
+	})
+
+	return err
+}
+
+// triggerRefill wakes up the refill worker if it is idle
+func (p *CodePool) triggerRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// run refills the pool on demand until the pool is closed
+func (p *CodePool) run() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.refill:
+			p.fill()
+		}
+	}
+}
+
+// fill generates and reserves batches of codes until the pool is full
+func (p *CodePool) fill() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		free := cap(p.codes) - len(p.codes)
+		if free <= 0 {
+			return
+		}
+
+		batch := make([]string, 0, min(free, p.batchSize))
+		for len(batch) < cap(batch) {
+			code, err := p.generator.Generate(ctx)
+			if err != nil {
+				slog.Error("failed to generate codes for the pool", "error", err)
+				return
+			}
+			batch = append(batch, code)
+		}
+
+		reserved, err := p.reserver.ReserveCodes(ctx, batch)
+		if err != nil {
+			slog.Error("failed to reserve codes for the pool", "error", err)
+			return
+		}
+		metrics.CodePoolReserved.Add(int64(len(reserved)))
+		if len(reserved) == 0 {
+			// every generated code is taken, the keyspace is nearly full or the
+			// generator repeats itself; the next miss of the pool retries
+			slog.Warn("no free codes reserved for the pool", "generated", len(batch))
+			return
+		}
+
+		for i, code := range reserved {
+			select {
+			case p.codes <- code:
+			default:
+				p.release(reserved[i:])
+				return
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// release returns codes which didn't fit into the pool
+func (p *CodePool) release(codes []string) {
+	if err := p.reserver.ReleaseCodes(context.Background(), codes); err != nil {
+		slog.Error("failed to release reserved codes", "error", err)
+	}
+}
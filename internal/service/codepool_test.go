@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReserver is a CodeReserver which remembers reserved codes
+type fakeReserver struct {
+	mutex    sync.Mutex
+	taken    map[string]bool
+	reserved map[string]bool
+}
+
+func newFakeReserver(taken ...string) *fakeReserver {
+	r := &fakeReserver{taken: make(map[string]bool), reserved: make(map[string]bool)}
+	for _, code := range taken {
+		r.taken[code] = true
+	}
+	return r
+}
+
+func (r *fakeReserver) ReserveCodes(ctx context.Context, codes []string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var result []string
+	for _, code := range codes {
+		if !r.taken[code] && !r.reserved[code] {
+			r.reserved[code] = true
+			result = append(result, code)
+		}
+	}
+	return result, nil
+}
+
+func (r *fakeReserver) ReleaseCodes(ctx context.Context, codes []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, code := range codes {
+		delete(r.reserved, code)
+	}
+	return nil
+}
+
+func (r *fakeReserver) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.reserved)
+}
+
+func newTestSequenceGenerator(t *testing.T) *SequenceGenerator {
+	generator, err := NewSequenceGenerator(&counterIDSource{}, []byte("secret"), ShortURLLength, Charset)
+	require.NoError(t, err)
+	return generator
+}
+
+func TestCodePool_FillsInBackground(t *testing.T) {
+	reserver := newFakeReserver()
+	pool, err := NewCodePool(newTestSequenceGenerator(t), reserver, 50, 10, 20)
+	require.NoError(t, err)
+	defer pool.Close(context.Background())
+
+	require.Eventually(t, func() bool { return len(pool.codes) == 50 },
+		time.Second, time.Millisecond)
+	assert.Equal(t, 50, reserver.count())
+}
+
+func TestCodePool_HandsOutReservedCodes(t *testing.T) {
+	reserver := newFakeReserver()
+	pool, err := NewCodePool(newTestSequenceGenerator(t), reserver, 20, 5, 10)
+	require.NoError(t, err)
+	defer pool.Close(context.Background())
+	ctx := context.Background()
+
+	require.Eventually(t, func() bool { return len(pool.codes) == 20 },
+		time.Second, time.Millisecond)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := pool.Generate(ctx)
+		require.NoError(t, err)
+		require.False(t, seen[code], "code %s is handed out twice", code)
+		seen[code] = true
+	}
+}
+
+func TestCodePool_SkipsTakenCodes(t *testing.T) {
+	expected := newTestSequenceGenerator(t)
+	ctx := context.Background()
+	taken, err := expected.Generate(ctx)
+	require.NoError(t, err)
+
+	reserver := newFakeReserver(taken)
+	pool, err := NewCodePool(newTestSequenceGenerator(t), reserver, 10, 2, 10)
+	require.NoError(t, err)
+	defer pool.Close(ctx)
+
+	require.Eventually(t, func() bool { return len(pool.codes) == 10 },
+		time.Second, time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		code, err := pool.Generate(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, taken, code)
+	}
+}
+
+// countingReserver is a CodeReserver which never has free codes
+type countingReserver struct {
+	mutex sync.Mutex
+	calls int
+}
+
+func (r *countingReserver) ReserveCodes(ctx context.Context, codes []string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls++
+	return nil, nil
+}
+
+func (r *countingReserver) ReleaseCodes(ctx context.Context, codes []string) error {
+	return nil
+}
+
+func TestCodePool_StopsFillingWithoutFreeCodes(t *testing.T) {
+	reserver := &countingReserver{}
+	pool, err := NewCodePool(newTestSequenceGenerator(t), reserver, 10, 2, 5)
+	require.NoError(t, err)
+	defer pool.Close(context.Background())
+
+	require.Eventually(t, func() bool {
+		reserver.mutex.Lock()
+		defer reserver.mutex.Unlock()
+		return reserver.calls > 0
+	}, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	reserver.mutex.Lock()
+	defer reserver.mutex.Unlock()
+	assert.Equal(t, 1, reserver.calls, "the refill gives up until the next miss")
+}
+
+func TestCodePool_CloseReleasesUnusedCodes(t *testing.T) {
+	reserver := newFakeReserver()
+	pool, err := NewCodePool(newTestSequenceGenerator(t), reserver, 30, 5, 30)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.Eventually(t, func() bool { return len(pool.codes) == 30 },
+		time.Second, time.Millisecond)
+
+	_, err = pool.Generate(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, pool.Close(ctx))
+	assert.Equal(t, 1, reserver.count(), "only the handed out code stays reserved")
+}
+
+func TestNewCodePool_Validation(t *testing.T) {
+	_, err := NewCodePool(newTestSequenceGenerator(t), newFakeReserver(), 0, 0, 10)
+	assert.Error(t, err)
+
+	_, err = NewCodePool(newTestSequenceGenerator(t), newFakeReserver(), 10, 10, 10)
+	assert.Error(t, err)
+}
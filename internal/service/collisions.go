@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/metrics"
+)
+
+// DefaultCollisionWindow is a number of recent ShortenURL calls
+// over which the collision rate is computed
+const DefaultCollisionWindow = 1000
+
+// lengthener is a code generator whose code length can be changed
+type lengthener interface {
+	Length() int
+	SetLength(length int)
+}
+
+// lengthenerOf returns the generator whose code length can be changed,
+// for a code pool it is the generator the pool is filled from, the codes
+// already in the pool keep their length
+func lengthenerOf(generator CodeGenerator) (lengthener, bool) {
+	if pool, ok := generator.(*CodePool); ok {
+		generator = pool.generator
+	}
+	g, ok := generator.(lengthener)
+	return g, ok
+}
+
+// callStats holds the numbers of attempts and collisions of a ShortenURL call
+type callStats struct {
+	attempts   int
+	collisions int
+}
+
+// collisionWindow keeps the numbers of attempts and collisions
+// of the recent ShortenURL calls
+type collisionWindow struct {
+	mutex      sync.Mutex
+	calls      []callStats
+	next       int
+	filled     bool
+	attempts   int64
+	collisions int64
+}
+
+// newCollisionWindow creates a collisionWindow over size calls
+func newCollisionWindow(size int) *collisionWindow {
+	return &collisionWindow{calls: make([]callStats, max(size, 1))}
+}
+
+// add records a call. It reports whether the window is full and its
+// collision rate reached threshold, the window is cleared then, so
+// a single crossing is reported once.
+func (w *collisionWindow) add(call callStats, threshold float64) (rate float64, exceeded bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	old := w.calls[w.next]
+	w.attempts += int64(call.attempts - old.attempts)
+	w.collisions += int64(call.collisions - old.collisions)
+	w.calls[w.next] = call
+	w.next = (w.next + 1) % len(w.calls)
+	if w.next == 0 {
+		w.filled = true
+	}
+
+	rate = w.rateLocked()
+	if threshold <= 0 || !w.filled || rate < threshold {
+		return rate, false
+	}
+
+	clear(w.calls)
+	w.next, w.filled, w.attempts, w.collisions = 0, false, 0, 0
+	return rate, true
+}
+
+// stats returns the numbers of attempts and collisions in the window
+func (w *collisionWindow) stats() (attempts, collisions int64, rate float64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.attempts, w.collisions, w.rateLocked()
+}
+
+// rateLocked returns the share of colliding attempts
+func (w *collisionWindow) rateLocked() float64 {
+	if w.attempts == 0 {
+		return 0
+	}
+	return float64(w.collisions) / float64(w.attempts)
+}
+
+// recordCall publishes the attempts and collisions of a ShortenURL call and
+// lengthens the codes of policy when the collision rate crossed the threshold
+func (s *URLServiceImpl) recordCall(policy CodePolicy, generator CodeGenerator, call callStats) {
+	metrics.CodeAttempts.Add(int64(call.attempts))
+	metrics.CodeCollisions.Add(int64(call.collisions))
+
+	rate, exceeded := s.collisions[policy.Name].add(call, s.collisionThreshold)
+	metrics.SetFloat(metrics.CodeCollisionRate, policy.Name, rate)
+	if !exceeded {
+		return
+	}
+
+	g, ok := lengthenerOf(generator)
+	if !ok {
+		slog.Warn("collision rate exceeded the threshold, the generator can't lengthen codes",
+			"policy", policy.Name, "rate", rate)
+		return
+	}
+
+	length := g.Length()
+	if length >= policy.maxLength() {
+		slog.Warn("collision rate exceeded the threshold, codes are at the max length",
+			"policy", policy.Name, "rate", rate, "length", length)
+		return
+	}
+
+	g.SetLength(length + 1)
+	metrics.SetInt(metrics.CodeLength, policy.Name, int64(length+1))
+	slog.Warn("collision rate exceeded the threshold, lengthening codes",
+		"policy", policy.Name, "rate", rate, "length", length+1)
+}
+
+// codeLength returns the current length of codes generated for policy
+func (s *URLServiceImpl) codeLength(policy CodePolicy) int {
+	if g, ok := lengthenerOf(s.generatorFor(policy)); ok {
+		return g.Length()
+	}
+	return policy.Length
+}
+
+// PolicyStats are statistics of code generation of a policy.
+// MaxUtilisation is the share of the keyspace of the policy used if all
+// the stored links had its codes, the storage doesn't count links by
+// policy, so it is an upper bound.
+type PolicyStats struct {
+	Policy         string
+	Length         int
+	Capacity       float64
+	MaxUtilisation float64
+	Attempts       int64
+	Collisions     int64
+	CollisionRate  float64
+}
+
+// Stats are statistics of the stored links and code generation
+type Stats struct {
+	Links    int64
+	Policies []PolicyStats
+}
+
+// Stats returns the number of links and, for every code policy, the upper
+// bound of the keyspace utilisation and the collision rate over the recent calls
+func (s *URLServiceImpl) Stats(ctx context.Context) (Stats, error) {
+	const op = "service.URLServiceImpl.Stats"
+
+	links, err := s.repo.CountURLs(ctx)
+	if err != nil {
+		return Stats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stats := Stats{Links: links}
+	for _, policy := range s.policies.Policies() {
+		length := s.codeLength(policy)
+		capacity := policy.capacityAt(length)
+		attempts, collisions, rate := s.collisions[policy.Name].stats()
+
+		stats.Policies = append(stats.Policies, PolicyStats{
+			Policy:         policy.Name,
+			Length:         length,
+			Capacity:       capacity,
+			MaxUtilisation: float64(links) / capacity,
+			Attempts:       attempts,
+			Collisions:     collisions,
+			CollisionRate:  rate,
+		})
+		metrics.SetFloat(metrics.CodeKeyspaceMaxUtilisation, policy.Name, float64(links)/capacity)
+	}
+
+	return stats, nil
+}
+
+// MonitorKeyspace refreshes the keyspace utilisation bounds of s every
+// interval until ctx is done
+func MonitorKeyspace(ctx context.Context, s URLService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Stats(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("failed to refresh keyspace utilisation", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/mocks"
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollisionWindow_Add(t *testing.T) {
+	w := newCollisionWindow(3)
+
+	_, exceeded := w.add(callStats{attempts: 2, collisions: 1}, 0.5)
+	assert.False(t, exceeded, "window isn't full yet")
+	_, exceeded = w.add(callStats{attempts: 2, collisions: 1}, 0.5)
+	assert.False(t, exceeded, "window isn't full yet")
+
+	rate, exceeded := w.add(callStats{attempts: 1}, 0.5)
+	assert.False(t, exceeded)
+	assert.InDelta(t, 0.4, rate, 1e-9)
+
+	// the first call is pushed out of the window
+	rate, exceeded = w.add(callStats{attempts: 3, collisions: 2}, 0.5)
+	assert.True(t, exceeded)
+	assert.InDelta(t, 0.5, rate, 1e-9)
+
+	attempts, collisions, rate := w.stats()
+	assert.Zero(t, attempts, "window is cleared after the crossing")
+	assert.Zero(t, collisions)
+	assert.Zero(t, rate)
+}
+
+func TestShortenURL_LengthensCodesOnCollisions(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	policies, err := NewPolicySet(CodePolicy{
+		Name:           "default",
+		Length:         4,
+		MaxLength:      5,
+		Alphabet:       Alphabets["base36"],
+		CaseSensitive:  true,
+		AliasMinLength: DefaultAliasMinLength,
+		AliasMaxLength: DefaultAliasMaxLength,
+	})
+	require.NoError(t, err)
+	service := NewURLService(mockRepo, WithPolicies(policies), WithCollisionThreshold(0.5, 2))
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	isShort := mock.MatchedBy(func(u models.Url) bool { return len(u.ShortURL) == 4 })
+	isLong := mock.MatchedBy(func(u models.Url) bool { return len(u.ShortURL) == 5 })
+	mockRepo.On("OriginalURLExists", ctx, originalURL).
+		Return("", false, nil)
+	for i := 0; i < 2; i++ {
+		mockRepo.On("GetOrCreate", ctx, isShort).
+			Return(models.Url{}, false, storage.ErrURLMappingExists).Once()
+		mockRepo.On("GetOrCreate", ctx, isShort).
+			Return(asCreated, true, nil).Once()
+	}
+	mockRepo.On("GetOrCreate", ctx, isLong).
+		Return(asCreated, true, nil)
+
+	// every call collides once, so the rate over the window of 2 calls is 0.5
+	for i := 0; i < 2; i++ {
+		shortURL, err := service.ShortenURL(ctx, originalURL)
+		require.NoError(t, err)
+		assert.Len(t, shortURL, 4)
+	}
+
+	shortURL, err := service.ShortenURL(ctx, originalURL)
+	require.NoError(t, err)
+	assert.Len(t, shortURL, 5, "codes are lengthened after the threshold is crossed")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestLengthenerOf_CodePool(t *testing.T) {
+	random := NewRandomGenerator(4, Alphabets["base36"])
+	pool, err := NewCodePool(random, newFakeReserver(), 10, 2, 5)
+	require.NoError(t, err)
+	defer pool.Close(context.Background())
+
+	g, ok := lengthenerOf(pool)
+	require.True(t, ok, "a pool lengthens the codes of its generator")
+	g.SetLength(5)
+	assert.Equal(t, 5, random.Length())
+
+	_, ok = lengthenerOf(&CodePool{generator: newTestSequenceGenerator(t)})
+	assert.False(t, ok, "sequence codes can't be lengthened")
+}
+
+func TestStats(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("CountURLs", ctx).Return(int64(1000), nil)
+
+	stats, err := service.Stats(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), stats.Links)
+	require.Len(t, stats.Policies, 1)
+	assert.Equal(t, DefaultPolicy.Name, stats.Policies[0].Policy)
+	assert.Equal(t, ShortURLLength, stats.Policies[0].Length)
+	assert.InDelta(t, 1000/stats.Policies[0].Capacity, stats.Policies[0].MaxUtilisation, 1e-18)
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Destination is a url being shortened
+type Destination struct {
+	// Raw is the url as it was requested
+	Raw string
+	URL *url.URL
+}
+
+// DestinationRule checks whether a url may be shortened,
+// it returns an error wrapping ErrInvalidDestination if not
+type DestinationRule interface {
+	Check(ctx context.Context, d Destination) error
+}
+
+// DestinationRuleFunc is a function used as a DestinationRule
+type DestinationRuleFunc func(ctx context.Context, d Destination) error
+
+// Check calls f
+func (f DestinationRuleFunc) Check(ctx context.Context, d Destination) error {
+	return f(ctx, d)
+}
+
+// DestinationPolicy decides which urls may be shortened
+type DestinationPolicy struct {
+	rules []DestinationRule
+}
+
+// NewDestinationPolicy creates a policy which accepts urls passing all rules,
+// rules are checked in order
+func NewDestinationPolicy(rules ...DestinationRule) *DestinationPolicy {
+	return &DestinationPolicy{rules: rules}
+}
+
+// Check returns the error of the first rule rawURL doesn't pass
+func (p *DestinationPolicy) Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDestination, err)
+	}
+
+	d := Destination{Raw: rawURL, URL: u}
+	for _, rule := range p.rules {
+		if err := rule.Check(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowSchemes accepts only urls with one of schemes
+func AllowSchemes(schemes ...string) DestinationRule {
+	allowed := make(map[string]bool, len(schemes))
+	for _, scheme := range schemes {
+		allowed[strings.ToLower(scheme)] = true
+	}
+
+	return DestinationRuleFunc(func(_ context.Context, d Destination) error {
+		if !allowed[strings.ToLower(d.URL.Scheme)] {
+			return fmt.Errorf("%w: %q", ErrSchemeNotAllowed, d.URL.Scheme)
+		}
+		return nil
+	})
+}
+
+// MaxURLLength accepts only urls of at most n bytes
+func MaxURLLength(n int) DestinationRule {
+	return DestinationRuleFunc(func(_ context.Context, d Destination) error {
+		if len(d.Raw) > n {
+			return fmt.Errorf("%w: %d bytes, at most %d are allowed", ErrURLTooLong, len(d.Raw), n)
+		}
+		return nil
+	})
+}
+
+// FilterHosts rejects urls whose host matches a denied pattern and, if
+// allowed is not empty, urls whose host matches no allowed pattern.
+// A pattern is a host name where a * label matches exactly one label,
+// so *.example.com matches www.example.com but neither a.b.example.com
+// nor example.com itself, which is listed separately if needed.
+func FilterHosts(allowed, denied []string) (DestinationRule, error) {
+	for _, pattern := range append(append([]string(nil), allowed...), denied...) {
+		if err := validateHostPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return DestinationRuleFunc(func(_ context.Context, d Destination) error {
+		host := normalizeHost(d.URL.Hostname())
+		if host == "" {
+			return fmt.Errorf("%w: url has no host", ErrHostNotAllowed)
+		}
+
+		if matchHost(denied, host) {
+			return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+		}
+		if len(allowed) > 0 && !matchHost(allowed, host) {
+			return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+		}
+		return nil
+	}), nil
+}
+
+// validateHostPattern checks that * is used only as a whole label
+func validateHostPattern(pattern string) error {
+	labels := strings.Split(normalizeHost(pattern), ".")
+	for _, label := range labels {
+		if label == "" || label != "*" && strings.Contains(label, "*") {
+			return fmt.Errorf("invalid host pattern %q: * must be a whole label", pattern)
+		}
+	}
+	return nil
+}
+
+// matchHost checks if host matches one of patterns label by label
+func matchHost(patterns []string, host string) bool {
+	hostLabels := strings.Split(host, ".")
+	for _, pattern := range patterns {
+		labels := strings.Split(normalizeHost(pattern), ".")
+		if len(labels) != len(hostLabels) {
+			continue
+		}
+
+		matched := true
+		for i, label := range labels {
+			if label != "*" && label != hostLabels[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupTimeout limits the resolution of a host name
+const lookupTimeout = 2 * time.Second
+
+// DenyPrivateAddresses rejects urls pointing at loopback, private, link-local,
+// shared, benchmarking and unspecified addresses or at localhost. Host names
+// are resolved within lookupTimeout if resolve is set, names which can't be
+// resolved are rejected then.
+func DenyPrivateAddresses(resolve bool) DestinationRule {
+	return DestinationRuleFunc(func(ctx context.Context, d Destination) error {
+		host := normalizeHost(d.URL.Hostname())
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+		}
+
+		if addr, ok := parseHostAddr(host); ok {
+			if isPrivateAddr(addr) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		}
+
+		if !resolve || host == "" {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		defer cancel()
+
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil || len(addrs) == 0 {
+			return fmt.Errorf("%w: %s can't be resolved", ErrHostNotAllowed, host)
+		}
+		for _, addr := range addrs {
+			if isPrivateAddr(addr) {
+				return fmt.Errorf("%w: %s resolves to %s", ErrPrivateAddress, host, addr)
+			}
+		}
+		return nil
+	})
+}
+
+// privatePrefixes are the ranges not reachable from the internet
+var privatePrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+}
+
+// embeddedIPv4 are IPv6 ranges carrying an IPv4 address at the offset,
+// the IPv4 address is the one actually reached
+var embeddedIPv4 = []struct {
+	prefix netip.Prefix
+	offset int
+}{
+	{netip.MustParsePrefix("64:ff9b::/96"), 12}, // NAT64
+	{netip.MustParsePrefix("2002::/16"), 2},     // 6to4
+}
+
+// isPrivateAddr checks if addr is not reachable from the internet
+func isPrivateAddr(addr netip.Addr) bool {
+	addr = unwrapIPv4(addr)
+	if addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, prefix := range privatePrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapIPv4 returns the IPv4 address mapped or embedded into addr
+func unwrapIPv4(addr netip.Addr) netip.Addr {
+	addr = addr.Unmap()
+	for _, embedded := range embeddedIPv4 {
+		if embedded.prefix.Contains(addr) {
+			b := addr.As16()
+			return netip.AddrFrom4([4]byte(b[embedded.offset : embedded.offset+4]))
+		}
+	}
+	return addr
+}
+
+// parseHostAddr parses host as an IP address, including the IPv4 forms
+// browsers accept such as 2130706433, 0x7f.1 or 0177.0.0.1
+func parseHostAddr(host string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr, true
+	}
+
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		values[i] = value
+	}
+
+	// the last part fills the remaining bytes of the address
+	var ip uint64
+	for i, value := range values[:len(values)-1] {
+		if value > 0xff {
+			return netip.Addr{}, false
+		}
+		ip |= value << (8 * (3 - i))
+	}
+	last := values[len(values)-1]
+	if last >= 1<<(8*(5-len(values))) {
+		return netip.Addr{}, false
+	}
+	ip |= last
+
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)}), true
+}
+
+// requestHostKey is the context key of the host a request was sent to
+type requestHostKey struct{}
+
+// WithRequestHost returns ctx carrying the host the request was sent to,
+// DenySelf takes the shortener's own host from it without a base url
+func WithRequestHost(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, requestHostKey{}, host)
+}
+
+// DenySelf rejects urls pointing at the shortener itself, which would
+// redirect to another short url or back to the same one; baseURL is
+// the public url of the service. If it is empty, the host the request
+// was sent to, see WithRequestHost, is rejected.
+func DenySelf(baseURL string) (DestinationRule, error) {
+	var self string
+	if baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid base url %q", baseURL)
+		}
+		self = normalizeHost(u.Hostname())
+	}
+
+	return DestinationRuleFunc(func(ctx context.Context, d Destination) error {
+		self := self
+		if self == "" {
+			self = requestHost(ctx)
+		}
+		if self != "" && normalizeHost(d.URL.Hostname()) == self {
+			return fmt.Errorf("%w: %s", ErrRedirectLoop, self)
+		}
+		return nil
+	}), nil
+}
+
+// requestHost returns the host without the port ctx was given by WithRequestHost
+func requestHost(ctx context.Context) string {
+	host, _ := ctx.Value(requestHostKey{}).(string)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return normalizeHost(strings.Trim(host, "[]"))
+}
+
+// normalizeHost lowercases host and removes the trailing dot
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDestinationPolicy(t *testing.T, allowed, denied []string) *DestinationPolicy {
+	t.Helper()
+
+	hosts, err := FilterHosts(allowed, denied)
+	require.NoError(t, err)
+	self, err := DenySelf("https://sho.rt")
+	require.NoError(t, err)
+
+	return NewDestinationPolicy(
+		AllowSchemes("http", "https"),
+		MaxURLLength(100),
+		hosts,
+		self,
+		DenyPrivateAddresses(false),
+	)
+}
+
+func TestDestinationPolicy_Check(t *testing.T) {
+	policy := newTestDestinationPolicy(t, nil, []string{"*.evil.com", "evil.com"})
+
+	tests := map[string]error{
+		"https://example.com/path":                        nil,
+		"HTTP://Example.com":                              nil,
+		"https://10.0.0.1.example.com":                    nil,
+		"javascript:alert(1)":                             ErrSchemeNotAllowed,
+		"data:text/html,<script>alert(1)</script>":        ErrSchemeNotAllowed,
+		"file:///etc/passwd":                              ErrSchemeNotAllowed,
+		"https://example.com/" + strings.Repeat("a", 100): ErrURLTooLong,
+		"https://evil.com/":                               ErrHostNotAllowed,
+		"https://www.EVIL.com./":                          ErrHostNotAllowed,
+		"https:///path":                                   ErrHostNotAllowed,
+		"https://sho.rt/abc123":                           ErrRedirectLoop,
+		"https://SHO.RT:443/abc123":                       ErrRedirectLoop,
+		"http://localhost:8080":                           ErrPrivateAddress,
+		"http://127.0.0.1":                                ErrPrivateAddress,
+		"http://192.168.1.1/admin":                        ErrPrivateAddress,
+		"http://[::1]/":                                   ErrPrivateAddress,
+		"http://[::ffff:10.0.0.1]/":                       ErrPrivateAddress,
+		"http://169.254.169.254/latest/meta-data":         ErrPrivateAddress,
+		"http://2130706433/":                              ErrPrivateAddress,
+		"http://0x7f.1/":                                  ErrPrivateAddress,
+		"http://0.0.0.0/":                                 ErrPrivateAddress,
+		"http://0.1.2.3/":                                 ErrPrivateAddress,
+		"http://100.64.0.1/":                              ErrPrivateAddress,
+		"http://192.0.0.170/":                             ErrPrivateAddress,
+		"http://198.18.0.1/":                              ErrPrivateAddress,
+		"http://[64:ff9b::7f00:1]/":                       ErrPrivateAddress,
+		"http://[64:ff9b::a9fe:a9fe]/":                    ErrPrivateAddress,
+		"http://[2002:a00:1::]/":                          ErrPrivateAddress,
+		"http://[fd00::1]/":                               ErrPrivateAddress,
+		"http://[64:ff9b::808:808]/":                      nil,
+		"http://[2606:4700::1111]/":                       nil,
+		"http://8.8.8.8/":                                 nil,
+	}
+	for rawURL, expected := range tests {
+		err := policy.Check(context.Background(), rawURL)
+		if expected == nil {
+			assert.NoError(t, err, rawURL)
+			continue
+		}
+		assert.ErrorIs(t, err, expected, rawURL)
+		assert.ErrorIs(t, err, ErrInvalidDestination, rawURL)
+	}
+}
+
+func TestDestinationPolicy_AllowedHosts(t *testing.T) {
+	policy := newTestDestinationPolicy(t, []string{"example.com", "*.example.com"}, []string{"admin.example.com"})
+
+	assert.NoError(t, policy.Check(context.Background(), "https://example.com"))
+	assert.NoError(t, policy.Check(context.Background(), "https://docs.example.com"))
+	assert.ErrorIs(t, policy.Check(context.Background(), "https://admin.example.com"), ErrHostNotAllowed)
+	assert.ErrorIs(t, policy.Check(context.Background(), "https://example.org"), ErrHostNotAllowed)
+}
+
+func TestFilterHosts_WildcardMatchesOneLabel(t *testing.T) {
+	policy := newTestDestinationPolicy(t, []string{"*.example.com"}, nil)
+
+	assert.NoError(t, policy.Check(context.Background(), "https://www.example.com"))
+	assert.ErrorIs(t, policy.Check(context.Background(), "https://a.b.example.com"), ErrHostNotAllowed)
+	assert.ErrorIs(t, policy.Check(context.Background(), "https://example.com"), ErrHostNotAllowed, "the apex is not matched")
+	assert.ErrorIs(t, policy.Check(context.Background(), "https://evilexample.com"), ErrHostNotAllowed)
+}
+
+func TestFilterHosts_InvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"*example.com", "www.*.com*", "example..com", ""} {
+		_, err := FilterHosts([]string{pattern}, nil)
+		assert.Error(t, err, pattern)
+	}
+}
+
+func TestDenyPrivateAddresses_UnresolvableHost(t *testing.T) {
+	policy := NewDestinationPolicy(DenyPrivateAddresses(true))
+
+	err := policy.Check(context.Background(), "https://missing.invalid/")
+	assert.ErrorIs(t, err, ErrHostNotAllowed)
+}
+
+func TestShortenURL_DestinationPolicy(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo, WithDestinationPolicy(newTestDestinationPolicy(t, nil, nil)))
+
+	_, err := service.ShortenURL(context.Background(), "javascript:alert(1)")
+	assert.ErrorIs(t, err, ErrSchemeNotAllowed)
+	mockRepo.AssertNotCalled(t, "OriginalURLExists")
+	mockRepo.AssertNotCalled(t, "GetOrCreate")
+}
+
+func TestDenySelf_RequestHost(t *testing.T) {
+	self, err := DenySelf("")
+	require.NoError(t, err)
+	policy := NewDestinationPolicy(self)
+	ctx := WithRequestHost(context.Background(), "Sho.rt:8080")
+
+	assert.ErrorIs(t, policy.Check(ctx, "https://sho.rt/abc"), ErrRedirectLoop)
+	assert.NoError(t, policy.Check(ctx, "https://example.com/"))
+	assert.NoError(t, policy.Check(context.Background(), "https://sho.rt/abc"),
+		"without a base url or a request host nothing is rejected")
+
+	ctx = WithRequestHost(context.Background(), "[::1]:8080")
+	assert.ErrorIs(t, policy.Check(ctx, "http://[::1]/abc"), ErrRedirectLoop)
+}
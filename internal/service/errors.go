@@ -0,0 +1,36 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidAlias is returned when a custom alias doesn't match the code policy
+	ErrInvalidAlias = errors.New("invalid alias")
+
+	// ErrAliasTaken is returned when a custom alias is already used
+	ErrAliasTaken = errors.New("alias is already taken")
+
+	// ErrUnknownNamespace is returned when no code policy is defined for the namespace
+	ErrUnknownNamespace = errors.New("unknown namespace")
+
+	// ErrInvalidDestination is returned when the destination policy rejects a url,
+	// the errors below wrap it
+	ErrInvalidDestination = errors.New("invalid destination")
+
+	// ErrSchemeNotAllowed is returned when the scheme of a url is not allowed
+	ErrSchemeNotAllowed = fmt.Errorf("%w: scheme is not allowed", ErrInvalidDestination)
+
+	// ErrHostNotAllowed is returned when the host of a url is denied or not allowed
+	ErrHostNotAllowed = fmt.Errorf("%w: host is not allowed", ErrInvalidDestination)
+
+	// ErrPrivateAddress is returned when a url points at a private or loopback address
+	ErrPrivateAddress = fmt.Errorf("%w: private addresses are not allowed", ErrInvalidDestination)
+
+	// ErrURLTooLong is returned when a url is longer than allowed
+	ErrURLTooLong = fmt.Errorf("%w: url is too long", ErrInvalidDestination)
+
+	// ErrRedirectLoop is returned when a url points at the shortener itself
+	ErrRedirectLoop = fmt.Errorf("%w: url points at the shortener itself", ErrInvalidDestination)
+)
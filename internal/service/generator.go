@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"sync/atomic"
+)
+
+// feistelRounds is a number of rounds of the keyed permutation
+const feistelRounds = 8
+
+// CodeGenerator generates candidates for short urls
+type CodeGenerator interface {
+	Generate(ctx context.Context) (string, error)
+}
+
+// IDSource provides unique ids, e.g. a database sequence
+type IDSource interface {
+	NextID(ctx context.Context) (int64, error)
+}
+
+// RandomGenerator generates random codes, they may collide with existing ones
+type RandomGenerator struct {
+	length   atomic.Int64
+	alphabet string
+}
+
+// NewRandomGenerator creates a generator of random codes of length symbols from alphabet
+func NewRandomGenerator(length int, alphabet string) *RandomGenerator {
+	g := &RandomGenerator{alphabet: alphabet}
+	g.length.Store(int64(length))
+	return g
+}
+
+// Length returns the length of generated codes
+func (g *RandomGenerator) Length() int {
+	return int(g.length.Load())
+}
+
+// SetLength changes the length of generated codes
+func (g *RandomGenerator) SetLength(length int) {
+	g.length.Store(int64(length))
+}
+
+// Generate generates random string with specified length from the set of symbols
+func (g *RandomGenerator) Generate(ctx context.Context) (string, error) {
+	b := make([]byte, g.Length())
+
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(g.alphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = g.alphabet[n.Int64()]
+	}
+
+	return string(b), nil
+}
+
+// SequenceGenerator encodes unique ids into codes. The ids are shuffled
+// with a keyed permutation of the whole code space, so consecutive ids
+// give unrelated codes and codes can't be guessed without the key,
+// while distinct ids never give the same code.
+type SequenceGenerator struct {
+	ids      IDSource
+	perm     *permutation
+	length   int
+	alphabet string
+}
+
+// NewSequenceGenerator creates a generator of codes of length symbols
+// from alphabet for ids provided by ids
+func NewSequenceGenerator(ids IDSource, key []byte, length int, alphabet string) (*SequenceGenerator, error) {
+	const op = "service.NewSequenceGenerator"
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%s: key is required", op)
+	}
+
+	domain, err := codeSpace(length, alphabet)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &SequenceGenerator{
+		ids:      ids,
+		perm:     newPermutation(domain, key),
+		length:   length,
+		alphabet: alphabet,
+	}, nil
+}
+
+// Generate encodes the next id into a code
+func (g *SequenceGenerator) Generate(ctx context.Context) (string, error) {
+	const op = "service.SequenceGenerator.Generate"
+
+	id, err := g.ids.NextID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if id < 0 || uint64(id) >= g.perm.domain {
+		return "", fmt.Errorf("%s: id %d is out of the code space", op, id)
+	}
+
+	return encode(g.perm.permute(uint64(id)), g.length, g.alphabet), nil
+}
+
+// codeSpace returns the number of codes of length symbols from alphabet
+func codeSpace(length int, alphabet string) (uint64, error) {
+	if length <= 0 || len(alphabet) < 2 {
+		return 0, errors.New("invalid code length or alphabet")
+	}
+
+	domain := uint64(1)
+	for i := 0; i < length; i++ {
+		hi, lo := bits.Mul64(domain, uint64(len(alphabet)))
+		if hi != 0 || lo > math.MaxInt64 {
+			return 0, fmt.Errorf("code space of %d symbols of %d is too large", length, len(alphabet))
+		}
+		domain = lo
+	}
+
+	return domain, nil
+}
+
+// encode writes n in the alphabet base using exactly length symbols
+func encode(n uint64, length int, alphabet string) string {
+	base := uint64(len(alphabet))
+	b := make([]byte, length)
+
+	for i := length - 1; i >= 0; i-- {
+		b[i] = alphabet[n%base]
+		n /= base
+	}
+
+	return string(b)
+}
+
+// permutation is a keyed bijection of [0, domain) built from
+// a balanced Feistel network with cycle walking
+type permutation struct {
+	domain uint64
+	half   uint
+	mask   uint64
+	key    []byte
+}
+
+// newPermutation creates a permutation of [0, domain) defined by key
+func newPermutation(domain uint64, key []byte) *permutation {
+	width := uint(bits.Len64(domain - 1))
+	half := (width + 1) / 2
+	if half == 0 {
+		half = 1
+	}
+
+	return &permutation{
+		domain: domain,
+		half:   half,
+		mask:   1<<half - 1,
+		key:    key,
+	}
+}
+
+// permute maps x from [0, domain) to another value of [0, domain).
+// The network permutes a slightly larger power of two, so it is
+// applied again until the result falls into the domain.
+func (p *permutation) permute(x uint64) uint64 {
+	for {
+		x = p.feistel(x)
+		if x < p.domain {
+			return x
+		}
+	}
+}
+
+// feistel applies the Feistel network to x
+func (p *permutation) feistel(x uint64) uint64 {
+	left, right := x>>p.half, x&p.mask
+
+	for round := 0; round < feistelRounds; round++ {
+		left, right = right, left^(p.round(round, right)&p.mask)
+	}
+
+	return left<<p.half | right
+}
+
+// round is a keyed round function of the Feistel network
+func (p *permutation) round(round int, value uint64) uint64 {
+	var buf [9]byte
+	buf[0] = byte(round)
+	binary.BigEndian.PutUint64(buf[1:], value)
+
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(buf[:])
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counterIDSource is an IDSource backed by a counter
+type counterIDSource struct {
+	next atomic.Int64
+	err  error
+}
+
+func (s *counterIDSource) NextID(ctx context.Context) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.next.Add(1), nil
+}
+
+func TestRandomGenerator_Generate(t *testing.T) {
+	generator := NewRandomGenerator(ShortURLLength, Charset)
+
+	code, err := generator.Generate(context.Background())
+
+	require.NoError(t, err)
+	assert.Len(t, code, ShortURLLength)
+	for _, char := range code {
+		assert.True(t, strings.ContainsRune(Charset, char), "invalid character: %c", char)
+	}
+}
+
+func TestPermutation_IsBijection(t *testing.T) {
+	for _, domain := range []uint64{2, 16, 63, 1000, 4096} {
+		perm := newPermutation(domain, []byte("secret"))
+		seen := make(map[uint64]bool, domain)
+
+		for x := uint64(0); x < domain; x++ {
+			y := perm.permute(x)
+			require.Less(t, y, domain)
+			require.False(t, seen[y], "domain %d: %d is produced twice", domain, y)
+			seen[y] = true
+		}
+	}
+}
+
+func TestPermutation_DependsOnKey(t *testing.T) {
+	domain, err := codeSpace(ShortURLLength, Charset)
+	require.NoError(t, err)
+
+	first := newPermutation(domain, []byte("first"))
+	second := newPermutation(domain, []byte("second"))
+
+	differ := 0
+	for x := uint64(0); x < 100; x++ {
+		if first.permute(x) != second.permute(x) {
+			differ++
+		}
+	}
+	assert.Equal(t, 100, differ)
+}
+
+func TestSequenceGenerator_GeneratesUniqueCodes(t *testing.T) {
+	generator, err := NewSequenceGenerator(&counterIDSource{}, []byte("secret"), ShortURLLength, Charset)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	seen := make(map[string]bool)
+	var previous string
+	for i := 0; i < 10000; i++ {
+		code, err := generator.Generate(ctx)
+		require.NoError(t, err)
+		require.Len(t, code, ShortURLLength)
+		require.False(t, seen[code], "code %s is generated twice", code)
+		seen[code] = true
+
+		if previous != "" {
+			assert.NotEqual(t, previous[:ShortURLLength-1], code[:ShortURLLength-1],
+				"consecutive codes must not share a prefix")
+		}
+		previous = code
+
+		for _, char := range code {
+			require.True(t, strings.ContainsRune(Charset, char), "invalid character: %c", char)
+		}
+	}
+}
+
+func TestSequenceGenerator_IDSourceError(t *testing.T) {
+	expectedError := errors.New("sequence is unavailable")
+	generator, err := NewSequenceGenerator(&counterIDSource{err: expectedError}, []byte("secret"), ShortURLLength, Charset)
+	require.NoError(t, err)
+
+	_, err = generator.Generate(context.Background())
+
+	assert.ErrorIs(t, err, expectedError)
+}
+
+func TestNewSequenceGenerator_Validation(t *testing.T) {
+	_, err := NewSequenceGenerator(&counterIDSource{}, nil, ShortURLLength, Charset)
+	assert.Error(t, err, "key is required")
+
+	_, err = NewSequenceGenerator(&counterIDSource{}, []byte("secret"), 20, Charset)
+	assert.Error(t, err, "code space must fit into int64")
+
+	_, err = NewSequenceGenerator(&counterIDSource{}, []byte("secret"), 0, Charset)
+	assert.Error(t, err)
+}
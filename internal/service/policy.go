@@ -0,0 +1,360 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultAliasMinLength is a minimal length of a custom alias
+	DefaultAliasMinLength = 4
+	// DefaultAliasMaxLength is a maximal length of a custom alias
+	DefaultAliasMaxLength = 32
+	// urlSafeSymbols are symbols which may be used in codes without escaping
+	urlSafeSymbols = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-._~"
+)
+
+// Alphabets are named alphabets which can be used in code policies
+var Alphabets = map[string]string{
+	"base63": Charset,
+	"base62": "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	"base36": "abcdefghijklmnopqrstuvwxyz0123456789",
+	// human has no symbols which are easy to confuse: 0, O, o, 1, l, I
+	"human": "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789",
+	// human-lower is a case-insensitive version of human
+	"human-lower": "abcdefghijkmnpqrstuvwxyz23456789",
+}
+
+// CodePolicy describes the format of short urls
+type CodePolicy struct {
+	Name   string
+	Length int
+	// MaxLength is a length up to which generated codes are lengthened
+	// when they collide too often, 0 means Length
+	MaxLength      int
+	Alphabet       string
+	CaseSensitive  bool
+	AliasMinLength int
+	AliasMaxLength int
+}
+
+// DefaultPolicy is a policy used when no policy is configured
+var DefaultPolicy = CodePolicy{
+	Name:           "default",
+	Length:         ShortURLLength,
+	Alphabet:       Charset,
+	CaseSensitive:  true,
+	AliasMinLength: DefaultAliasMinLength,
+	AliasMaxLength: DefaultAliasMaxLength,
+}
+
+// Capacity returns the number of codes which can be generated by the policy
+func (p CodePolicy) Capacity() uint64 {
+	capacity, err := codeSpace(p.Length, p.Alphabet)
+	if err != nil {
+		return 0
+	}
+	return capacity
+}
+
+// maxLength returns the largest length of generated codes
+func (p CodePolicy) maxLength() int {
+	return max(p.Length, p.MaxLength)
+}
+
+// capacityAt returns the approximate number of codes of length symbols
+func (p CodePolicy) capacityAt(length int) float64 {
+	return math.Pow(float64(len(p.Alphabet)), float64(length))
+}
+
+// Normalize brings code to the form it is stored in
+func (p CodePolicy) Normalize(code string) string {
+	if p.CaseSensitive {
+		return code
+	}
+	return strings.ToLower(code)
+}
+
+// Matches checks if code could be generated by the policy
+func (p CodePolicy) Matches(code string) bool {
+	return len(code) >= p.Length && len(code) <= p.maxLength() && p.inAlphabet(p.Normalize(code))
+}
+
+// ValidateAlias checks a custom alias and returns it normalized
+func (p CodePolicy) ValidateAlias(alias string) (string, error) {
+	alias = p.Normalize(alias)
+
+	if len(alias) < p.AliasMinLength || len(alias) > p.AliasMaxLength {
+		return "", fmt.Errorf("%w: length must be between %d and %d",
+			ErrInvalidAlias, p.AliasMinLength, p.AliasMaxLength)
+	}
+	if !p.inAlphabet(alias) {
+		return "", fmt.Errorf("%w: only symbols %q are allowed", ErrInvalidAlias, p.Alphabet)
+	}
+
+	return alias, nil
+}
+
+// CaseFolded returns the case-insensitive version of the policy, uppercase
+// symbols of the alphabet are replaced by lowercase ones
+func (p CodePolicy) CaseFolded() CodePolicy {
+	var alphabet strings.Builder
+	for _, c := range strings.ToLower(p.Alphabet) {
+		if !strings.ContainsRune(alphabet.String(), c) {
+			alphabet.WriteRune(c)
+		}
+	}
+
+	p.Alphabet = alphabet.String()
+	p.CaseSensitive = false
+	return p
+}
+
+// inAlphabet checks if every symbol of code belongs to the alphabet
+func (p CodePolicy) inAlphabet(code string) bool {
+	for i := 0; i < len(code); i++ {
+		if strings.IndexByte(p.Alphabet, code[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks that the policy is usable
+func (p CodePolicy) validate() error {
+	if p.Length <= 0 {
+		return fmt.Errorf("policy %q: length must be positive", p.Name)
+	}
+	if p.MaxLength != 0 && p.MaxLength < p.Length {
+		return fmt.Errorf("policy %q: max length %d is less than length %d", p.Name, p.MaxLength, p.Length)
+	}
+	if p.AliasMinLength <= 0 || p.AliasMinLength > p.AliasMaxLength {
+		return fmt.Errorf("policy %q: invalid alias length range %d-%d", p.Name, p.AliasMinLength, p.AliasMaxLength)
+	}
+	if len(p.Alphabet) < 2 {
+		return fmt.Errorf("policy %q: alphabet must have at least 2 symbols", p.Name)
+	}
+
+	seen := make(map[byte]bool, len(p.Alphabet))
+	for i := 0; i < len(p.Alphabet); i++ {
+		c := p.Alphabet[i]
+		if strings.IndexByte(urlSafeSymbols, c) < 0 {
+			return fmt.Errorf("policy %q: symbol %q is not allowed in urls", p.Name, c)
+		}
+		if seen[c] {
+			return fmt.Errorf("policy %q: symbol %q is repeated", p.Name, c)
+		}
+		if !p.CaseSensitive && strings.ToLower(string(c)) != string(c) {
+			return fmt.Errorf("policy %q: case-insensitive alphabet must be lowercase, got %q", p.Name, c)
+		}
+		seen[c] = true
+	}
+
+	if _, err := codeSpace(p.Length, p.Alphabet); err != nil {
+		return fmt.Errorf("policy %q: %w", p.Name, err)
+	}
+
+	return nil
+}
+
+// PolicySet selects the code policy by namespace or API key
+type PolicySet struct {
+	defaultPolicy CodePolicy
+	policies      []CodePolicy
+	byNamespace   map[string]CodePolicy
+	byAPIKey      map[string]CodePolicy
+}
+
+// NewPolicySet creates a PolicySet with only the default policy
+func NewPolicySet(defaultPolicy CodePolicy) (*PolicySet, error) {
+	if err := defaultPolicy.validate(); err != nil {
+		return nil, err
+	}
+
+	return &PolicySet{
+		defaultPolicy: defaultPolicy,
+		byNamespace:   make(map[string]CodePolicy),
+		byAPIKey:      make(map[string]CodePolicy),
+	}, nil
+}
+
+// Add registers policy for the namespaces and API keys
+func (ps *PolicySet) Add(policy CodePolicy, namespaces, apiKeys []string) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	if policy.Name == ps.defaultPolicy.Name {
+		return fmt.Errorf("policy %q: name is already used", policy.Name)
+	}
+	for _, p := range ps.policies {
+		if p.Name == policy.Name {
+			return fmt.Errorf("policy %q: name is already used", policy.Name)
+		}
+	}
+
+	for _, ns := range namespaces {
+		if _, exists := ps.byNamespace[ns]; exists {
+			return fmt.Errorf("policy %q: namespace %q is already assigned", policy.Name, ns)
+		}
+		ps.byNamespace[ns] = policy
+	}
+	for _, key := range apiKeys {
+		if _, exists := ps.byAPIKey[key]; exists {
+			return fmt.Errorf("policy %q: API key is assigned to several policies", policy.Name)
+		}
+		ps.byAPIKey[key] = policy
+	}
+
+	ps.policies = append(ps.policies, policy)
+	return nil
+}
+
+// Default returns the policy used when no namespace or API key matches
+func (ps *PolicySet) Default() CodePolicy {
+	return ps.defaultPolicy
+}
+
+// Policies returns all policies including the default one
+func (ps *PolicySet) Policies() []CodePolicy {
+	return append([]CodePolicy{ps.defaultPolicy}, ps.policies...)
+}
+
+// FoldCase makes all policies case-insensitive
+func (ps *PolicySet) FoldCase() {
+	ps.defaultPolicy = ps.defaultPolicy.CaseFolded()
+	for i, p := range ps.policies {
+		ps.policies[i] = p.CaseFolded()
+	}
+	for ns, p := range ps.byNamespace {
+		ps.byNamespace[ns] = p.CaseFolded()
+	}
+	for key, p := range ps.byAPIKey {
+		ps.byAPIKey[key] = p.CaseFolded()
+	}
+}
+
+// DefaultMaxLength sets the max length of policies which don't set their own,
+// policies whose codes are already at least n long are left as they are
+func (ps *PolicySet) DefaultMaxLength(n int) {
+	withDefault := func(p CodePolicy) CodePolicy {
+		if p.MaxLength == 0 && n > p.Length {
+			p.MaxLength = n
+		}
+		return p
+	}
+
+	ps.defaultPolicy = withDefault(ps.defaultPolicy)
+	for i, p := range ps.policies {
+		ps.policies[i] = withDefault(p)
+	}
+	for ns, p := range ps.byNamespace {
+		ps.byNamespace[ns] = withDefault(p)
+	}
+	for key, p := range ps.byAPIKey {
+		ps.byAPIKey[key] = withDefault(p)
+	}
+}
+
+// Resolve returns the policy of the API key, or of the namespace if the key
+// has no policy, or the default one
+func (ps *PolicySet) Resolve(namespace, apiKey string) (CodePolicy, error) {
+	if policy, exists := ps.byAPIKey[apiKey]; exists && apiKey != "" {
+		return policy, nil
+	}
+	if namespace == "" {
+		return ps.defaultPolicy, nil
+	}
+	if policy, exists := ps.byNamespace[namespace]; exists {
+		return policy, nil
+	}
+	return CodePolicy{}, fmt.Errorf("%w: %q", ErrUnknownNamespace, namespace)
+}
+
+// policyFile is a format of the code policies file
+type policyFile struct {
+	Default  *policyDefinition  `json:"default"`
+	Policies []policyDefinition `json:"policies"`
+}
+
+// policyDefinition is a code policy as written in the file
+type policyDefinition struct {
+	Name           string   `json:"name"`
+	Length         int      `json:"length"`
+	MaxLength      int      `json:"max_length"`
+	Alphabet       string   `json:"alphabet"`
+	CaseSensitive  *bool    `json:"case_sensitive"`
+	AliasMinLength int      `json:"alias_min_length"`
+	AliasMaxLength int      `json:"alias_max_length"`
+	Namespaces     []string `json:"namespaces"`
+	APIKeys        []string `json:"api_keys"`
+}
+
+// policy converts the definition to a CodePolicy filling omitted values from DefaultPolicy
+func (d policyDefinition) policy() CodePolicy {
+	p := DefaultPolicy
+	if d.Name != "" {
+		p.Name = d.Name
+	}
+	if d.Length != 0 {
+		p.Length = d.Length
+	}
+	if d.MaxLength != 0 {
+		p.MaxLength = d.MaxLength
+	}
+	if d.Alphabet != "" {
+		p.Alphabet = d.Alphabet
+		if named, exists := Alphabets[d.Alphabet]; exists {
+			p.Alphabet = named
+		}
+	}
+	if d.CaseSensitive != nil {
+		p.CaseSensitive = *d.CaseSensitive
+	}
+	if d.AliasMinLength != 0 {
+		p.AliasMinLength = d.AliasMinLength
+	}
+	if d.AliasMaxLength != 0 {
+		p.AliasMaxLength = d.AliasMaxLength
+	}
+	return p
+}
+
+// LoadPolicies reads and validates code policies from the JSON file at path
+func LoadPolicies(path string) (*PolicySet, error) {
+	const op = "service.LoadPolicies"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var file policyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defaultPolicy := DefaultPolicy
+	if file.Default != nil {
+		defaultPolicy = file.Default.policy()
+		defaultPolicy.Name = DefaultPolicy.Name
+	}
+
+	ps, err := NewPolicySet(defaultPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i, def := range file.Policies {
+		if def.Name == "" {
+			return nil, fmt.Errorf("%s: policy %d has no name", op, i)
+		}
+		if err := ps.Add(def.policy(), def.Namespaces, def.APIKeys); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return ps, nil
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePolicies(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "policies.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPolicies_Success(t *testing.T) {
+	path := writePolicies(t, `{
+		"default": {"length": 8, "alphabet": "base62"},
+		"policies": [
+			{"name": "print", "length": 6, "alphabet": "human-lower", "case_sensitive": false,
+			 "namespaces": ["print"], "api_keys": ["print-key"]}
+		]
+	}`)
+
+	ps, err := LoadPolicies(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, 8, ps.Default().Length)
+	assert.Equal(t, Alphabets["base62"], ps.Default().Alphabet)
+
+	policy, err := ps.Resolve("print", "")
+	require.NoError(t, err)
+	assert.Equal(t, "print", policy.Name)
+	assert.False(t, policy.CaseSensitive)
+
+	policy, err = ps.Resolve("", "print-key")
+	require.NoError(t, err)
+	assert.Equal(t, "print", policy.Name)
+
+	policy, err = ps.Resolve("", "other-key")
+	require.NoError(t, err)
+	assert.Equal(t, "default", policy.Name)
+
+	_, err = ps.Resolve("unknown", "")
+	assert.ErrorIs(t, err, ErrUnknownNamespace)
+}
+
+func TestLoadPolicies_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"bad json":            `{`,
+		"zero length":         `{"default": {"length": -1}}`,
+		"short alphabet":      `{"default": {"alphabet": "a"}}`,
+		"repeated symbol":     `{"default": {"alphabet": "abca"}}`,
+		"unsafe symbol":       `{"default": {"alphabet": "ab/c"}}`,
+		"mixed case":          `{"default": {"alphabet": "base62", "case_sensitive": false}}`,
+		"too large":           `{"default": {"length": 30}}`,
+		"unnamed policy":      `{"policies": [{"length": 5}]}`,
+		"duplicate namespace": `{"policies": [{"name": "a", "namespaces": ["x"]}, {"name": "b", "namespaces": ["x"]}]}`,
+		"duplicate name":      `{"policies": [{"name": "a"}, {"name": "a"}]}`,
+		"alias range":         `{"default": {"alias_min_length": 10, "alias_max_length": 5}}`,
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadPolicies(writePolicies(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCodePolicy_Matches(t *testing.T) {
+	policy := CodePolicy{Name: "test", Length: 4, Alphabet: "abc1", CaseSensitive: false}
+
+	assert.True(t, policy.Matches("abc1"))
+	assert.True(t, policy.Matches("ABC1"))
+	assert.False(t, policy.Matches("abc"))
+	assert.False(t, policy.Matches("abcd"))
+
+	policy.CaseSensitive = true
+	assert.False(t, policy.Matches("ABC1"))
+}
+
+func TestCodePolicy_ValidateAlias(t *testing.T) {
+	policy := DefaultPolicy
+	policy.CaseSensitive = false
+	policy.Alphabet = Alphabets["base36"]
+
+	alias, err := policy.ValidateAlias("MyLink")
+	require.NoError(t, err)
+	assert.Equal(t, "mylink", alias)
+
+	_, err = policy.ValidateAlias("abc")
+	assert.ErrorIs(t, err, ErrInvalidAlias)
+
+	_, err = policy.ValidateAlias("my-link")
+	assert.ErrorIs(t, err, ErrInvalidAlias)
+}
+
+func TestCodePolicy_CaseFolded(t *testing.T) {
+	policy := DefaultPolicy.CaseFolded()
+
+	assert.False(t, policy.CaseSensitive)
+	assert.Equal(t, "abcdefghijklmnopqrstuvwxyz0123456789_", policy.Alphabet)
+	assert.NoError(t, policy.validate())
+	assert.True(t, policy.Matches("ABCDEFGHIJ"))
+}
+
+func TestPolicySet_DefaultMaxLength(t *testing.T) {
+	path := writePolicies(t, `{
+		"default": {"length": 8},
+		"policies": [
+			{"name": "print", "length": 6, "max_length": 7, "namespaces": ["print"]},
+			{"name": "long", "length": 10, "namespaces": ["long"]}
+		]
+	}`)
+
+	ps, err := LoadPolicies(path)
+	require.NoError(t, err)
+	ps.DefaultMaxLength(10)
+
+	assert.Equal(t, 10, ps.Default().MaxLength)
+
+	policy, err := ps.Resolve("print", "")
+	require.NoError(t, err)
+	assert.Equal(t, 7, policy.MaxLength, "own max length is kept")
+
+	policy, err = ps.Resolve("long", "")
+	require.NoError(t, err)
+	assert.Equal(t, 0, policy.MaxLength, "codes are already longer")
+	assert.NoError(t, policy.validate())
+}
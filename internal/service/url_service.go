@@ -2,12 +2,12 @@ package service
 
 import (
 	"context"
-	"crypto/rand"
 	"errors"
 	"fmt"
 	"log/slog"
-	"math/big"
 
+	"github.com/hard-gainer/url-shortener/internal/metrics"
+	"github.com/hard-gainer/url-shortener/internal/models"
 	"github.com/hard-gainer/url-shortener/internal/storage"
 )
 
@@ -18,83 +18,394 @@ const (
 	Charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_"
 	// MaxRetries is a maximum amount of retries if the collision occured
 	MaxRetries = 3
+	// MaxSkippedCodes is a maximum amount of generated codes which are
+	// skipped because they are reserved or blocked, they aren't retries
+	MaxSkippedCodes = 100
 )
 
 // URLService represents a main interface for the service for the url shortening
 type URLService interface {
-	ShortenURL(ctx context.Context, originalURL string) (string, error)
+	ShortenURL(ctx context.Context, originalURL string, opts ...ShortenOption) (string, error)
 	GetOriginalURL(ctx context.Context, shortURL string) (string, error)
+	Stats(ctx context.Context) (Stats, error)
 }
 
 type URLServiceImpl struct {
-	repo storage.Repository
+	repo       storage.Repository
+	generator  CodeGenerator
+	policies   *PolicySet
+	generators map[string]CodeGenerator
+
+	collisions         map[string]*collisionWindow
+	collisionWindow    int
+	collisionThreshold float64
+
+	canonicalizer Canonicalizer
+	codeFilter    *CodeFilter
+	validator     *CodeValidator
+	blocklist     *Blocklist
+	destinations  *DestinationPolicy
+}
+
+// Option configures the URL service
+type Option func(*URLServiceImpl)
+
+// WithCodeGenerator sets the generator of short urls of the default policy,
+// random codes are used by default
+func WithCodeGenerator(generator CodeGenerator) Option {
+	return func(s *URLServiceImpl) {
+		s.generator = generator
+	}
+}
+
+// WithPolicies sets the code policies, other policies than the default one
+// use random codes
+func WithPolicies(policies *PolicySet) Option {
+	return func(s *URLServiceImpl) {
+		s.policies = policies
+	}
+}
+
+// WithCollisionThreshold makes generated codes one symbol longer, up to the
+// max length of the policy, when the share of colliding codes over the last
+// window ShortenURL calls reaches threshold; 0 threshold disables it
+func WithCollisionThreshold(threshold float64, window int) Option {
+	return func(s *URLServiceImpl) {
+		s.collisionThreshold = threshold
+		s.collisionWindow = window
+	}
+}
+
+// WithCanonicalizer sets the canonicalization of urls before deduplication
+func WithCanonicalizer(canonicalizer Canonicalizer) Option {
+	return func(s *URLServiceImpl) {
+		s.canonicalizer = canonicalizer
+	}
+}
+
+// WithCodeFilter makes lookups of short urls missing from filter
+// fail without querying the storage
+func WithCodeFilter(filter *CodeFilter) Option {
+	return func(s *URLServiceImpl) {
+		s.codeFilter = filter
+	}
+}
+
+// WithCodeValidator makes lookups of codes no policy could issue fail
+// without querying the storage and keeps reserved paths from being issued
+func WithCodeValidator(validator *CodeValidator) Option {
+	return func(s *URLServiceImpl) {
+		s.validator = validator
+	}
+}
+
+// WithBlocklist keeps blocked words from being issued as codes,
+// generated codes containing them are generated again
+func WithBlocklist(blocklist *Blocklist) Option {
+	return func(s *URLServiceImpl) {
+		s.blocklist = blocklist
+	}
+}
+
+// WithDestinationPolicy rejects urls which don't pass policy
+func WithDestinationPolicy(policy *DestinationPolicy) Option {
+	return func(s *URLServiceImpl) {
+		s.destinations = policy
+	}
 }
 
 // NewURLService creates a new instance of the URL service
-func NewURLService(repo storage.Repository) URLService {
-	return &URLServiceImpl{
-		repo: repo,
+func NewURLService(repo storage.Repository, opts ...Option) URLService {
+	s := &URLServiceImpl{
+		repo:            repo,
+		generators:      make(map[string]CodeGenerator),
+		collisions:      make(map[string]*collisionWindow),
+		collisionWindow: DefaultCollisionWindow,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.policies == nil {
+		s.policies = &PolicySet{defaultPolicy: DefaultPolicy}
+	}
+	if s.generator == nil {
+		s.generator = NewRandomGenerator(s.policies.Default().Length, s.policies.Default().Alphabet)
+	}
+	for _, policy := range s.policies.Policies()[1:] {
+		s.generators[policy.Name] = NewRandomGenerator(policy.Length, policy.Alphabet)
+	}
+	for _, policy := range s.policies.Policies() {
+		s.collisions[policy.Name] = newCollisionWindow(s.collisionWindow)
+		metrics.SetInt(metrics.CodeLength, policy.Name, int64(s.codeLength(policy)))
+	}
+
+	return s
+}
+
+// ShortenOption sets a parameter of a single ShortenURL call
+type ShortenOption func(*shortenRequest)
+
+// shortenRequest holds parameters of a ShortenURL call
+type shortenRequest struct {
+	alias     string
+	namespace string
+	apiKey    string
+	forceNew  bool
+}
+
+// WithAlias requests a custom alias instead of a generated code
+func WithAlias(alias string) ShortenOption {
+	return func(r *shortenRequest) {
+		r.alias = alias
+	}
+}
+
+// WithNamespace selects the code policy of the namespace
+func WithNamespace(namespace string) ShortenOption {
+	return func(r *shortenRequest) {
+		r.namespace = namespace
+	}
+}
+
+// WithAPIKey selects the code policy of the API key
+func WithAPIKey(apiKey string) ShortenOption {
+	return func(r *shortenRequest) {
+		r.apiKey = apiKey
+	}
+}
+
+// WithForceNew creates a new code even if the url is already shortened,
+// the new code is never returned for the url later
+func WithForceNew() ShortenOption {
+	return func(r *shortenRequest) {
+		r.forceNew = true
 	}
 }
 
 // ShortenURL creates a shortened URL for the original one
-func (s *URLServiceImpl) ShortenURL(ctx context.Context, originalURL string) (string, error) {
+func (s *URLServiceImpl) ShortenURL(ctx context.Context, originalURL string, opts ...ShortenOption) (string, error) {
 	const op = "service.URLServiceImpl.ShortenURL"
 
-	existingShort, exists, err := s.repo.OriginalURLExists(ctx, originalURL)
+	var req shortenRequest
+	for _, opt := range opts {
+		opt(&req)
+	}
+
+	policy, err := s.policies.Resolve(req.namespace, req.apiKey)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if s.destinations != nil {
+		if err := s.destinations.Check(ctx, originalURL); err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	canonicalURL, err := s.canonicalizer.Canonicalize(originalURL)
 	if err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
+	url := models.Url{OriginalURL: originalURL, CanonicalURL: canonicalURL, Standalone: req.forceNew}
 
-	if exists {
-		slog.Debug("URL already exists", "original_url", originalURL, "short_url", existingShort)
-		return existingShort, nil
+	if req.alias != "" {
+		return s.saveAlias(ctx, policy, req.alias, url)
 	}
 
-	for i := 0; i < MaxRetries; i++ {
-		shortURL, err := generateShortURL()
+	if !url.Standalone {
+		existingShort, exists, err := s.repo.OriginalURLExists(ctx, canonicalURL)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		if exists {
+			slog.Debug("URL already exists", "original_url", originalURL, "short_url", existingShort)
+			return existingShort, nil
+		}
+	}
+
+	generator := s.generatorFor(policy)
+	var call callStats
+	var skipped int
+	// generated codes which aren't stored are released,
+	// so the codes reserved by a pool don't stay reserved
+	var unused []string
+	defer func() {
+		s.releaseCodes(ctx, generator, unused)
+	}()
+
+	for call.attempts < MaxRetries {
+		shortURL, err := generator.Generate(ctx)
 		if err != nil {
 			return "", fmt.Errorf("%s: failed to generate short URL: %w", op, err)
 		}
+		if !policy.Matches(shortURL) {
+			unused = append(unused, shortURL)
+			return "", fmt.Errorf("%s: generated code %q doesn't match policy %q", op, shortURL, policy.Name)
+		}
+		if s.unusable(shortURL) {
+			slog.Debug("generated code is reserved or blocked, skipping", "short_url", shortURL)
+			unused = append(unused, shortURL)
+			skipped++
+			if skipped >= MaxSkippedCodes {
+				return "", fmt.Errorf("%s: %d generated codes in a row are reserved or blocked", op, skipped)
+			}
+			continue
+		}
 
-		_, err = s.repo.SaveURL(ctx, shortURL, originalURL)
+		call.attempts++
+		url.ShortURL = shortURL
+		saved, created, err := s.repo.GetOrCreate(ctx, url)
 		if err != nil {
+			unused = append(unused, shortURL)
 			if errors.Is(err, storage.ErrURLMappingExists) {
-				slog.Debug("URL collision, retrying", "attempt", i+1)
+				slog.Debug("URL collision, retrying", "attempt", call.attempts)
+				call.collisions++
 				continue
 			}
 			return "", fmt.Errorf("%s: %w", op, err)
 		}
 
-		return shortURL, nil
+		if !created {
+			slog.Debug("URL was shortened concurrently", "original_url", originalURL, "short_url", saved.ShortURL)
+			unused = append(unused, shortURL)
+		}
+		s.addToFilter(saved.ShortURL)
+		s.recordCall(policy, generator, call)
+		return saved.ShortURL, nil
 	}
 
+	s.recordCall(policy, generator, call)
 	return "", fmt.Errorf("%s: failed to generate unique short URL after %d attempts", op, MaxRetries)
 }
 
-// GetOriginalURL gets original URL by a shortend one 
-func (s *URLServiceImpl) GetOriginalURL(ctx context.Context, shortURL string) (string, error) {
-	const op = "service.URLServiceImpl.GetOriginalURL"
+// unusable checks if a generated code is reserved or blocked
+func (s *URLServiceImpl) unusable(shortURL string) bool {
+	return s.validator != nil && s.validator.Reserved(shortURL) ||
+		s.blocklist != nil && s.blocklist.Blocked(shortURL)
+}
+
+// codeReleaser is a generator handing out codes reserved in the storage,
+// codes which aren't used are returned to it
+type codeReleaser interface {
+	Release(ctx context.Context, codes []string) error
+}
+
+// releaseCodes returns unused codes to the generator if they were reserved
+func (s *URLServiceImpl) releaseCodes(ctx context.Context, generator CodeGenerator, codes []string) {
+	releaser, ok := generator.(codeReleaser)
+	if !ok || len(codes) == 0 {
+		return
+	}
+	if err := releaser.Release(context.WithoutCancel(ctx), codes); err != nil {
+		slog.Error("failed to release unused codes", "error", err)
+	}
+}
+
+// saveAlias stores url under a custom alias
+func (s *URLServiceImpl) saveAlias(ctx context.Context, policy CodePolicy, alias string, url models.Url) (string, error) {
+	const op = "service.URLServiceImpl.saveAlias"
 
-	url, err := s.repo.GetURL(ctx, shortURL)
+	alias, err := policy.ValidateAlias(alias)
 	if err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
+	if s.validator != nil && s.validator.Reserved(alias) {
+		return "", fmt.Errorf("%s: %w: %q is reserved", op, ErrInvalidAlias, alias)
+	}
+	if s.blocklist != nil && s.blocklist.Blocked(alias) {
+		return "", fmt.Errorf("%s: %w: %q is not allowed", op, ErrInvalidAlias, alias)
+	}
 
-	return url.OriginalURL, nil
+	url.ShortURL = alias
+	saved, created, err := s.repo.GetOrCreate(ctx, url)
+	if err != nil {
+		if errors.Is(err, storage.ErrURLMappingExists) {
+			return "", fmt.Errorf("%s: %w", op, ErrAliasTaken)
+		}
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if !created && saved.ShortURL != alias {
+		return "", fmt.Errorf("%s: %w: %s", op, storage.ErrOriginalURLExists, saved.ShortURL)
+	}
+	s.addToFilter(alias)
+
+	return alias, nil
+}
+
+// lookupForms returns the forms shortURL may be stored in: as it is or
+// lowercased by a case-insensitive policy. Without the validator codes
+// no policy could issue are looked up as they are.
+func (s *URLServiceImpl) lookupForms(shortURL string) []string {
+	if s.validator != nil {
+		return s.validator.Forms(shortURL)
+	}
+	if forms := storedForms(s.policies.Policies(), shortURL); len(forms) > 0 {
+		return forms
+	}
+	return []string{shortURL}
 }
 
-// generateShortURL generates random string with specified length from the set of symbols
-func generateShortURL() (string, error) {
-	b := make([]byte, ShortURLLength)
+// lookup gets the url stored under the code, codes missing in the code
+// filter are looked up while other instances or imports create codes and
+// the found ones are added to the filter
+func (s *URLServiceImpl) lookup(ctx context.Context, code string) (models.Url, error) {
+	inFilter := s.codeFilter != nil && s.codeFilter.MayExist(code)
+	if s.codeFilter != nil && !inFilter && s.codeFilter.Complete() {
+		metrics.CodeFilterRejected.Add(1)
+		return models.Url{}, storage.ErrURLMappingNotFound
+	}
 
-	for i := range b {
-		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(Charset))))
-		if err != nil {
-			return "", err
+	url, err := s.repo.GetURL(ctx, code)
+	if err != nil {
+		if inFilter && errors.Is(err, storage.ErrURLMappingNotFound) {
+			metrics.CodeFilterFalsePositives.Add(1)
 		}
-		b[i] = Charset[n.Int64()]
+		return models.Url{}, err
+	}
+	if s.codeFilter != nil && !inFilter {
+		s.codeFilter.Add(url.ShortURL)
 	}
+	return url, nil
+}
 
-	return string(b), nil
+// addToFilter adds a stored short url to the code filter
+func (s *URLServiceImpl) addToFilter(shortURL string) {
+	if s.codeFilter != nil {
+		s.codeFilter.Add(shortURL)
+	}
+}
+
+// generatorFor returns the code generator of policy
+func (s *URLServiceImpl) generatorFor(policy CodePolicy) CodeGenerator {
+	if generator, exists := s.generators[policy.Name]; exists {
+		return generator
+	}
+	return s.generator
+}
+
+// GetOriginalURL gets original URL by a shortend one
+func (s *URLServiceImpl) GetOriginalURL(ctx context.Context, shortURL string) (string, error) {
+	const op = "service.URLServiceImpl.GetOriginalURL"
+
+	forms := s.lookupForms(shortURL)
+	if len(forms) == 0 {
+		metrics.CodeValidationRejected.Add(1)
+		return "", fmt.Errorf("%s: %w", op, storage.ErrURLMappingNotFound)
+	}
+
+	var url models.Url
+	var err error
+	for _, code := range forms {
+		if url, err = s.lookup(ctx, code); !errors.Is(err, storage.ErrURLMappingNotFound) {
+			break
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return url.OriginalURL, nil
 }
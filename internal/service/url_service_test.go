@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/hard-gainer/url-shortener/internal/mocks"
 	"github.com/hard-gainer/url-shortener/internal/models"
 	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/hard-gainer/url-shortener/internal/storage/memory"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -19,13 +21,13 @@ func TestShortenURL_Success(t *testing.T) {
 	mockRepo := new(mocks.RepositoryMock)
 	service := NewURLService(mockRepo)
 	ctx := context.Background()
-	originalURL := "https://example.com"
+	originalURL := "https://example.com/"
 
 	mockRepo.On("OriginalURLExists", ctx, originalURL).
 		Return("", false, nil)
 
-	mockRepo.On("SaveURL", ctx, mock.AnythingOfType("string"), originalURL).
-		Return(int64(1), nil)
+	mockRepo.On("GetOrCreate", ctx, urlWith("", originalURL)).
+		Return(asCreated, true, nil)
 
 	shortURL, err := service.ShortenURL(ctx, originalURL)
 
@@ -44,7 +46,7 @@ func TestShortenURL_ExistingURL(t *testing.T) {
 	mockRepo := new(mocks.RepositoryMock)
 	service := NewURLService(mockRepo)
 	ctx := context.Background()
-	originalURL := "https://example.com"
+	originalURL := "https://example.com/"
 	existingShortURL := "existing123"
 
 	mockRepo.On("OriginalURLExists", ctx, originalURL).
@@ -56,14 +58,14 @@ func TestShortenURL_ExistingURL(t *testing.T) {
 	assert.Equal(t, existingShortURL, shortURL)
 
 	mockRepo.AssertExpectations(t)
-	mockRepo.AssertNotCalled(t, "SaveURL")
+	mockRepo.AssertNotCalled(t, "GetOrCreate")
 }
 
 func TestShortenURL_RepositoryError(t *testing.T) {
 	mockRepo := new(mocks.RepositoryMock)
 	service := NewURLService(mockRepo)
 	ctx := context.Background()
-	originalURL := "https://example.com"
+	originalURL := "https://example.com/"
 	expectedError := errors.New("database error")
 
 	mockRepo.On("OriginalURLExists", ctx, originalURL).
@@ -75,7 +77,7 @@ func TestShortenURL_RepositoryError(t *testing.T) {
 	assert.ErrorContains(t, err, expectedError.Error())
 
 	mockRepo.AssertExpectations(t)
-	mockRepo.AssertNotCalled(t, "SaveURL")
+	mockRepo.AssertNotCalled(t, "GetOrCreate")
 }
 
 func TestGetOriginalURL_Success(t *testing.T) {
@@ -135,3 +137,233 @@ func TestGetOriginalURL_DatabaseError(t *testing.T) {
 
 	mockRepo.AssertExpectations(t)
 }
+
+func TestShortenURL_CustomGenerator(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	generator, err := NewSequenceGenerator(&counterIDSource{}, []byte("secret"), ShortURLLength, Charset)
+	require.NoError(t, err)
+	service := NewURLService(mockRepo, WithCodeGenerator(generator))
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	expected, err := NewSequenceGenerator(&counterIDSource{}, []byte("secret"), ShortURLLength, Charset)
+	require.NoError(t, err)
+	expectedShortURL, err := expected.Generate(ctx)
+	require.NoError(t, err)
+
+	mockRepo.On("OriginalURLExists", ctx, originalURL).
+		Return("", false, nil)
+	mockRepo.On("GetOrCreate", ctx, urlWith(expectedShortURL, originalURL)).
+		Return(asCreated, true, nil)
+
+	shortURL, err := service.ShortenURL(ctx, originalURL)
+
+	require.NoError(t, err)
+	assert.Equal(t, expectedShortURL, shortURL)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestShortenURL_Alias(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo)
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	mockRepo.On("GetOrCreate", ctx, urlWith("my_link", originalURL)).
+		Return(asCreated, true, nil)
+
+	shortURL, err := service.ShortenURL(ctx, originalURL, WithAlias("my_link"))
+
+	require.NoError(t, err)
+	assert.Equal(t, "my_link", shortURL)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestShortenURL_AliasTaken(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo)
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	mockRepo.On("GetOrCreate", ctx, urlWith("my_link", originalURL)).
+		Return(models.Url{}, false, storage.ErrURLMappingExists)
+
+	_, err := service.ShortenURL(ctx, originalURL, WithAlias("my_link"))
+
+	assert.ErrorIs(t, err, ErrAliasTaken)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestShortenURL_InvalidAlias(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo)
+	ctx := context.Background()
+
+	_, err := service.ShortenURL(ctx, "https://example.com", WithAlias("bad/alias"))
+
+	assert.ErrorIs(t, err, ErrInvalidAlias)
+	mockRepo.AssertNotCalled(t, "OriginalURLExists")
+	mockRepo.AssertNotCalled(t, "GetOrCreate")
+}
+
+func TestShortenURL_NamespacePolicy(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	policies, err := NewPolicySet(DefaultPolicy)
+	require.NoError(t, err)
+	printPolicy := CodePolicy{
+		Name:           "print",
+		Length:         6,
+		Alphabet:       Alphabets["human-lower"],
+		AliasMinLength: 4,
+		AliasMaxLength: 10,
+	}
+	require.NoError(t, policies.Add(printPolicy, []string{"print"}, nil))
+	service := NewURLService(mockRepo, WithPolicies(policies))
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	mockRepo.On("OriginalURLExists", ctx, originalURL).
+		Return("", false, nil)
+	mockRepo.On("GetOrCreate", ctx, urlWith("", originalURL)).
+		Return(asCreated, true, nil)
+
+	shortURL, err := service.ShortenURL(ctx, originalURL, WithNamespace("print"))
+
+	require.NoError(t, err)
+	assert.True(t, printPolicy.Matches(shortURL), "code %q must match the namespace policy", shortURL)
+
+	_, err = service.ShortenURL(ctx, originalURL, WithNamespace("unknown"))
+	assert.ErrorIs(t, err, ErrUnknownNamespace)
+}
+
+func TestGetOriginalURL_CaseInsensitiveNamespace(t *testing.T) {
+	policies, err := NewPolicySet(DefaultPolicy)
+	require.NoError(t, err)
+	printPolicy := CodePolicy{
+		Name:           "print",
+		Length:         6,
+		Alphabet:       Alphabets["human-lower"],
+		AliasMinLength: 4,
+		AliasMaxLength: 10,
+	}
+	require.NoError(t, policies.Add(printPolicy, []string{"print"}, nil))
+	ctx := context.Background()
+
+	for name, opts := range map[string][]Option{
+		"without validator": {WithPolicies(policies)},
+		"with validator":    {WithPolicies(policies), WithCodeValidator(NewCodeValidator(policies, nil))},
+	} {
+		t.Run(name, func(t *testing.T) {
+			repo, err := memory.NewMemory()
+			require.NoError(t, err)
+			service := NewURLService(repo, opts...)
+
+			shortURL, err := service.ShortenURL(ctx, "https://example.com/", WithNamespace("print"), WithAlias("MyDesk"))
+			require.NoError(t, err)
+			assert.Equal(t, "mydesk", shortURL)
+
+			for _, code := range []string{"mydesk", "MyDesk", "MYDESK"} {
+				originalURL, err := service.GetOriginalURL(ctx, code)
+				require.NoError(t, err, code)
+				assert.Equal(t, "https://example.com/", originalURL)
+			}
+		})
+	}
+}
+
+// urlWith matches a saved url with originalURL and shortURL, any short url if it is empty
+func urlWith(shortURL, originalURL string) interface{} {
+	return mock.MatchedBy(func(u models.Url) bool {
+		return u.OriginalURL == originalURL && (shortURL == "" || u.ShortURL == shortURL)
+	})
+}
+
+func TestShortenURL_ForceNew(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo)
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	mockRepo.On("GetOrCreate", ctx, mock.MatchedBy(func(u models.Url) bool {
+		return u.OriginalURL == originalURL && u.Standalone
+	})).Return(asCreated, true, nil)
+
+	shortURL, err := service.ShortenURL(ctx, originalURL, WithForceNew())
+
+	require.NoError(t, err)
+	assert.Len(t, shortURL, ShortURLLength)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "OriginalURLExists")
+}
+
+func TestShortenURL_ShortenedConcurrently(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo)
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	mockRepo.On("OriginalURLExists", ctx, originalURL).
+		Return("", false, nil)
+	mockRepo.On("GetOrCreate", ctx, urlWith("", originalURL)).
+		Return(models.Url{Id: 1, ShortURL: "winner1234", OriginalURL: originalURL}, false, nil)
+
+	shortURL, err := service.ShortenURL(ctx, originalURL)
+
+	require.NoError(t, err)
+	assert.Equal(t, "winner1234", shortURL, "the code of the concurrent request is returned")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestShortenURL_AliasForShortenedURL(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo)
+	ctx := context.Background()
+	originalURL := "https://example.com/"
+
+	mockRepo.On("GetOrCreate", ctx, urlWith("my_link", originalURL)).
+		Return(models.Url{Id: 1, ShortURL: "existing123", OriginalURL: originalURL}, false, nil)
+
+	_, err := service.ShortenURL(ctx, originalURL, WithAlias("my_link"))
+
+	assert.ErrorIs(t, err, storage.ErrOriginalURLExists)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestShortenURL_ConcurrentSameURL(t *testing.T) {
+	repo, err := memory.NewMemory()
+	require.NoError(t, err)
+	service := NewURLService(repo)
+	ctx := context.Background()
+
+	const workers = 50
+	codes := make([]string, workers)
+	errs := make([]error, workers)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			codes[i], errs[i] = service.ShortenURL(ctx, "https://example.com/concurrent")
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i := 0; i < workers; i++ {
+		require.NoError(t, errs[i])
+		assert.Equal(t, codes[0], codes[i], "every request must get the same code")
+	}
+
+	count, err := repo.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+// asCreated makes the mocked GetOrCreate return the passed url as created
+func asCreated(u models.Url) models.Url {
+	u.Id = 1
+	return u
+}
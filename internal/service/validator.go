@@ -0,0 +1,82 @@
+package service
+
+import (
+	"slices"
+	"strings"
+)
+
+// DefaultReservedPaths are paths which are never issued as codes: paths
+// of the service itself and paths requested by browsers and crawlers
+var DefaultReservedPaths = []string{
+	"api", "admin", "debug", "health", "metrics", "static", "assets", "login", "logout",
+	"favicon.ico", "robots.txt", "sitemap.xml", "humans.txt", ".well-known",
+}
+
+// CodeValidator checks short urls against the code policies before they
+// reach the storage, so requests of impossible codes are answered at once
+type CodeValidator struct {
+	policies []CodePolicy
+	reserved map[string]bool
+}
+
+// NewCodeValidator creates a validator of the codes of policies, reserved
+// are paths which are never issued, DefaultReservedPaths if it is empty
+func NewCodeValidator(policies *PolicySet, reserved []string) *CodeValidator {
+	if len(reserved) == 0 {
+		reserved = DefaultReservedPaths
+	}
+
+	v := &CodeValidator{
+		policies: policies.Policies(),
+		reserved: make(map[string]bool, len(reserved)),
+	}
+	for _, path := range reserved {
+		v.reserved[strings.ToLower(path)] = true
+	}
+	return v
+}
+
+// Possible reports whether code could be issued by a policy,
+// as a generated code or as a custom alias
+func (v *CodeValidator) Possible(code string) bool {
+	return len(v.Forms(code)) > 0
+}
+
+// Normalize returns code in the form it is stored in by the first policy
+// which could issue it, it reports false if no policy could
+func (v *CodeValidator) Normalize(code string) (string, bool) {
+	forms := v.Forms(code)
+	if len(forms) == 0 {
+		return "", false
+	}
+	return forms[0], true
+}
+
+// Forms returns the forms code is stored in by the policies which could
+// issue it, a case-insensitive policy stores it lowercased
+func (v *CodeValidator) Forms(code string) []string {
+	return storedForms(v.policies, code)
+}
+
+// storedForms returns the distinct forms code is stored in by policies
+// which could issue it, in the order of policies
+func storedForms(policies []CodePolicy, code string) []string {
+	var forms []string
+	for _, policy := range policies {
+		form := policy.Normalize(code)
+		if !policy.Matches(code) {
+			if _, err := policy.ValidateAlias(code); err != nil {
+				continue
+			}
+		}
+		if !slices.Contains(forms, form) {
+			forms = append(forms, form)
+		}
+	}
+	return forms
+}
+
+// Reserved reports whether code is a reserved path, case is ignored
+func (v *CodeValidator) Reserved(code string) bool {
+	return v.reserved[strings.ToLower(code)]
+}
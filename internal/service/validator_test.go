@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/mocks"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeValidator_Possible(t *testing.T) {
+	policies, err := NewPolicySet(DefaultPolicy)
+	require.NoError(t, err)
+	printPolicy := CodePolicy{
+		Name: "print", Length: 6, Alphabet: Alphabets["human-lower"],
+		AliasMinLength: DefaultAliasMinLength, AliasMaxLength: DefaultAliasMaxLength,
+	}
+	require.NoError(t, policies.Add(printPolicy, []string{"print"}, nil))
+
+	v := NewCodeValidator(policies, nil)
+
+	assert.True(t, v.Possible("abcDEF1234"), "generated code of the default policy")
+	assert.True(t, v.Possible("abc234"), "generated code of the print policy")
+	assert.True(t, v.Possible("my_link"), "custom alias")
+	assert.False(t, v.Possible("abc"), "too short")
+	assert.False(t, v.Possible(strings.Repeat("a", 5000)), "too long")
+	assert.False(t, v.Possible("favicon.ico"))
+	assert.False(t, v.Possible("abc-def"))
+}
+
+func TestCodeValidator_Reserved(t *testing.T) {
+	policies, err := NewPolicySet(DefaultPolicy)
+	require.NoError(t, err)
+
+	v := NewCodeValidator(policies, nil)
+	assert.True(t, v.Reserved("Admin"))
+	assert.True(t, v.Reserved("robots.txt"))
+	assert.False(t, v.Reserved("my_link"))
+
+	v = NewCodeValidator(policies, []string{"Promo"})
+	assert.True(t, v.Reserved("promo"))
+	assert.False(t, v.Reserved("admin"))
+}
+
+func TestGetOriginalURL_ImpossibleCode(t *testing.T) {
+	policies, err := NewPolicySet(DefaultPolicy)
+	require.NoError(t, err)
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo, WithPolicies(policies), WithCodeValidator(NewCodeValidator(policies, nil)))
+
+	_, err = service.GetOriginalURL(context.Background(), "favicon.ico")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound)
+	mockRepo.AssertNotCalled(t, "GetURL")
+}
+
+func TestShortenURL_ReservedAlias(t *testing.T) {
+	policies, err := NewPolicySet(DefaultPolicy)
+	require.NoError(t, err)
+	mockRepo := new(mocks.RepositoryMock)
+	service := NewURLService(mockRepo, WithPolicies(policies), WithCodeValidator(NewCodeValidator(policies, nil)))
+
+	_, err = service.ShortenURL(context.Background(), "https://example.com/", WithAlias("Admin"))
+	assert.ErrorIs(t, err, ErrInvalidAlias)
+	assert.ErrorContains(t, err, "reserved")
+	mockRepo.AssertNotCalled(t, "GetOrCreate")
+}
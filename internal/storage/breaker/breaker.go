@@ -0,0 +1,291 @@
+// Package breaker contains a circuit breaker decorator of the storage
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/config"
+	"github.com/hard-gainer/url-shortener/internal/metrics"
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+)
+
+// state is a state of the circuit breaker
+type state int
+
+const (
+	stateClosed state = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s state) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerRepository is a repository which stops calling the wrapped storage
+// after repeated failures and serves redirects from the last-known cache
+type BreakerRepository struct {
+	next  storage.Repository
+	cfg   config.BreakerConfig
+	cache *lastKnown
+	now   func() time.Time
+
+	mutex     sync.Mutex
+	state     state
+	failures  int
+	openedAt  time.Time
+	inFlight  int
+	successes int
+}
+
+// Option configures the circuit breaker
+type Option func(*BreakerRepository)
+
+// WithCaseInsensitiveCodes makes the cache find codes differing only in case,
+// it must be set when the wrapped storage compares codes ignoring case
+func WithCaseInsensitiveCodes() Option {
+	return func(b *BreakerRepository) {
+		b.cache.foldCase = true
+	}
+}
+
+// NewBreaker wraps next with a circuit breaker
+func NewBreaker(next storage.Repository, cfg config.BreakerConfig, opts ...Option) storage.Repository {
+	b := &BreakerRepository{
+		next:  next,
+		cfg:   cfg,
+		cache: newLastKnown(cfg.CacheSize),
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// GetURL retrieves the url from the storage, while the breaker is open
+// it is served from the cache of recently read urls
+func (b *BreakerRepository) GetURL(ctx context.Context, shortURL string) (models.Url, error) {
+	const op = "storage.breaker.GetURL"
+
+	probe, retryAfter, ok := b.allow()
+	if !ok {
+		if url, found := b.cache.get(shortURL); found {
+			metrics.StorageBreakerCacheHits.Add(1)
+			return url, nil
+		}
+		return models.Url{}, fmt.Errorf("%s: %w", op, &storage.UnavailableError{RetryAfter: retryAfter})
+	}
+
+	url, err := b.next.GetURL(ctx, shortURL)
+	b.record(err, probe)
+
+	if err == nil {
+		b.cache.put(url)
+	} else if isFailure(err) {
+		if cached, found := b.cache.get(shortURL); found {
+			metrics.StorageBreakerCacheHits.Add(1)
+			return cached, nil
+		}
+	}
+
+	return url, err
+}
+
+// SaveURL saves a new pair of short url and original url into the storage
+func (b *BreakerRepository) SaveURL(ctx context.Context, url models.Url) (int64, error) {
+	const op = "storage.breaker.SaveURL"
+
+	probe, retryAfter, ok := b.allow()
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", op, &storage.UnavailableError{RetryAfter: retryAfter})
+	}
+
+	id, err := b.next.SaveURL(ctx, url)
+	b.record(err, probe)
+
+	return id, err
+}
+
+// SaveURLs imports urls into the wrapped storage
+func (b *BreakerRepository) SaveURLs(ctx context.Context, urls []models.Url) ([]error, error) {
+	const op = "storage.breaker.SaveURLs"
+
+	importer, ok := b.next.(storage.Importer)
+	if !ok {
+		return nil, fmt.Errorf("%s: storage doesn't support import", op)
+	}
+
+	probe, retryAfter, ok := b.allow()
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, &storage.UnavailableError{RetryAfter: retryAfter})
+	}
+
+	errs, err := importer.SaveURLs(ctx, urls)
+	b.record(err, probe)
+
+	return errs, err
+}
+
+// GetOrCreate returns the existing url with the canonical form of url or saves url
+func (b *BreakerRepository) GetOrCreate(ctx context.Context, url models.Url) (models.Url, bool, error) {
+	const op = "storage.breaker.GetOrCreate"
+
+	probe, retryAfter, ok := b.allow()
+	if !ok {
+		return models.Url{}, false, fmt.Errorf("%s: %w", op, &storage.UnavailableError{RetryAfter: retryAfter})
+	}
+
+	saved, created, err := b.next.GetOrCreate(ctx, url)
+	b.record(err, probe)
+
+	return saved, created, err
+}
+
+// OriginalURLExists checks if an original URL already exists in storage
+func (b *BreakerRepository) OriginalURLExists(ctx context.Context, canonicalURL string) (string, bool, error) {
+	const op = "storage.breaker.OriginalURLExists"
+
+	probe, retryAfter, ok := b.allow()
+	if !ok {
+		return "", false, fmt.Errorf("%s: %w", op, &storage.UnavailableError{RetryAfter: retryAfter})
+	}
+
+	shortURL, exists, err := b.next.OriginalURLExists(ctx, canonicalURL)
+	b.record(err, probe)
+
+	return shortURL, exists, err
+}
+
+// CountURLs returns the number of urls in the wrapped storage
+func (b *BreakerRepository) CountURLs(ctx context.Context) (int64, error) {
+	const op = "storage.breaker.CountURLs"
+
+	probe, retryAfter, ok := b.allow()
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", op, &storage.UnavailableError{RetryAfter: retryAfter})
+	}
+
+	count, err := b.next.CountURLs(ctx)
+	b.record(err, probe)
+
+	return count, err
+}
+
+// Close closes the wrapped storage
+func (b *BreakerRepository) Close() {
+	b.next.Close()
+}
+
+// allow decides if a call may reach the storage. In the half-open state
+// only a limited number of probe calls is let through at a time.
+func (b *BreakerRepository) allow() (probe bool, retryAfter time.Duration, ok bool) {
+	if b.cfg.FailureThreshold <= 0 {
+		return false, 0, true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		elapsed := b.now().Sub(b.openedAt)
+		if elapsed < b.cfg.OpenTimeout {
+			metrics.StorageBreakerRejected.Add(1)
+			return false, b.cfg.OpenTimeout - elapsed, false
+		}
+		b.setState(stateHalfOpen)
+		b.inFlight = 0
+		b.successes = 0
+		fallthrough
+	case stateHalfOpen:
+		if b.inFlight >= b.cfg.HalfOpenProbes {
+			metrics.StorageBreakerRejected.Add(1)
+			return false, b.cfg.OpenTimeout, false
+		}
+		b.inFlight++
+		return true, 0, true
+	default:
+		return false, 0, true
+	}
+}
+
+// record updates the breaker with the result of a call
+func (b *BreakerRepository) record(err error, probe bool) {
+	if b.cfg.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if probe && b.state == stateHalfOpen {
+		b.inFlight--
+	}
+
+	failed := isFailure(err)
+
+	switch b.state {
+	case stateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			slog.Error("storage circuit breaker opened", "failures", b.failures, "error", err)
+			b.open()
+		}
+	case stateHalfOpen:
+		if !probe {
+			return
+		}
+		if failed {
+			slog.Warn("storage probe failed, circuit breaker reopened", "error", err)
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenProbes {
+			slog.Info("storage circuit breaker closed")
+			b.setState(stateClosed)
+			b.failures = 0
+		}
+	}
+}
+
+// open moves the breaker to the open state
+func (b *BreakerRepository) open() {
+	b.setState(stateOpen)
+	b.openedAt = b.now()
+}
+
+// setState changes the state and publishes it
+func (b *BreakerRepository) setState(s state) {
+	b.state = s
+	metrics.StorageBreakerState.Set(int64(s))
+}
+
+// isFailure checks if err means that the storage is not healthy,
+// expected domain errors and cancellation by the client do not count
+func isFailure(err error) bool {
+	return err != nil &&
+		!errors.Is(err, storage.ErrURLMappingNotFound) &&
+		!errors.Is(err, storage.ErrURLMappingExists) &&
+		!errors.Is(err, storage.ErrOriginalURLExists) &&
+		!errors.Is(err, storage.ErrStorageFull) &&
+		!errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,194 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/config"
+	"github.com/hard-gainer/url-shortener/internal/mocks"
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errDatabaseDown = errors.New("database is down")
+
+// fakeClock is a controllable time source
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestBreaker(mockRepo *mocks.RepositoryMock) (*BreakerRepository, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+	b := NewBreaker(mockRepo, config.BreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenProbes:   1,
+		CacheSize:        10,
+	}).(*BreakerRepository)
+	b.now = clock.Now
+	return b, clock
+}
+
+func TestBreaker_OpensAfterThreshold(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	b, _ := newTestBreaker(mockRepo)
+	ctx := context.Background()
+	originalURL := "https://example.com"
+
+	mockRepo.On("OriginalURLExists", ctx, originalURL).
+		Return("", false, errDatabaseDown).Times(2)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := b.OriginalURLExists(ctx, originalURL)
+		assert.ErrorIs(t, err, errDatabaseDown)
+	}
+
+	_, _, err := b.OriginalURLExists(ctx, originalURL)
+
+	require.Error(t, err)
+	assert.ErrorIs(t, err, storage.ErrStorageUnavailable)
+
+	var unavailable *storage.UnavailableError
+	require.ErrorAs(t, err, &unavailable)
+	assert.Equal(t, 10*time.Second, unavailable.RetryAfter)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestBreaker_NotFoundIsNotAFailure(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	b, _ := newTestBreaker(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetURL", ctx, "missing").
+		Return(models.Url{}, storage.ErrURLMappingNotFound)
+
+	for i := 0; i < 5; i++ {
+		_, err := b.GetURL(ctx, "missing")
+		assert.ErrorIs(t, err, storage.ErrURLMappingNotFound)
+	}
+
+	assert.Equal(t, stateClosed, b.state)
+}
+
+func TestBreaker_ServesCachedRedirectsWhileOpen(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	b, _ := newTestBreaker(mockRepo)
+	ctx := context.Background()
+	url := models.Url{Id: 1, ShortURL: "abc123", OriginalURL: "https://example.com"}
+
+	mockRepo.On("GetURL", ctx, "abc123").Return(url, nil).Once()
+	mockRepo.On("GetURL", ctx, "abc123").Return(models.Url{}, errDatabaseDown)
+	mockRepo.On("GetURL", ctx, "other").Return(models.Url{}, errDatabaseDown)
+
+	_, err := b.GetURL(ctx, "abc123")
+	require.NoError(t, err)
+
+	_, err = b.GetURL(ctx, "other")
+	require.ErrorIs(t, err, errDatabaseDown)
+	result, err := b.GetURL(ctx, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, url, result)
+	assert.Equal(t, stateOpen, b.state)
+
+	result, err = b.GetURL(ctx, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, url, result)
+
+	_, err = b.GetURL(ctx, "other")
+	assert.ErrorIs(t, err, storage.ErrStorageUnavailable)
+}
+
+func TestBreaker_CaseInsensitiveCache(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	b, _ := newTestBreaker(mockRepo)
+	WithCaseInsensitiveCodes()(b)
+	ctx := context.Background()
+	url := models.Url{Id: 1, ShortURL: "abc123", OriginalURL: "https://example.com"}
+
+	mockRepo.On("GetURL", ctx, "abc123").Return(url, nil).Once()
+	mockRepo.On("GetURL", ctx, "ABC123").Return(models.Url{}, errDatabaseDown)
+
+	_, err := b.GetURL(ctx, "abc123")
+	require.NoError(t, err)
+
+	result, err := b.GetURL(ctx, "ABC123")
+	require.NoError(t, err)
+	assert.Equal(t, url, result)
+}
+
+func TestBreaker_HalfOpenProbeClosesBreaker(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	b, clock := newTestBreaker(mockRepo)
+	ctx := context.Background()
+	originalURL := "https://example.com"
+
+	mockRepo.On("OriginalURLExists", ctx, originalURL).
+		Return("", false, errDatabaseDown).Times(2)
+	mockRepo.On("OriginalURLExists", ctx, originalURL).
+		Return("", false, nil).Once()
+
+	for i := 0; i < 2; i++ {
+		_, _, _ = b.OriginalURLExists(ctx, originalURL)
+	}
+	require.Equal(t, stateOpen, b.state)
+
+	clock.now = clock.now.Add(11 * time.Second)
+
+	_, exists, err := b.OriginalURLExists(ctx, originalURL)
+
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, stateClosed, b.state)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestBreaker_FailedProbeReopensBreaker(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	b, clock := newTestBreaker(mockRepo)
+	ctx := context.Background()
+	originalURL := "https://example.com"
+
+	mockRepo.On("OriginalURLExists", ctx, originalURL).
+		Return("", false, errDatabaseDown).Times(3)
+
+	for i := 0; i < 2; i++ {
+		_, _, _ = b.OriginalURLExists(ctx, originalURL)
+	}
+
+	clock.now = clock.now.Add(11 * time.Second)
+
+	_, _, err := b.OriginalURLExists(ctx, originalURL)
+	require.ErrorIs(t, err, errDatabaseDown)
+	assert.Equal(t, stateOpen, b.state)
+
+	_, _, err = b.OriginalURLExists(ctx, originalURL)
+	assert.ErrorIs(t, err, storage.ErrStorageUnavailable)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestBreaker_SaveURLFailsFastWhileOpen(t *testing.T) {
+	mockRepo := new(mocks.RepositoryMock)
+	b, _ := newTestBreaker(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("SaveURL", ctx, models.Url{ShortURL: "abc123", OriginalURL: "https://example.com"}).
+		Return(int64(0), errDatabaseDown).Times(2)
+
+	for i := 0; i < 2; i++ {
+		_, _ = b.SaveURL(ctx, models.Url{ShortURL: "abc123", OriginalURL: "https://example.com"})
+	}
+
+	_, err := b.SaveURL(ctx, models.Url{ShortURL: "abc123", OriginalURL: "https://example.com"})
+
+	assert.ErrorIs(t, err, storage.ErrStorageUnavailable)
+	mockRepo.AssertExpectations(t)
+}
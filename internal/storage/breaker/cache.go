@@ -0,0 +1,68 @@
+package breaker
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+)
+
+// lastKnown is a bounded cache of recently read urls,
+// the oldest entry is replaced when it is full
+type lastKnown struct {
+	mutex sync.RWMutex
+	urls  map[string]models.Url
+	order []string
+	next  int
+	// foldCase makes codes differing only in case the same entry,
+	// as they are with a case-insensitive storage
+	foldCase bool
+}
+
+// newLastKnown creates a cache for size urls, zero size disables caching
+func newLastKnown(size int) *lastKnown {
+	return &lastKnown{
+		urls:  make(map[string]models.Url, size),
+		order: make([]string, size),
+	}
+}
+
+// get returns the cached url by its short url
+func (c *lastKnown) get(shortURL string) (models.Url, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	url, found := c.urls[c.key(shortURL)]
+	return url, found
+}
+
+// put stores url in the cache
+func (c *lastKnown) put(url models.Url) {
+	if len(c.order) == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := c.key(url.ShortURL)
+	if _, exists := c.urls[key]; exists {
+		c.urls[key] = url
+		return
+	}
+
+	if oldest := c.order[c.next]; oldest != "" {
+		delete(c.urls, oldest)
+	}
+	c.order[c.next] = key
+	c.next = (c.next + 1) % len(c.order)
+	c.urls[key] = url
+}
+
+// key returns the form of a short url used in the cache
+func (c *lastKnown) key(shortURL string) string {
+	if c.foldCase {
+		return strings.ToLower(shortURL)
+	}
+	return shortURL
+}
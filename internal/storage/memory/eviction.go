@@ -0,0 +1,218 @@
+package memory
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+)
+
+// EvictionPolicy is the action taken when the repository is full
+type EvictionPolicy string
+
+const (
+	// EvictReject rejects new urls with ErrStorageFull
+	EvictReject EvictionPolicy = "reject"
+	// EvictLRU removes the least recently used urls
+	EvictLRU EvictionPolicy = "lru"
+	// EvictOldest removes the urls created first
+	EvictOldest EvictionPolicy = "oldest"
+)
+
+const (
+	// recordOverhead approximates the memory of a record and its map
+	// entries besides the strings
+	recordOverhead = 256
+	// lruSamples is the number of urls the least recently used one is chosen from,
+	// like in redis the lru policy is approximate to keep reads cheap
+	lruSamples = 16
+	// lruSamplesPerShard is the number of urls sampled from a single shard
+	lruSamplesPerShard = 4
+)
+
+// WithLimits bounds the repository by the number of urls and the approximate
+// memory they use, 0 disables a limit; policy decides what happens when
+// a new url doesn't fit
+func WithLimits(maxLinks, maxBytes int64, policy EvictionPolicy) Option {
+	return func(repo *MemoryRepository) {
+		repo.maxLinks = maxLinks
+		repo.maxBytes = maxBytes
+		if policy != "" {
+			repo.policy = policy
+		}
+	}
+}
+
+// recordSize returns the approximate memory used by url
+func recordSize(url models.Url) int64 {
+	size := int64(recordOverhead + len(url.ShortURL) + len(url.OriginalURL))
+	if url.CanonicalURL != url.OriginalURL {
+		size += int64(len(url.CanonicalURL))
+	}
+	return size
+}
+
+// admit accounts a new url of size, it returns false if the url must be
+// rejected. With the eviction policies urls are always admitted unless
+// a single url exceeds the memory limit, evict frees the space afterwards.
+func (repo *MemoryRepository) admit(size int64) bool {
+	if repo.maxBytes > 0 && size > repo.maxBytes {
+		return false
+	}
+
+	links := repo.count.Add(1)
+	bytes := repo.bytes.Add(size)
+	if repo.policy != EvictReject || !repo.exceeds(links, bytes) {
+		return true
+	}
+
+	repo.count.Add(-1)
+	repo.bytes.Add(-size)
+	return false
+}
+
+// exceeds checks if the usage is over the limits
+func (repo *MemoryRepository) exceeds(links, bytes int64) bool {
+	return (repo.maxLinks > 0 && links > repo.maxLinks) ||
+		(repo.maxBytes > 0 && bytes > repo.maxBytes)
+}
+
+// touch marks rec as used now, the shard of rec must be locked
+func (repo *MemoryRepository) touch(rec *record) {
+	if repo.policy == EvictLRU {
+		rec.lastAccess.Store(time.Now().UnixNano())
+	}
+}
+
+// evict removes urls while the limits are exceeded, no shards must be
+// locked. Concurrent calls may remove a few urls more than needed.
+func (repo *MemoryRepository) evict() {
+	if repo.policy == EvictReject {
+		return
+	}
+
+	for repo.exceeds(repo.count.Load(), repo.bytes.Load()) {
+		var victim *record
+		if repo.policy == EvictLRU {
+			victim = repo.leastRecentlyUsed()
+		} else {
+			victim = repo.oldest()
+		}
+		if victim == nil {
+			return
+		}
+
+		if repo.remove(victim) {
+			repo.evictions.Add(1)
+		}
+	}
+}
+
+// leastRecentlyUsed returns the least recently used of sampled urls
+func (repo *MemoryRepository) leastRecentlyUsed() *record {
+	var victim *record
+	var victimAccess int64
+	sampled := 0
+
+	start := rand.Uint64()
+	for i := uint64(0); i <= repo.mask && sampled < lruSamples; i++ {
+		shard := &repo.codes[(start+i)&repo.mask]
+
+		shard.mutex.RLock()
+		n := 0
+		// map iteration starts at a random entry
+		for _, rec := range shard.urls {
+			if access := rec.lastAccess.Load(); victim == nil || access < victimAccess {
+				victim, victimAccess = rec, access
+			}
+			n++
+			if n == lruSamplesPerShard {
+				break
+			}
+		}
+		shard.mutex.RUnlock()
+
+		sampled += n
+	}
+
+	return victim
+}
+
+// oldest returns the url created first
+func (repo *MemoryRepository) oldest() *record {
+	var victim *record
+
+	for i := range repo.codes {
+		shard := &repo.codes[i]
+
+		shard.mutex.RLock()
+		if len(shard.order) > 0 {
+			if head := shard.order[0]; victim == nil || head.CreatedAt.Before(victim.CreatedAt) {
+				victim = head
+			}
+		}
+		shard.mutex.RUnlock()
+	}
+
+	return victim
+}
+
+// appendOrdered adds rec to the order of creation, urls are mostly created
+// in order, imported ones may be older than the stored ones
+func (shard *codeShard) appendOrdered(rec *record) {
+	n := len(shard.order)
+	if n == 0 || !rec.CreatedAt.Before(shard.order[n-1].CreatedAt) {
+		shard.order = append(shard.order, rec)
+		return
+	}
+
+	i := sort.Search(n, func(i int) bool {
+		return rec.CreatedAt.Before(shard.order[i].CreatedAt)
+	})
+	shard.order = append(shard.order, nil)
+	copy(shard.order[i+1:], shard.order[i:])
+	shard.order[i] = rec
+}
+
+// remove deletes rec unless it has been removed already
+func (repo *MemoryRepository) remove(rec *record) bool {
+	key := repo.key(rec.ShortURL)
+	codes, canonicals := repo.lock(key, rec.Url)
+	defer unlock(codes, canonicals)
+
+	if codes.urls[key] != rec {
+		return false
+	}
+
+	delete(codes.urls, key)
+	if canonicals != nil && canonicals.urls[rec.CanonicalURL] == rec {
+		delete(canonicals.urls, rec.CanonicalURL)
+	}
+	if len(codes.order) > 0 && codes.order[0] == rec {
+		codes.order[0] = nil
+		codes.order = codes.order[1:]
+	}
+
+	repo.count.Add(-1)
+	repo.bytes.Add(-rec.size)
+	return true
+}
+
+// Usage returns the current usage of the repository
+func (repo *MemoryRepository) Usage(ctx context.Context) (storage.Usage, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.Usage{}, err
+	}
+
+	return storage.Usage{
+		Links:     repo.count.Load(),
+		Bytes:     repo.bytes.Load(),
+		MaxLinks:  repo.maxLinks,
+		MaxBytes:  repo.maxBytes,
+		Policy:    string(repo.policy),
+		Evictions: repo.evictions.Load(),
+	}, nil
+}
@@ -0,0 +1,184 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func saveAll(t *testing.T, repo storage.Repository, codes ...string) {
+	t.Helper()
+
+	for _, code := range codes {
+		_, err := repo.SaveURL(context.Background(), models.Url{ShortURL: code, OriginalURL: "https://example.com/" + code})
+		require.NoError(t, err)
+	}
+}
+
+func TestMemoryRepository_RejectWhenFull(t *testing.T) {
+	repo, err := NewMemory(WithLimits(2, 0, EvictReject))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	saveAll(t, repo, "first1", "second")
+
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "third3", OriginalURL: "https://example.com/third3"})
+	assert.ErrorIs(t, err, storage.ErrStorageFull)
+
+	saved, created, err := repo.GetOrCreate(ctx, models.Url{ShortURL: "other1", OriginalURL: "https://example.com/first1"})
+	require.NoError(t, err, "existing urls are returned when full")
+	assert.False(t, created)
+	assert.Equal(t, "first1", saved.ShortURL)
+
+	_, err = repo.GetURL(ctx, "third3")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound)
+
+	count, err := repo.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestMemoryRepository_RejectByBytes(t *testing.T) {
+	url := models.Url{ShortURL: "first1", OriginalURL: "https://example.com/first1"}
+	repo, err := NewMemory(WithLimits(0, recordSize(url)*3/2, EvictReject))
+	require.NoError(t, err)
+
+	saveAll(t, repo, "first1")
+
+	_, err = repo.SaveURL(context.Background(), models.Url{ShortURL: "second", OriginalURL: "https://example.com/second"})
+	assert.ErrorIs(t, err, storage.ErrStorageFull)
+}
+
+func TestMemoryRepository_EvictOldest(t *testing.T) {
+	repo, err := NewMemory(WithLimits(2, 0, EvictOldest))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	saveAll(t, repo, "first1", "second")
+	_, err = repo.GetURL(ctx, "first1")
+	require.NoError(t, err)
+	saveAll(t, repo, "third3")
+
+	_, err = repo.GetURL(ctx, "first1")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound, "the oldest url is evicted even if it is used")
+	_, exists, err := repo.OriginalURLExists(ctx, "https://example.com/first1")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	for _, code := range []string{"second", "third3"} {
+		_, err = repo.GetURL(ctx, code)
+		assert.NoError(t, err, code)
+	}
+
+	usage, err := repo.(storage.UsageReporter).Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage.Links)
+	assert.Equal(t, int64(1), usage.Evictions)
+	assert.Equal(t, "oldest", usage.Policy)
+}
+
+func TestMemoryRepository_EvictLRU(t *testing.T) {
+	repo, err := NewMemory(WithShards(1), WithLimits(3, 0, EvictLRU))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	saveAll(t, repo, "first1", "second", "third3")
+	_, err = repo.GetURL(ctx, "first1")
+	require.NoError(t, err)
+	saveAll(t, repo, "fourth")
+
+	_, err = repo.GetURL(ctx, "second")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound)
+
+	for _, code := range []string{"first1", "third3", "fourth"} {
+		_, err = repo.GetURL(ctx, code)
+		assert.NoError(t, err, code)
+	}
+}
+
+func TestMemoryRepository_EvictTooLargeURL(t *testing.T) {
+	repo, err := NewMemory(WithLimits(0, recordOverhead+10, EvictLRU))
+	require.NoError(t, err)
+
+	_, err = repo.SaveURL(context.Background(), models.Url{ShortURL: "abc123", OriginalURL: "https://example.com/long"})
+	assert.ErrorIs(t, err, storage.ErrStorageFull)
+}
+
+func TestMemoryRepository_Usage(t *testing.T) {
+	url := models.Url{ShortURL: "abc123", OriginalURL: "https://example.com"}
+	repo, err := NewMemory(WithLimits(10, 1<<20, ""))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = repo.SaveURL(ctx, url)
+	require.NoError(t, err)
+
+	usage, err := repo.(storage.UsageReporter).Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Usage{
+		Links:    1,
+		Bytes:    recordSize(url),
+		MaxLinks: 10,
+		MaxBytes: 1 << 20,
+		Policy:   "reject",
+	}, usage)
+}
+
+func TestMemoryRepository_InvalidLimits(t *testing.T) {
+	_, err := NewMemory(WithLimits(0, 0, "random"))
+	assert.Error(t, err)
+
+	_, err = NewMemory(WithLimits(-1, 0, EvictLRU))
+	assert.Error(t, err)
+}
+
+func TestMemoryRepository_ConcurrentEviction(t *testing.T) {
+	for _, policy := range []EvictionPolicy{EvictLRU, EvictOldest} {
+		t.Run(string(policy), func(t *testing.T) {
+			const maxLinks = 50
+
+			repo, err := NewMemory(WithShards(4), WithLimits(maxLinks, 0, policy))
+			require.NoError(t, err)
+			ctx := context.Background()
+			memRepo := repo.(*MemoryRepository)
+
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+
+					for i := 0; i < 200; i++ {
+						code := fmt.Sprintf("g%d-%d", g, i)
+						_, err := repo.SaveURL(ctx, models.Url{ShortURL: code, OriginalURL: "https://example.com/" + code})
+						assert.NoError(t, err)
+						_, _ = repo.GetURL(ctx, fmt.Sprintf("g%d-%d", g, i/2))
+					}
+				}(g)
+			}
+			wg.Wait()
+
+			stored := 0
+			var bytes int64
+			for i := range memRepo.codes {
+				stored += len(memRepo.codes[i].urls)
+				for _, rec := range memRepo.codes[i].urls {
+					bytes += rec.size
+				}
+			}
+
+			usage, err := memRepo.Usage(ctx)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, usage.Links, int64(maxLinks))
+			assert.Equal(t, int64(stored), usage.Links)
+			assert.Equal(t, bytes, usage.Bytes)
+			assert.Equal(t, int64(8*200)-usage.Links, usage.Evictions)
+		})
+	}
+}
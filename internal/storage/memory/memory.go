@@ -3,30 +3,183 @@ package memory
 import (
 	"context"
 	"fmt"
+	"hash/maphash"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hard-gainer/url-shortener/internal/models"
 	"github.com/hard-gainer/url-shortener/internal/storage"
 )
 
-// A memory implementation of the repository
+// DefaultShards is a number of shards used when none is configured
+const DefaultShards = 64
+
+// A memory implementation of the repository. Urls are spread over shards
+// by the hash of the short url and the canonical url is indexed in its own
+// shards, so operations on different shards don't wait for each other.
+// Every url is stored once and both indexes point to it.
+//
+// An operation which needs both a canonical and a code shard locks
+// the canonical one first, so concurrent operations never deadlock.
 type MemoryRepository struct {
-	shortToOriginal map[string]string
-	originalToShort map[string]string
-	urls            map[int64]models.Url
-	mutex           sync.RWMutex
-	lastID          int64
+	codes      []codeShard
+	canonicals []canonicalShard
+	mask       uint64
+	seed       maphash.Seed
+
+	lastID          atomic.Int64
+	count           atomic.Int64
+	bytes           atomic.Int64
+	evictions       atomic.Int64
+	sequence        atomic.Int64
+	caseInsensitive bool
+
+	maxLinks int64
+	maxBytes int64
+	policy   EvictionPolicy
+
+	snapshotFile string
+}
+
+// record is a stored url
+type record struct {
+	models.Url
+	// size is the approximate memory used by the url
+	size int64
+	// lastAccess is the time of the last read in unix nanoseconds,
+	// it is only updated with the lru policy
+	lastAccess atomic.Int64
+}
+
+// codeShard keeps urls and reserved codes by the short url
+type codeShard struct {
+	mutex    sync.RWMutex
+	urls     map[string]*record
+	reserved map[string]struct{}
+	// order keeps urls in the order of creation with the oldest policy
+	order []*record
+	// the padding keeps the mutexes of neighbouring shards in different cache lines
+	_ [64]byte
+}
+
+// canonicalShard keeps urls by the canonical url, standalone urls aren't in it
+type canonicalShard struct {
+	mutex sync.RWMutex
+	urls  map[string]*record
+	_     [64]byte
+}
+
+// Option configures the memory repository
+type Option func(*MemoryRepository)
+
+// WithCaseInsensitiveCodes makes codes differing only in case the same code
+func WithCaseInsensitiveCodes() Option {
+	return func(repo *MemoryRepository) {
+		repo.caseInsensitive = true
+	}
+}
+
+// WithShards sets the number of shards, it is rounded up to a power of two,
+// a non-positive n means DefaultShards
+func WithShards(n int) Option {
+	return func(repo *MemoryRepository) {
+		if n <= 0 {
+			n = DefaultShards
+		}
+		repo.mask = uint64(ceilPowerOfTwo(n) - 1)
+	}
+}
+
+// NewMemory creates a new sharded memory repository
+func NewMemory(opts ...Option) (storage.Repository, error) {
+	const op = "storage.memory.NewMemory"
+
+	repo := &MemoryRepository{
+		mask:   DefaultShards - 1,
+		seed:   maphash.MakeSeed(),
+		policy: EvictReject,
+	}
+
+	for _, opt := range opts {
+		opt(repo)
+	}
+
+	switch repo.policy {
+	case EvictReject, EvictLRU, EvictOldest:
+	default:
+		return nil, fmt.Errorf("%s: unknown eviction policy %q", op, repo.policy)
+	}
+	if repo.maxLinks < 0 || repo.maxBytes < 0 {
+		return nil, fmt.Errorf("%s: negative limits: %d links, %d bytes", op, repo.maxLinks, repo.maxBytes)
+	}
+
+	shards := int(repo.mask + 1)
+	repo.codes = make([]codeShard, shards)
+	repo.canonicals = make([]canonicalShard, shards)
+	for i := 0; i < shards; i++ {
+		repo.codes[i].urls = make(map[string]*record)
+		repo.codes[i].reserved = make(map[string]struct{})
+		repo.canonicals[i].urls = make(map[string]*record)
+	}
+
+	if repo.snapshotFile != "" {
+		if err := repo.restore(); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return repo, nil
+}
+
+// ceilPowerOfTwo returns the smallest power of two not less than n
+func ceilPowerOfTwo(n int) int {
+	power := 1
+	for power < n {
+		power <<= 1
+	}
+	return power
+}
+
+// key returns the form of a short url used in the maps
+func (repo *MemoryRepository) key(shortURL string) string {
+	if repo.caseInsensitive {
+		return strings.ToLower(shortURL)
+	}
+	return shortURL
 }
 
-// NewMemory creates a new memory repository with maps and rwmutex
-func NewMemory() (storage.Repository, error) {
-	return &MemoryRepository{
-		shortToOriginal: make(map[string]string),
-		originalToShort: make(map[string]string),
-		urls:            make(map[int64]models.Url),
-		lastID:          0,
-	}, nil
+// codesFor returns the shard of the key of a short url
+func (repo *MemoryRepository) codesFor(key string) *codeShard {
+	return &repo.codes[maphash.String(repo.seed, key)&repo.mask]
+}
+
+// canonicalsFor returns the shard of a canonical url
+func (repo *MemoryRepository) canonicalsFor(canonicalURL string) *canonicalShard {
+	return &repo.canonicals[maphash.String(repo.seed, canonicalURL)&repo.mask]
+}
+
+// lock locks the shards url is stored in, canonicals is nil for standalone urls
+func (repo *MemoryRepository) lock(key string, url models.Url) (*codeShard, *canonicalShard) {
+	var canonicals *canonicalShard
+	if !url.Standalone {
+		canonicals = repo.canonicalsFor(url.CanonicalURL)
+		canonicals.mutex.Lock()
+	}
+
+	codes := repo.codesFor(key)
+	codes.mutex.Lock()
+
+	return codes, canonicals
+}
+
+// unlock unlocks the shards locked by lock
+func unlock(codes *codeShard, canonicals *canonicalShard) {
+	codes.mutex.Unlock()
+	if canonicals != nil {
+		canonicals.mutex.Unlock()
+	}
 }
 
 // GetURL retrieves the url from the storage by its short url
@@ -37,75 +190,239 @@ func (repo *MemoryRepository) GetURL(ctx context.Context, shortURL string) (mode
 		return models.Url{}, err
 	}
 
-	repo.mutex.RLock()
-	defer repo.mutex.RUnlock()
+	key := repo.key(shortURL)
+	codes := repo.codesFor(key)
 
-	_, exists := repo.shortToOriginal[shortURL]
+	codes.mutex.RLock()
+	defer codes.mutex.RUnlock()
+
+	rec, exists := codes.urls[key]
 	if !exists {
 		return models.Url{}, fmt.Errorf("%s: %w", op, storage.ErrURLMappingNotFound)
 	}
+	repo.touch(rec)
 
-	var url models.Url
-	for _, u := range repo.urls {
-		if u.ShortURL == shortURL {
-			url = u
-			break
-		}
-	}
-
-	return url, nil
+	return rec.Url, nil
 }
 
 // SaveURL saves a new pair of short url and original url into the storage
-func (repo *MemoryRepository) SaveURL(ctx context.Context, shortURL, originalURL string) (int64, error) {
+func (repo *MemoryRepository) SaveURL(ctx context.Context, url models.Url) (int64, error) {
 	const op = "storage.memory.SaveURL"
 
 	if err := ctx.Err(); err != nil {
 		return 0, err
 	}
 
-	repo.mutex.Lock()
-	defer repo.mutex.Unlock()
+	id, err := repo.save(url)
+	if err != nil {
+		return id, fmt.Errorf("%s: %w", op, err)
+	}
+	repo.evict()
+
+	return id, nil
+}
+
+// save stores url if neither its short url nor its canonical url is used
+func (repo *MemoryRepository) save(url models.Url) (int64, error) {
+	if url.CanonicalURL == "" {
+		url.CanonicalURL = url.OriginalURL
+	}
+
+	key := repo.key(url.ShortURL)
+	codes, canonicals := repo.lock(key, url)
+	defer unlock(codes, canonicals)
+
+	if _, exists := codes.urls[key]; exists {
+		return 0, storage.ErrURLMappingExists
+	}
+
+	if canonicals != nil {
+		if existing, exists := canonicals.urls[url.CanonicalURL]; exists {
+			return existing.Id, storage.ErrOriginalURLExists
+		}
+	}
+
+	saved, err := repo.insertLocked(codes, canonicals, key, url)
+	return saved.Id, err
+}
+
+// GetOrCreate returns the stored url with the canonical form of url,
+// if there is none it saves url. Both happen while the shards of url
+// are locked, so concurrent calls for the same url create it once.
+func (repo *MemoryRepository) GetOrCreate(ctx context.Context, url models.Url) (models.Url, bool, error) {
+	const op = "storage.memory.GetOrCreate"
+
+	if err := ctx.Err(); err != nil {
+		return models.Url{}, false, err
+	}
 
-	if _, exists := repo.shortToOriginal[shortURL]; exists {
-		return 0, fmt.Errorf("%s: %w", op, storage.ErrURLMappingExists)
+	saved, created, err := repo.getOrCreate(url)
+	if err != nil {
+		return models.Url{}, false, fmt.Errorf("%s: %w", op, err)
 	}
+	if created {
+		repo.evict()
+	}
+
+	return saved, created, nil
+}
+
+// getOrCreate returns the url with the canonical form of url or stores url
+func (repo *MemoryRepository) getOrCreate(url models.Url) (models.Url, bool, error) {
+	if url.CanonicalURL == "" {
+		url.CanonicalURL = url.OriginalURL
+	}
+
+	key := repo.key(url.ShortURL)
+	codes, canonicals := repo.lock(key, url)
+	defer unlock(codes, canonicals)
 
-	if existingShort, exists := repo.originalToShort[originalURL]; exists {
-		for _, url := range repo.urls {
-			if url.ShortURL == existingShort {
-				return url.Id, fmt.Errorf("%s: %w", op, storage.ErrOriginalURLExists)
-			}
+	if canonicals != nil {
+		if existing, exists := canonicals.urls[url.CanonicalURL]; exists {
+			repo.touch(existing)
+			return existing.Url, false, nil
 		}
 	}
 
-	repo.lastID++
+	if _, exists := codes.urls[key]; exists {
+		return models.Url{}, false, storage.ErrURLMappingExists
+	}
+
+	saved, err := repo.insertLocked(codes, canonicals, key, url)
+	if err != nil {
+		return models.Url{}, false, err
+	}
+	return saved, true, nil
+}
+
+// insertLocked stores a new url, the shards must be locked for writing.
+// It fails with ErrStorageFull if the url doesn't fit into the limits.
+func (repo *MemoryRepository) insertLocked(codes *codeShard, canonicals *canonicalShard, key string, url models.Url) (models.Url, error) {
+	// most urls are canonical already, the copy shares the memory then
+	canonicalURL := url.CanonicalURL
+	if canonicalURL == url.OriginalURL {
+		canonicalURL = url.OriginalURL
+	}
+
+	size := recordSize(url)
+	if !repo.admit(size) {
+		return models.Url{}, storage.ErrStorageFull
+	}
+
+	now := time.Now()
+	createdAt := url.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+
+	rec := &record{
+		Url: models.Url{
+			Id:           repo.lastID.Add(1),
+			ShortURL:     url.ShortURL,
+			OriginalURL:  url.OriginalURL,
+			CanonicalURL: canonicalURL,
+			Standalone:   url.Standalone,
+			CreatedAt:    createdAt,
+		},
+		size: size,
+	}
+	rec.lastAccess.Store(now.UnixNano())
+
+	codes.urls[key] = rec
+	if repo.policy == EvictOldest {
+		codes.appendOrdered(rec)
+	}
+	if canonicals != nil {
+		canonicals.urls[canonicalURL] = rec
+	}
+	delete(codes.reserved, key)
+
+	return rec.Url, nil
+}
+
+// OriginalURLExists checks if an url with the canonical form already exists in storage
+func (repo *MemoryRepository) OriginalURLExists(ctx context.Context, canonicalURL string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+
+	canonicals := repo.canonicalsFor(canonicalURL)
 
-	urlModel := models.Url{
-		Id:          repo.lastID,
-		ShortURL:    shortURL,
-		OriginalURL: originalURL,
-		CreatedAt:   time.Now(),
+	canonicals.mutex.RLock()
+	defer canonicals.mutex.RUnlock()
+
+	rec, exists := canonicals.urls[canonicalURL]
+	if !exists {
+		return "", false, nil
 	}
+	return rec.ShortURL, true, nil
+}
 
-	repo.shortToOriginal[shortURL] = originalURL
-	repo.originalToShort[originalURL] = shortURL
-	repo.urls[repo.lastID] = urlModel
+// CountURLs returns the number of stored urls
+func (repo *MemoryRepository) CountURLs(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
-	return repo.lastID, nil
+	return repo.count.Load(), nil
 }
 
-// OriginalURLExists checks if an original URL already exists in storage
-func (repo *MemoryRepository) OriginalURLExists(ctx context.Context, originalURL string) (string, bool, error) {
-    if err := ctx.Err(); err != nil {
-        return "", false, err
-    }
+// NextID returns the next value of the short code sequence
+func (repo *MemoryRepository) NextID(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return repo.sequence.Add(1), nil
+}
 
-    repo.mutex.RLock()
-    defer repo.mutex.RUnlock()
+// ReserveCodes reserves codes which are neither used nor reserved yet,
+// it returns the codes reserved by this call
+func (repo *MemoryRepository) ReserveCodes(ctx context.Context, codes []string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	reserved := make([]string, 0, len(codes))
+	for _, code := range codes {
+		if repo.reserve(code) {
+			reserved = append(reserved, code)
+		}
+	}
+
+	return reserved, nil
+}
+
+// reserve reserves a single code if it is free
+func (repo *MemoryRepository) reserve(code string) bool {
+	key := repo.key(code)
+	shard := repo.codesFor(key)
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if _, exists := shard.urls[key]; exists {
+		return false
+	}
+	if _, exists := shard.reserved[key]; exists {
+		return false
+	}
+	shard.reserved[key] = struct{}{}
+	return true
+}
+
+// ReleaseCodes returns unused reserved codes
+func (repo *MemoryRepository) ReleaseCodes(ctx context.Context, codes []string) error {
+	for _, code := range codes {
+		key := repo.key(code)
+		shard := repo.codesFor(key)
+
+		shard.mutex.Lock()
+		delete(shard.reserved, key)
+		shard.mutex.Unlock()
+	}
 
-    shortURL, exists := repo.originalToShort[originalURL]
-    return shortURL, exists, nil
+	return nil
 }
 
 func (repo *MemoryRepository) Close() {
@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+)
+
+// newFilledMemory creates a repository with n links
+func newFilledMemory(b *testing.B, n int, opts ...Option) *MemoryRepository {
+	b.Helper()
+
+	repo, _ := NewMemory(opts...)
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		_, err := repo.SaveURL(ctx, models.Url{
+			ShortURL:    benchCode(i),
+			OriginalURL: fmt.Sprintf("https://example.com/page/%d", i),
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	return repo.(*MemoryRepository)
+}
+
+// benchCode returns the code of the i-th benchmark link
+func benchCode(i int) string {
+	return fmt.Sprintf("c%09d", i)
+}
+
+func BenchmarkMemoryRepository_GetURL(b *testing.B) {
+	for _, n := range []int{1_000_000, 10_000_000} {
+		b.Run(fmt.Sprintf("links=%d", n), func(b *testing.B) {
+			if n > 1_000_000 && testing.Short() {
+				b.Skip("skipping 10M links in short mode")
+			}
+
+			repo := newFilledMemory(b, n)
+			codes := make([]string, 1024)
+			for i := range codes {
+				codes[i] = benchCode(i * (n / len(codes)))
+			}
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.GetURL(ctx, codes[i%len(codes)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMemoryRepository_Parallel compares the sharded repository with
+// a single shard, which behaves like a repository behind one mutex
+func BenchmarkMemoryRepository_Parallel(b *testing.B) {
+	const links = 100_000
+
+	workloads := []struct {
+		name       string
+		writeEvery int
+	}{
+		{name: "reads", writeEvery: 0},
+		{name: "writes=10%", writeEvery: 10},
+		{name: "writes=50%", writeEvery: 2},
+	}
+
+	for _, shards := range []int{1, DefaultShards} {
+		for _, w := range workloads {
+			b.Run(fmt.Sprintf("shards=%d/%s", shards, w.name), func(b *testing.B) {
+				repo := newFilledMemory(b, links, WithShards(shards))
+				codes := make([]string, links)
+				for i := range codes {
+					codes[i] = benchCode(i)
+				}
+				ctx := context.Background()
+				var next atomic.Int64
+				next.Store(links)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						i++
+						if w.writeEvery > 0 && i%w.writeEvery == 0 {
+							n := int(next.Add(1))
+							url := models.Url{ShortURL: benchCode(n), OriginalURL: benchCode(n)}
+							if _, _, err := repo.GetOrCreate(ctx, url); err != nil {
+								b.Fatal(err)
+							}
+							continue
+						}
+
+						if _, err := repo.GetURL(ctx, codes[i*7919%links]); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			})
+		}
+	}
+}
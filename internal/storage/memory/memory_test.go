@@ -2,6 +2,8 @@ package memory
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -18,16 +20,15 @@ func TestMemoryRepository_GetURL_Success(t *testing.T) {
 	originalURL := "https://example.com"
 
 	memRepo := repo.(*MemoryRepository)
-	memRepo.lastID = 1
 	url := models.Url{
 		Id:          1,
 		ShortURL:    shortURL,
 		OriginalURL: originalURL,
 		CreatedAt:   time.Now(),
 	}
-	memRepo.urls[1] = url
-	memRepo.shortToOriginal[shortURL] = originalURL
-	memRepo.originalToShort[originalURL] = shortURL
+	rec := &record{Url: url}
+	memRepo.codesFor(shortURL).urls[shortURL] = rec
+	memRepo.canonicalsFor(originalURL).urls[originalURL] = rec
 
 	result, err := repo.GetURL(ctx, shortURL)
 
@@ -66,15 +67,18 @@ func TestMemoryRepository_SaveURL_Success(t *testing.T) {
 	shortURL := "abc123"
 	originalURL := "https://example.com"
 
-	id, err := repo.SaveURL(ctx, shortURL, originalURL)
+	id, err := repo.SaveURL(ctx, models.Url{ShortURL: shortURL, OriginalURL: originalURL})
 
 	require.NoError(t, err)
 	assert.Equal(t, int64(1), id)
 
 	memRepo := repo.(*MemoryRepository)
-	assert.Equal(t, originalURL, memRepo.shortToOriginal[shortURL])
-	assert.Equal(t, shortURL, memRepo.originalToShort[originalURL])
-	assert.Equal(t, originalURL, memRepo.urls[id].OriginalURL)
+	byShort := memRepo.codesFor(shortURL).urls[shortURL]
+	byCanonical := memRepo.canonicalsFor(originalURL).urls[originalURL]
+	assert.Equal(t, originalURL, byShort.OriginalURL)
+	assert.Equal(t, shortURL, byCanonical.ShortURL)
+	assert.Equal(t, id, byShort.Id)
+	assert.Same(t, byShort, byCanonical)
 }
 
 func TestMemoryRepository_SaveURL_ShortURLExists(t *testing.T) {
@@ -84,10 +88,10 @@ func TestMemoryRepository_SaveURL_ShortURLExists(t *testing.T) {
 	originalURL1 := "https://example1.com"
 	originalURL2 := "https://example2.com"
 
-	_, err := repo.SaveURL(ctx, shortURL, originalURL1)
+	_, err := repo.SaveURL(ctx, models.Url{ShortURL: shortURL, OriginalURL: originalURL1})
 	require.NoError(t, err)
 
-	_, err = repo.SaveURL(ctx, shortURL, originalURL2)
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: shortURL, OriginalURL: originalURL2})
 
 	require.Error(t, err)
 	assert.ErrorIs(t, err, storage.ErrURLMappingExists)
@@ -100,10 +104,10 @@ func TestMemoryRepository_SaveURL_ShortURLExists(t *testing.T) {
 // 	shortURL2 := "def456"
 // 	originalURL := "https://example.com"
 
-// 	_, err := repo.SaveURL(ctx, shortURL1, originalURL)
+// 	_, err := repo.SaveURL(ctx, models.Url{ShortURL: shortURL1, OriginalURL: originalURL})
 // 	require.NoError(t, err)
 
-// 	_, err = repo.SaveURL(ctx, shortURL2, originalURL)
+// 	_, err = repo.SaveURL(ctx, models.Url{ShortURL: shortURL2, OriginalURL: originalURL})
 
 // 	require.Error(t, err)
 // 	assert.Contains(t, err.Error(), storage.ErrOriginalURLExists.Error())
@@ -117,7 +121,7 @@ func TestMemoryRepository_SaveURL_CanceledContext(t *testing.T) {
 	shortURL := "abc123"
 	originalURL := "https://example.com"
 
-	_, err := repo.SaveURL(ctx, shortURL, originalURL)
+	_, err := repo.SaveURL(ctx, models.Url{ShortURL: shortURL, OriginalURL: originalURL})
 
 	require.Error(t, err)
 	assert.ErrorIs(t, err, context.Canceled)
@@ -130,7 +134,9 @@ func TestMemoryRepository_OriginalURLExists_Found(t *testing.T) {
 	originalURL := "https://example.com"
 
 	memRepo := repo.(*MemoryRepository)
-	memRepo.originalToShort[originalURL] = shortURL
+	memRepo.canonicalsFor(originalURL).urls[originalURL] = &record{
+		Url: models.Url{ShortURL: shortURL, OriginalURL: originalURL},
+	}
 
 	resultShortURL, exists, err := repo.OriginalURLExists(ctx, originalURL)
 
@@ -162,3 +168,225 @@ func TestMemoryRepository_OriginalURLExists_CanceledContext(t *testing.T) {
 	require.Error(t, err)
 	assert.ErrorIs(t, err, context.Canceled)
 }
+
+func TestMemoryRepository_NextID(t *testing.T) {
+	repo, _ := NewMemory()
+	ctx := context.Background()
+	memRepo := repo.(*MemoryRepository)
+
+	first, err := memRepo.NextID(ctx)
+	require.NoError(t, err)
+
+	second, err := memRepo.NextID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, first+1, second)
+}
+
+func TestMemoryRepository_ReserveCodes(t *testing.T) {
+	repo, _ := NewMemory()
+	ctx := context.Background()
+	memRepo := repo.(*MemoryRepository)
+
+	_, err := repo.SaveURL(ctx, models.Url{ShortURL: "used", OriginalURL: "https://example.com"})
+	require.NoError(t, err)
+
+	reserved, err := memRepo.ReserveCodes(ctx, []string{"used", "free1", "free2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"free1", "free2"}, reserved)
+
+	reserved, err = memRepo.ReserveCodes(ctx, []string{"free1", "free3"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"free3"}, reserved)
+
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "free1", OriginalURL: "https://example1.com"})
+	require.NoError(t, err)
+	require.NoError(t, memRepo.ReleaseCodes(ctx, []string{"free2"}))
+
+	reserved, err = memRepo.ReserveCodes(ctx, []string{"free1", "free2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"free2"}, reserved)
+}
+
+func TestMemoryRepository_CaseInsensitiveCodes(t *testing.T) {
+	repo, _ := NewMemory(WithCaseInsensitiveCodes())
+	ctx := context.Background()
+
+	_, err := repo.SaveURL(ctx, models.Url{ShortURL: "AbC123", OriginalURL: "https://example.com"})
+	require.NoError(t, err)
+
+	url, err := repo.GetURL(ctx, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "AbC123", url.ShortURL)
+
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "ABC123", OriginalURL: "https://example.org"})
+	assert.ErrorIs(t, err, storage.ErrURLMappingExists)
+
+	memRepo := repo.(*MemoryRepository)
+	reserved, err := memRepo.ReserveCodes(ctx, []string{"abc123", "xyz789", "XYZ789"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"xyz789"}, reserved)
+}
+
+func TestMemoryRepository_CaseSensitiveCodes(t *testing.T) {
+	repo, _ := NewMemory()
+	ctx := context.Background()
+
+	_, err := repo.SaveURL(ctx, models.Url{ShortURL: "AbC123", OriginalURL: "https://example.com"})
+	require.NoError(t, err)
+
+	_, err = repo.GetURL(ctx, "abc123")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound)
+}
+
+func TestMemoryRepository_CountURLs(t *testing.T) {
+	repo, _ := NewMemory()
+	ctx := context.Background()
+
+	count, err := repo.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "abc123", OriginalURL: "https://example.com"})
+	require.NoError(t, err)
+
+	count, err = repo.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestMemoryRepository_SaveURL_CanonicalURLExists(t *testing.T) {
+	repo, _ := NewMemory()
+	ctx := context.Background()
+
+	_, err := repo.SaveURL(ctx, models.Url{
+		ShortURL:     "abc123",
+		OriginalURL:  "HTTPS://Example.com",
+		CanonicalURL: "https://example.com/",
+	})
+	require.NoError(t, err)
+
+	shortURL, exists, err := repo.OriginalURLExists(ctx, "https://example.com/")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "abc123", shortURL)
+
+	url, err := repo.GetURL(ctx, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTPS://Example.com", url.OriginalURL)
+	assert.Equal(t, "https://example.com/", url.CanonicalURL)
+}
+
+func TestMemoryRepository_SaveURL_Standalone(t *testing.T) {
+	repo, _ := NewMemory()
+	ctx := context.Background()
+	originalURL := "https://example.com"
+
+	_, err := repo.SaveURL(ctx, models.Url{ShortURL: "first1", OriginalURL: originalURL})
+	require.NoError(t, err)
+
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "second", OriginalURL: originalURL})
+	assert.ErrorIs(t, err, storage.ErrOriginalURLExists)
+
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "second", OriginalURL: originalURL, Standalone: true})
+	require.NoError(t, err)
+
+	shortURL, exists, err := repo.OriginalURLExists(ctx, originalURL)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "first1", shortURL, "standalone urls are not returned for deduplication")
+
+	url, err := repo.GetURL(ctx, "second")
+	require.NoError(t, err)
+	assert.True(t, url.Standalone)
+}
+
+func TestMemoryRepository_GetOrCreate(t *testing.T) {
+	repo, _ := NewMemory()
+	ctx := context.Background()
+	originalURL := "https://example.com"
+
+	saved, created, err := repo.GetOrCreate(ctx, models.Url{ShortURL: "first1", OriginalURL: originalURL})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "first1", saved.ShortURL)
+
+	saved, created, err = repo.GetOrCreate(ctx, models.Url{ShortURL: "second", OriginalURL: originalURL})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "first1", saved.ShortURL)
+
+	_, _, err = repo.GetOrCreate(ctx, models.Url{ShortURL: "first1", OriginalURL: "https://example.org"})
+	assert.ErrorIs(t, err, storage.ErrURLMappingExists)
+}
+
+func TestMemoryRepository_WithShards(t *testing.T) {
+	tests := []struct {
+		shards   int
+		expected int
+	}{
+		{shards: 0, expected: DefaultShards},
+		{shards: 1, expected: 1},
+		{shards: 3, expected: 4},
+		{shards: 16, expected: 16},
+	}
+
+	for _, tt := range tests {
+		repo, _ := NewMemory(WithShards(tt.shards))
+		memRepo := repo.(*MemoryRepository)
+
+		assert.Len(t, memRepo.codes, tt.expected, "shards %d", tt.shards)
+		assert.Len(t, memRepo.canonicals, tt.expected, "shards %d", tt.shards)
+	}
+}
+
+func TestMemoryRepository_ConcurrentAccess(t *testing.T) {
+	const (
+		goroutines = 16
+		urls       = 200
+	)
+
+	repo, _ := NewMemory(WithShards(4))
+	ctx := context.Background()
+	memRepo := repo.(*MemoryRepository)
+
+	codes := make([][]string, goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		codes[g] = make([]string, urls)
+
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < urls; i++ {
+				url := models.Url{
+					ShortURL:    fmt.Sprintf("g%d-%d", g, i),
+					OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+				}
+				saved, _, err := repo.GetOrCreate(ctx, url)
+				if !assert.NoError(t, err) {
+					return
+				}
+				codes[g][i] = saved.ShortURL
+
+				_, err = repo.GetURL(ctx, saved.ShortURL)
+				assert.NoError(t, err)
+				_, err = memRepo.ReserveCodes(ctx, []string{fmt.Sprintf("r%d-%d", g, i)})
+				assert.NoError(t, err)
+				_, _, err = repo.OriginalURLExists(ctx, url.OriginalURL)
+				assert.NoError(t, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	count, err := repo.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(urls), count)
+
+	for i := 0; i < urls; i++ {
+		for g := 1; g < goroutines; g++ {
+			assert.Equal(t, codes[0][i], codes[g][i], "url %d", i)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+)
+
+// A snapshot file consists of the magic, the big-endian version, the gob
+// encoded snapshot and the sha256 checksum of everything before it
+const (
+	snapshotMagic   = "URLSNAP\x00"
+	snapshotVersion = uint32(1)
+	snapshotHeader  = len(snapshotMagic) + 4
+)
+
+// ErrInvalidSnapshot is returned when a snapshot file is damaged or unsupported
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// snapshot is the state of the repository
+type snapshot struct {
+	Sequence  int64
+	LastID    int64
+	Evictions int64
+	URLs      []snapshotURL
+}
+
+// snapshotURL is a stored url, CanonicalURL is empty if it equals OriginalURL
+type snapshotURL struct {
+	Id           int64
+	ShortURL     string
+	OriginalURL  string
+	CanonicalURL string
+	Standalone   bool
+	CreatedAt    time.Time
+	LastAccess   int64
+}
+
+// WithSnapshotFile sets the file the repository is restored from when it is
+// created and which Snapshot writes to, a missing file means an empty repository
+func WithSnapshotFile(path string) Option {
+	return func(repo *MemoryRepository) {
+		repo.snapshotFile = path
+	}
+}
+
+// Snapshot writes the state of the repository to the snapshot file,
+// it returns the number of saved urls. The file is replaced atomically.
+func (repo *MemoryRepository) Snapshot(ctx context.Context) (int64, error) {
+	const op = "storage.memory.Snapshot"
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if repo.snapshotFile == "" {
+		return 0, fmt.Errorf("%s: snapshot file is not configured", op)
+	}
+
+	snap := repo.snapshot()
+	if err := writeFileAtomic(repo.snapshotFile, func(w io.Writer) error {
+		return writeSnapshot(w, snap)
+	}); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int64(len(snap.URLs)), nil
+}
+
+// snapshot copies the state of the repository
+func (repo *MemoryRepository) snapshot() snapshot {
+	records := repo.sortedRecords()
+
+	snap := snapshot{
+		Sequence:  repo.sequence.Load(),
+		LastID:    repo.lastID.Load(),
+		Evictions: repo.evictions.Load(),
+		URLs:      make([]snapshotURL, 0, len(records)),
+	}
+	for _, rec := range records {
+		url := snapshotURL{
+			Id:          rec.Id,
+			ShortURL:    rec.ShortURL,
+			OriginalURL: rec.OriginalURL,
+			Standalone:  rec.Standalone,
+			CreatedAt:   rec.CreatedAt,
+			LastAccess:  rec.lastAccess.Load(),
+		}
+		if rec.CanonicalURL != rec.OriginalURL {
+			url.CanonicalURL = rec.CanonicalURL
+		}
+		snap.URLs = append(snap.URLs, url)
+	}
+
+	return snap
+}
+
+// restore loads the snapshot file into the empty repository
+func (repo *MemoryRepository) restore() error {
+	const op = "storage.memory.restore"
+
+	data, err := os.ReadFile(repo.snapshotFile)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info("snapshot file not found, starting empty", "file", repo.snapshotFile)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	snap, err := readSnapshot(data)
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", op, repo.snapshotFile, err)
+	}
+
+	for _, url := range snap.URLs {
+		if err := repo.restoreURL(url); err != nil {
+			return fmt.Errorf("%s: %s: %w", op, repo.snapshotFile, err)
+		}
+	}
+	repo.sequence.Store(snap.Sequence)
+	repo.lastID.Store(snap.LastID)
+	repo.evictions.Store(snap.Evictions)
+
+	slog.Info("restored snapshot", "file", repo.snapshotFile, "urls", len(snap.URLs))
+
+	if repo.exceeds(repo.count.Load(), repo.bytes.Load()) {
+		slog.Warn("restored snapshot exceeds the limits", "urls", repo.count.Load(), "bytes", repo.bytes.Load())
+		repo.evict()
+	}
+	return nil
+}
+
+// restoreURL stores a url of the snapshot keeping its id and times
+func (repo *MemoryRepository) restoreURL(url snapshotURL) error {
+	if url.CanonicalURL == "" {
+		url.CanonicalURL = url.OriginalURL
+	}
+
+	rec := &record{
+		Url: models.Url{
+			Id:           url.Id,
+			ShortURL:     url.ShortURL,
+			OriginalURL:  url.OriginalURL,
+			CanonicalURL: url.CanonicalURL,
+			Standalone:   url.Standalone,
+			CreatedAt:    url.CreatedAt,
+		},
+	}
+	rec.size = recordSize(rec.Url)
+	rec.lastAccess.Store(url.LastAccess)
+
+	key := repo.key(url.ShortURL)
+	codes := repo.codesFor(key)
+	if _, exists := codes.urls[key]; exists {
+		return fmt.Errorf("%w: duplicate short url %q", ErrInvalidSnapshot, url.ShortURL)
+	}
+	if !url.Standalone {
+		canonicals := repo.canonicalsFor(url.CanonicalURL)
+		if _, exists := canonicals.urls[url.CanonicalURL]; exists {
+			return fmt.Errorf("%w: duplicate url %q", ErrInvalidSnapshot, url.CanonicalURL)
+		}
+		canonicals.urls[url.CanonicalURL] = rec
+	}
+
+	codes.urls[key] = rec
+	if repo.policy == EvictOldest {
+		codes.appendOrdered(rec)
+	}
+	repo.count.Add(1)
+	repo.bytes.Add(rec.size)
+	return nil
+}
+
+// writeSnapshot writes snap in the snapshot file format
+func writeSnapshot(w io.Writer, snap snapshot) error {
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+
+	header := make([]byte, snapshotHeader)
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint32(header[len(snapshotMagic):], snapshotVersion)
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(out).Encode(snap); err != nil {
+		return err
+	}
+
+	_, err := w.Write(hash.Sum(nil))
+	return err
+}
+
+// readSnapshot parses data in the snapshot file format
+func readSnapshot(data []byte) (snapshot, error) {
+	if len(data) < snapshotHeader+sha256.Size || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return snapshot{}, fmt.Errorf("%w: not a snapshot file", ErrInvalidSnapshot)
+	}
+
+	content, checksum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if sum := sha256.Sum256(content); !bytes.Equal(sum[:], checksum) {
+		return snapshot{}, fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)
+	}
+
+	if version := binary.BigEndian.Uint32(content[len(snapshotMagic):]); version != snapshotVersion {
+		return snapshot{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, version)
+	}
+
+	var snap snapshot
+	if err := gob.NewDecoder(bytes.NewReader(content[snapshotHeader:])).Decode(&snap); err != nil {
+		return snapshot{}, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	return snap, nil
+}
+
+// writeFileAtomic writes a temporary file next to path with write
+// and renames it to path, so path always holds a complete file
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	buffered := bufio.NewWriter(tmp)
+	if err := write(buffered); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
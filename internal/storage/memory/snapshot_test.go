@@ -0,0 +1,156 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepository_SnapshotRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.snapshot")
+	ctx := context.Background()
+
+	repo, err := NewMemory(WithSnapshotFile(path))
+	require.NoError(t, err)
+	memRepo := repo.(*MemoryRepository)
+
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "abc123", OriginalURL: "HTTPS://Example.com", CanonicalURL: "https://example.com/"})
+	require.NoError(t, err)
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "def456", OriginalURL: "https://example.org"})
+	require.NoError(t, err)
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "ghi789", OriginalURL: "https://example.org", Standalone: true})
+	require.NoError(t, err)
+	_, err = memRepo.NextID(ctx)
+	require.NoError(t, err)
+
+	saved, err := memRepo.Snapshot(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), saved)
+
+	restored, err := NewMemory(WithSnapshotFile(path))
+	require.NoError(t, err)
+
+	original, err := repo.GetURL(ctx, "abc123")
+	require.NoError(t, err)
+	url, err := restored.GetURL(ctx, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, original.Id, url.Id)
+	assert.Equal(t, "HTTPS://Example.com", url.OriginalURL)
+	assert.Equal(t, "https://example.com/", url.CanonicalURL)
+	assert.True(t, original.CreatedAt.Equal(url.CreatedAt))
+
+	shortURL, exists, err := restored.OriginalURLExists(ctx, "https://example.org")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "def456", shortURL)
+
+	url, err = restored.GetURL(ctx, "ghi789")
+	require.NoError(t, err)
+	assert.True(t, url.Standalone)
+
+	count, err := restored.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	next, err := restored.(*MemoryRepository).NextID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), next, "the sequence continues")
+
+	id, err := restored.SaveURL(ctx, models.Url{ShortURL: "new123", OriginalURL: "https://example.net"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), id, "ids continue")
+}
+
+func TestMemoryRepository_RestoreMissingFile(t *testing.T) {
+	repo, err := NewMemory(WithSnapshotFile(filepath.Join(t.TempDir(), "missing")))
+	require.NoError(t, err)
+
+	count, err := repo.CountURLs(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestMemoryRepository_RestoreInvalidSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.snapshot")
+
+	repo, err := NewMemory(WithSnapshotFile(path))
+	require.NoError(t, err)
+	_, err = repo.SaveURL(ctx, models.Url{ShortURL: "abc123", OriginalURL: "https://example.com"})
+	require.NoError(t, err)
+	_, err = repo.(storage.Snapshotter).Snapshot(ctx)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{
+			name:   "not a snapshot",
+			modify: func([]byte) []byte { return []byte("abc123 https://example.com") },
+		},
+		{
+			name: "damaged",
+			modify: func(data []byte) []byte {
+				data[len(data)/2] ^= 0xff
+				return data
+			},
+		},
+		{
+			name:   "truncated",
+			modify: func(data []byte) []byte { return data[:len(data)-1] },
+		},
+		{
+			name: "unsupported version",
+			modify: func(data []byte) []byte {
+				data[snapshotHeader-1]++
+				return data
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := filepath.Join(t.TempDir(), "urls.snapshot")
+			require.NoError(t, os.WriteFile(corrupted, tt.modify(append([]byte(nil), data...)), 0o600))
+
+			_, err := NewMemory(WithSnapshotFile(corrupted))
+			assert.ErrorIs(t, err, ErrInvalidSnapshot)
+		})
+	}
+}
+
+func TestMemoryRepository_RestoreOverLimits(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.snapshot")
+
+	repo, err := NewMemory(WithSnapshotFile(path))
+	require.NoError(t, err)
+	saveAll(t, repo, "first1", "second", "third3")
+	_, err = repo.(storage.Snapshotter).Snapshot(ctx)
+	require.NoError(t, err)
+
+	restored, err := NewMemory(WithSnapshotFile(path), WithLimits(2, 0, EvictOldest))
+	require.NoError(t, err)
+
+	_, err = restored.GetURL(ctx, "first1")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound)
+	_, err = restored.GetURL(ctx, "third3")
+	assert.NoError(t, err)
+}
+
+func TestMemoryRepository_SnapshotWithoutFile(t *testing.T) {
+	repo, _ := NewMemory()
+
+	_, err := repo.(storage.Snapshotter).Snapshot(context.Background())
+	assert.Error(t, err)
+}
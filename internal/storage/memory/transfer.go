@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+)
+
+// ForEachURL calls fn for every stored url with an id greater than afterID
+// in the order of ids. The urls are copied at once, the ones saved while
+// fn is called are not visited.
+func (repo *MemoryRepository) ForEachURL(ctx context.Context, afterID int64, fn func(models.Url) error) error {
+	const op = "storage.memory.ForEachURL"
+
+	for _, rec := range repo.sortedRecords() {
+		if rec.Id <= afterID {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(rec.Url); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// SaveURLs saves urls keeping their short urls and creation times
+func (repo *MemoryRepository) SaveURLs(ctx context.Context, urls []models.Url) ([]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(urls))
+	for i, url := range urls {
+		if _, err := repo.save(url); err != nil {
+			if !errors.Is(err, storage.ErrURLMappingExists) &&
+				!errors.Is(err, storage.ErrOriginalURLExists) &&
+				!errors.Is(err, storage.ErrStorageFull) {
+				return nil, err
+			}
+			errs[i] = err
+		}
+	}
+	repo.evict()
+
+	return errs, nil
+}
+
+// sortedRecords returns all records in the order of ids. All code shards
+// are locked for reading at once, so the copy is consistent.
+func (repo *MemoryRepository) sortedRecords() []*record {
+	for i := range repo.codes {
+		repo.codes[i].mutex.RLock()
+	}
+
+	records := make([]*record, 0, repo.count.Load())
+	for i := range repo.codes {
+		for _, rec := range repo.codes[i].urls {
+			records = append(records, rec)
+		}
+	}
+
+	for i := range repo.codes {
+		repo.codes[i].mutex.RUnlock()
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Id < records[j].Id
+	})
+	return records
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepository_SaveURLs(t *testing.T) {
+	repo, _ := NewMemory()
+	ctx := context.Background()
+	memRepo := repo.(*MemoryRepository)
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	saveAll(t, repo, "exists")
+
+	errs, err := memRepo.SaveURLs(ctx, []models.Url{
+		{ShortURL: "first1", OriginalURL: "https://example.org", CreatedAt: createdAt},
+		{ShortURL: "exists", OriginalURL: "https://example.net"},
+		{ShortURL: "second", OriginalURL: "https://example.com/exists"},
+		{ShortURL: "third3", OriginalURL: "https://example.org", Standalone: true},
+	})
+	require.NoError(t, err)
+	require.Len(t, errs, 4)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], storage.ErrURLMappingExists)
+	assert.ErrorIs(t, errs[2], storage.ErrOriginalURLExists)
+	assert.NoError(t, errs[3])
+
+	url, err := repo.GetURL(ctx, "first1")
+	require.NoError(t, err)
+	assert.Equal(t, createdAt, url.CreatedAt)
+}
+
+func TestMemoryRepository_ForEachURL(t *testing.T) {
+	repo, _ := NewMemory()
+	ctx := context.Background()
+	memRepo := repo.(*MemoryRepository)
+
+	saveAll(t, repo, "first1", "second", "third3")
+
+	var codes []string
+	err := memRepo.ForEachURL(ctx, 0, func(url models.Url) error {
+		codes = append(codes, url.ShortURL)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first1", "second", "third3"}, codes)
+
+	codes = nil
+	err = memRepo.ForEachURL(ctx, 1, func(url models.Url) error {
+		codes = append(codes, url.ShortURL)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"second", "third3"}, codes)
+
+	stop := errors.New("stop")
+	err = memRepo.ForEachURL(ctx, 0, func(models.Url) error {
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+}
+
+func TestMemoryRepository_EvictOldestImported(t *testing.T) {
+	repo, _ := NewMemory(WithShards(1), WithLimits(2, 0, EvictOldest))
+	ctx := context.Background()
+
+	saveAll(t, repo, "first1", "second")
+
+	_, err := repo.(*MemoryRepository).SaveURLs(ctx, []models.Url{
+		{ShortURL: "old123", OriginalURL: "https://example.org", CreatedAt: time.Now().Add(-time.Hour)},
+	})
+	require.NoError(t, err)
+
+	_, err = repo.GetURL(ctx, "old123")
+	assert.ErrorIs(t, err, storage.ErrURLMappingNotFound, "the imported url is the oldest one")
+	_, err = repo.GetURL(ctx, "first1")
+	assert.NoError(t, err)
+}
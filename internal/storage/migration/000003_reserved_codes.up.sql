@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS reserved_codes (
+    short_url VARCHAR(255) PRIMARY KEY,
+    reserved_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_short_url_lower;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_short_url_lower ON url_mappings (lower(short_url));
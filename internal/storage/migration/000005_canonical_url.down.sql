@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_canonical_url;
+
+ALTER TABLE url_mappings DROP COLUMN IF EXISTS canonical_url;
@@ -0,0 +1,9 @@
+ALTER TABLE url_mappings ADD COLUMN IF NOT EXISTS canonical_url TEXT;
+
+-- existing rows keep the original url, they are brought to the canonical
+-- form by running url-shortener -recanonicalize after the migration
+UPDATE url_mappings SET canonical_url = original_url WHERE canonical_url IS NULL;
+
+ALTER TABLE url_mappings ALTER COLUMN canonical_url SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_canonical_url ON url_mappings(canonical_url);
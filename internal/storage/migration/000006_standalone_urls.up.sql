@@ -0,0 +1 @@
+ALTER TABLE url_mappings ADD COLUMN IF NOT EXISTS standalone BOOLEAN NOT NULL DEFAULT FALSE;
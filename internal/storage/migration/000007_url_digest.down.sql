@@ -0,0 +1,6 @@
+CREATE INDEX IF NOT EXISTS idx_original_url ON url_mappings(original_url);
+CREATE INDEX IF NOT EXISTS idx_canonical_url ON url_mappings(canonical_url);
+
+DROP INDEX IF EXISTS idx_url_digest;
+
+ALTER TABLE url_mappings DROP COLUMN IF EXISTS url_digest;
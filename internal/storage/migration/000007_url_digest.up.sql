@@ -0,0 +1,17 @@
+ALTER TABLE url_mappings ADD COLUMN IF NOT EXISTS url_digest BYTEA;
+
+UPDATE url_mappings SET url_digest = sha256(convert_to(canonical_url, 'UTF8')) WHERE url_digest IS NULL;
+
+ALTER TABLE url_mappings ALTER COLUMN url_digest SET NOT NULL;
+
+-- earlier rows could duplicate a url, the oldest one stays deduplicated
+UPDATE url_mappings m SET standalone = TRUE
+WHERE NOT m.standalone AND EXISTS (
+    SELECT 1 FROM url_mappings o
+    WHERE o.url_digest = m.url_digest AND NOT o.standalone AND o.id < m.id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_url_digest ON url_mappings(url_digest) WHERE NOT standalone;
+
+DROP INDEX IF EXISTS idx_canonical_url;
+DROP INDEX IF EXISTS idx_original_url;
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/config"
+	"github.com/hard-gainer/url-shortener/internal/metrics"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// poolSaturationRatio is a share of acquired connections at which the pool is reported as saturated
+const poolSaturationRatio = 0.9
+
+// newPool creates a connection pool for connString tuned by cfg
+func newPool(ctx context.Context, connString string, cfg config.DBConfig) (*pgxpool.Pool, error) {
+	poolCfg, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxConns > 0 {
+		poolCfg.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolCfg.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolCfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolCfg.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("could not ping database: %w", err)
+	}
+
+	return pool, nil
+}
+
+// monitorPool publishes pool statistics every interval until ctx is done
+// and warns when requests have to wait for a free connection
+func monitorPool(ctx context.Context, pool *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastEmptyAcquires int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stat := pool.Stat()
+		metrics.DBPoolTotalConns.Set(int64(stat.TotalConns()))
+		metrics.DBPoolAcquiredConns.Set(int64(stat.AcquiredConns()))
+		metrics.DBPoolIdleConns.Set(int64(stat.IdleConns()))
+		metrics.DBPoolMaxConns.Set(int64(stat.MaxConns()))
+		metrics.DBPoolEmptyAcquires.Set(stat.EmptyAcquireCount())
+		metrics.DBPoolAcquireWaitMs.Set(stat.AcquireDuration().Milliseconds())
+
+		waited := stat.EmptyAcquireCount() - lastEmptyAcquires
+		lastEmptyAcquires = stat.EmptyAcquireCount()
+
+		if float64(stat.AcquiredConns()) >= poolSaturationRatio*float64(stat.MaxConns()) || waited > 0 {
+			slog.Warn("database pool is saturated",
+				"acquired", stat.AcquiredConns(),
+				"max", stat.MaxConns(),
+				"waited_acquires", waited,
+			)
+		}
+	}
+}
+
+// withTimeout limits ctx by timeout, zero timeout leaves ctx as is
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// observeTimeout counts err in the metrics if the query ran out of time
+func observeTimeout(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) {
+		metrics.DBQueryTimeouts.Add(1)
+	}
+	return err
+}
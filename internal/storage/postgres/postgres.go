@@ -2,19 +2,25 @@ package postgres
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hard-gainer/url-shortener/internal/config"
 	"github.com/hard-gainer/url-shortener/internal/models"
 	"github.com/hard-gainer/url-shortener/internal/storage"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// urlDigestIndex is the unique index of digests of deduplicated urls
+const urlDigestIndex = "idx_url_digest"
+
 type qurier interface {
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
 	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
 	Begin(ctx context.Context) (pgx.Tx, error)
 	Close()
@@ -22,24 +28,61 @@ type qurier interface {
 
 // A postgres implementation of the repository
 type PostgresRepository struct {
-	db qurier
+	primary      qurier
+	replicas     *replicaSet
+	recent       *recentWrites
+	retry        retryPolicy
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	stopMonitor  context.CancelFunc
+	// caseInsensitive makes codes differing only in case the same code
+	caseInsensitive bool
+	// reservationTTL is an age at which reserved codes are freed
+	reservationTTL time.Duration
 }
 
 // NewPostgres creates a new PostgreSQL repository with connection pool
 func NewPostgres(cfg *config.Config) (storage.Repository, error) {
 	const op = "storage.postgres.NewPostgres"
 
-	connPool, err := pgxpool.New(context.Background(), cfg.DBConfig.URL)
+	connPool, err := newPool(context.Background(), cfg.DBConfig.URL, cfg.DBConfig)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := connPool.Ping(context.Background()); err != nil {
-		connPool.Close()
-		return nil, fmt.Errorf("%s: could not ping database: %w", op, err)
+	var replicaPools []qurier
+	for i, replicaURL := range cfg.DBConfig.ReplicaURLs {
+		replicaPool, err := newPool(context.Background(), replicaURL, cfg.DBConfig)
+		if err != nil {
+			connPool.Close()
+			for _, p := range replicaPools {
+				p.Close()
+			}
+			return nil, fmt.Errorf("%s: replica %d: %w", op, i, err)
+		}
+		replicaPools = append(replicaPools, replicaPool)
+	}
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	if cfg.DBConfig.PoolStatsInterval > 0 {
+		go monitorPool(monitorCtx, connPool, cfg.DBConfig.PoolStatsInterval)
 	}
 
-	return &PostgresRepository{db: connPool}, nil
+	return &PostgresRepository{
+		primary:  connPool,
+		replicas: newReplicaSet(replicaPools, cfg.DBConfig.ReplicaRetryInterval),
+		recent:   newRecentWrites(cfg.DBConfig.ReadYourWritesWindow),
+		retry: retryPolicy{
+			maxAttempts: cfg.DBConfig.RetryMaxAttempts,
+			baseDelay:   cfg.DBConfig.RetryBaseDelay,
+			maxDelay:    cfg.DBConfig.RetryMaxDelay,
+		},
+		readTimeout:     cfg.DBConfig.ReadTimeout,
+		writeTimeout:    cfg.DBConfig.WriteTimeout,
+		stopMonitor:     stopMonitor,
+		caseInsensitive: cfg.GeneratorConfig.CaseInsensitive,
+		reservationTTL:  cfg.GeneratorConfig.PoolReservationTTL,
+	}, nil
 }
 
 // GetURL retrieves the url from the storage by its short url
@@ -47,89 +90,474 @@ func (repo *PostgresRepository) GetURL(ctx context.Context, shortURL string) (mo
 	const op = "storage.postgres.GetURL"
 	var url models.Url
 
-	err := repo.db.QueryRow(ctx,
-		`SELECT id, short_url, original_url, created_at 
-         FROM url_mappings  
-         WHERE short_url = $1`,
-		shortURL).Scan(&url.Id, &url.ShortURL, &url.OriginalURL, &url.CreatedAt)
+	err := repo.retry.do(ctx, func() error {
+		return repo.read(ctx, shortKey(repo.fold(shortURL)), func(ctx context.Context, db qurier) error {
+			if repo.caseInsensitive {
+				// codes created before the mode was enabled may differ only in case,
+				// the exact match wins then
+				return db.QueryRow(ctx,
+					`SELECT id, short_url, original_url, canonical_url, standalone, created_at
+					 FROM url_mappings
+					 WHERE lower(short_url) = lower($1)
+					 ORDER BY short_url = $1 DESC, id
+					 LIMIT 1`,
+					shortURL).Scan(&url.Id, &url.ShortURL, &url.OriginalURL, &url.CanonicalURL, &url.Standalone, &url.CreatedAt)
+			}
+
+			return db.QueryRow(ctx,
+				`SELECT id, short_url, original_url, canonical_url, standalone, created_at
+                 FROM url_mappings
+                 WHERE short_url = $1`,
+				shortURL).Scan(&url.Id, &url.ShortURL, &url.OriginalURL, &url.CanonicalURL, &url.Standalone, &url.CreatedAt)
+		})
+	})
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return models.Url{}, fmt.Errorf("%s: %w", op, storage.ErrURLMappingNotFound)
 		}
-		return models.Url{}, fmt.Errorf("%s: %w", op, err)
+		return models.Url{}, fmt.Errorf("%s: %w", op, observeTimeout(err))
 	}
 
 	return url, nil
 }
 
 // SaveURL saves a new pair of short url and original url into the storage
-func (repo *PostgresRepository) SaveURL(ctx context.Context, shortURL, originalURL string) (int64, error) {
+func (repo *PostgresRepository) SaveURL(ctx context.Context, url models.Url) (int64, error) {
+	if url.CanonicalURL == "" {
+		url.CanonicalURL = url.OriginalURL
+	}
+
+	var id int64
+	err := repo.retry.do(ctx, func() error {
+		var err error
+		id, err = repo.saveURL(ctx, url)
+		return err
+	})
+
+	return id, err
+}
+
+// saveURL makes a single attempt to save a new pair of short url and original url
+func (repo *PostgresRepository) saveURL(ctx context.Context, url models.Url) (int64, error) {
 	const op = "storage.postgres.SaveURL"
 
-	tx, err := repo.db.Begin(ctx)
+	ctx, cancel := withTimeout(ctx, repo.writeTimeout)
+	defer cancel()
+
+	tx, err := repo.primary.Begin(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("%s: failed to begin transaction: %w", op, err)
+		return 0, fmt.Errorf("%s: failed to begin transaction: %w", op, observeTimeout(err))
 	}
 	defer tx.Rollback(ctx)
 
-	var existingShort string
-	err = tx.QueryRow(ctx,
-		`SELECT short_url
-		 FROM url_mappings
-		 WHERE original_url = $1`,
-		originalURL).Scan(&existingShort)
+	if !url.Standalone {
+		var existingShort string
+		err = tx.QueryRow(ctx,
+			`SELECT short_url
+			 FROM url_mappings
+			 WHERE url_digest = $1 AND NOT standalone`,
+			urlDigest(url.CanonicalURL)).Scan(&existingShort)
+
+		if err == nil {
+			return 0, fmt.Errorf("%s: %w: %s", op, storage.ErrOriginalURLExists, existingShort)
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, fmt.Errorf("%s: checking existing URL: %w", op, observeTimeout(err))
+		}
+	}
 
-	if err == nil {
-		return 0, fmt.Errorf("%s: %w: %s", op, storage.ErrOriginalURLExists, existingShort)
-	} else if !errors.Is(err, pgx.ErrNoRows) {
-		return 0, fmt.Errorf("%s: checking existing URL: %w", op, err)
+	if repo.caseInsensitive {
+		if err := repo.checkFoldedCode(ctx, tx, url.ShortURL); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
 	}
 
 	var id int64
 	err = tx.QueryRow(ctx,
-		`INSERT INTO url_mappings(short_url, original_url)
-         VALUES($1, $2)
+		`INSERT INTO url_mappings(short_url, original_url, canonical_url, url_digest, standalone)
+         VALUES($1, $2, $3, $4, $5)
          RETURNING id`,
-		shortURL, originalURL).Scan(&id)
+		url.ShortURL, url.OriginalURL, url.CanonicalURL, urlDigest(url.CanonicalURL), url.Standalone).Scan(&id)
 
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			if pgErr.ConstraintName == urlDigestIndex {
+				return 0, fmt.Errorf("%s: %w", op, storage.ErrOriginalURLExists)
+			}
 			return 0, fmt.Errorf("%s: %w", op, storage.ErrURLMappingExists)
 		}
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return 0, fmt.Errorf("%s: %w", op, observeTimeout(err))
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM reserved_codes
+		 WHERE short_url = $1`,
+		url.ShortURL)
+	if err != nil {
+		return 0, fmt.Errorf("%s: releasing reserved code: %w", op, observeTimeout(err))
 	}
 
 	if err = tx.Commit(ctx); err != nil {
-		return 0, fmt.Errorf("%s: failed to commit transaction: %w", op, err)
+		return 0, fmt.Errorf("%s: failed to commit transaction: %w", op, &commitError{observeTimeout(err)})
 	}
 
+	now := time.Now()
+	repo.recent.add(shortKey(repo.fold(url.ShortURL)), now)
+	repo.recent.add(originalKey(url.CanonicalURL), now)
+
 	return id, nil
 }
 
-// OriginalURLExists checks if an original URL already exists in storage
-func (repo *PostgresRepository) OriginalURLExists(ctx context.Context, originalURL string) (string, bool, error) {
+// GetOrCreate returns the stored url with the canonical form of url,
+// if there is none it saves url. The insert and the lookup are a single
+// statement relying on the unique index of url digests, so concurrent
+// calls for the same url create it once.
+func (repo *PostgresRepository) GetOrCreate(ctx context.Context, url models.Url) (models.Url, bool, error) {
+	if url.CanonicalURL == "" {
+		url.CanonicalURL = url.OriginalURL
+	}
+
+	var saved models.Url
+	var created bool
+	err := repo.retry.do(ctx, func() error {
+		var err error
+		saved, created, err = repo.getOrCreate(ctx, url)
+		return err
+	})
+
+	return saved, created, err
+}
+
+// getOrCreateAttempts is a number of times the insert is repeated when the
+// conflicting row was committed after the statement had started
+const getOrCreateAttempts = 3
+
+// getOrCreate makes a single attempt to get or create url
+func (repo *PostgresRepository) getOrCreate(ctx context.Context, url models.Url) (models.Url, bool, error) {
+	const op = "storage.postgres.GetOrCreate"
+
+	ctx, cancel := withTimeout(ctx, repo.writeTimeout)
+	defer cancel()
+
+	tx, err := repo.primary.Begin(ctx)
+	if err != nil {
+		return models.Url{}, false, fmt.Errorf("%s: failed to begin transaction: %w", op, observeTimeout(err))
+	}
+	defer tx.Rollback(ctx)
+
+	var saved models.Url
+	if repo.caseInsensitive {
+		// the stored url is returned even if its code differs from url's one
+		// only in case, like in the memory storage, so an alias submitted
+		// again in another case isn't taken by itself
+		if !url.Standalone {
+			err = tx.QueryRow(ctx,
+				`SELECT id, short_url, original_url, canonical_url, standalone, created_at
+				 FROM url_mappings
+				 WHERE url_digest = $1 AND NOT standalone`,
+				urlDigest(url.CanonicalURL)).
+				Scan(&saved.Id, &saved.ShortURL, &saved.OriginalURL, &saved.CanonicalURL, &saved.Standalone, &saved.CreatedAt)
+			if err == nil {
+				return saved, false, nil
+			} else if !errors.Is(err, pgx.ErrNoRows) {
+				return models.Url{}, false, fmt.Errorf("%s: checking existing URL: %w", op, observeTimeout(err))
+			}
+		}
+
+		if err := repo.checkFoldedCode(ctx, tx, url.ShortURL); err != nil {
+			return models.Url{}, false, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	var created bool
+	for i := 0; ; i++ {
+		// a row inserted by a concurrent transaction makes the insert do
+		// nothing, but it isn't visible in the snapshot of the statement,
+		// the next statement sees it
+		err = tx.QueryRow(ctx,
+			`WITH inserted AS (
+				INSERT INTO url_mappings(short_url, original_url, canonical_url, url_digest, standalone)
+				VALUES($1, $2, $3, $4, $5)
+				ON CONFLICT (url_digest) WHERE NOT standalone DO NOTHING
+				RETURNING id, short_url, original_url, canonical_url, standalone, created_at
+			 )
+			 SELECT id, short_url, original_url, canonical_url, standalone, created_at, TRUE
+			 FROM inserted
+			 UNION ALL
+			 SELECT id, short_url, original_url, canonical_url, standalone, created_at, FALSE
+			 FROM url_mappings
+			 WHERE url_digest = $4 AND NOT standalone
+			 LIMIT 1`,
+			url.ShortURL, url.OriginalURL, url.CanonicalURL, urlDigest(url.CanonicalURL), url.Standalone).
+			Scan(&saved.Id, &saved.ShortURL, &saved.OriginalURL, &saved.CanonicalURL, &saved.Standalone, &saved.CreatedAt, &created)
+		if !errors.Is(err, pgx.ErrNoRows) || i+1 == getOrCreateAttempts {
+			break
+		}
+	}
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return models.Url{}, false, fmt.Errorf("%s: %w", op, storage.ErrURLMappingExists)
+		}
+		return models.Url{}, false, fmt.Errorf("%s: %w", op, observeTimeout(err))
+	}
+
+	if created {
+		_, err = tx.Exec(ctx,
+			`DELETE FROM reserved_codes
+			 WHERE short_url = $1`,
+			saved.ShortURL)
+		if err != nil {
+			return models.Url{}, false, fmt.Errorf("%s: releasing reserved code: %w", op, observeTimeout(err))
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return models.Url{}, false, fmt.Errorf("%s: failed to commit transaction: %w", op, &commitError{observeTimeout(err)})
+	}
+
+	if created {
+		now := time.Now()
+		repo.recent.add(shortKey(repo.fold(saved.ShortURL)), now)
+		repo.recent.add(originalKey(saved.CanonicalURL), now)
+	}
+
+	return saved, created, nil
+}
+
+// checkFoldedCode fails with ErrURLMappingExists if a code differing from
+// shortURL only in case is already used. The unique index is case-sensitive,
+// so concurrent inserts of such codes are serialized by an advisory lock.
+func (repo *PostgresRepository) checkFoldedCode(ctx context.Context, tx pgx.Tx, shortURL string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext(lower($1)))`, shortURL)
+	if err != nil {
+		return fmt.Errorf("locking code: %w", observeTimeout(err))
+	}
+
+	var exists bool
+	err = tx.QueryRow(ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM url_mappings WHERE lower(short_url) = lower($1)
+		 )`,
+		shortURL).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("checking existing code: %w", observeTimeout(err))
+	}
+	if exists {
+		return storage.ErrURLMappingExists
+	}
+
+	return nil
+}
+
+// OriginalURLExists checks if an url with the canonical form already exists in storage
+func (repo *PostgresRepository) OriginalURLExists(ctx context.Context, canonicalURL string) (string, bool, error) {
 	const op = "storage.postgres.OriginalURLExists"
 	var shortURL string
 
-	err := repo.db.QueryRow(ctx,
-		`SELECT short_url
-		 FROM url_mappings
-		 WHERE original_url = $1`,
-		originalURL).Scan(&shortURL)
+	err := repo.retry.do(ctx, func() error {
+		return repo.read(ctx, originalKey(canonicalURL), func(ctx context.Context, db qurier) error {
+			return db.QueryRow(ctx,
+				`SELECT short_url
+				 FROM url_mappings
+				 WHERE url_digest = $1 AND NOT standalone`,
+				urlDigest(canonicalURL)).Scan(&shortURL)
+		})
+	})
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return "", false, nil
 		}
-		return "", false, fmt.Errorf("%s: %w", op, err)
+		return "", false, fmt.Errorf("%s: %w", op, observeTimeout(err))
 	}
 
 	return shortURL, true, nil
 }
 
+// CountURLs returns the number of stored urls. It is the planner estimate,
+// so it is cheap on large tables; the exact count is used until the table
+// is analyzed for the first time.
+func (repo *PostgresRepository) CountURLs(ctx context.Context) (int64, error) {
+	const op = "storage.postgres.CountURLs"
+	var count int64
+
+	err := repo.retry.do(ctx, func() error {
+		return repo.read(ctx, "", func(ctx context.Context, db qurier) error {
+			err := db.QueryRow(ctx,
+				`SELECT reltuples::bigint
+				 FROM pg_class
+				 WHERE oid = 'url_mappings'::regclass`).Scan(&count)
+			if err != nil || count >= 0 {
+				return err
+			}
+
+			return db.QueryRow(ctx, `SELECT count(*) FROM url_mappings`).Scan(&count)
+		})
+	})
+
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, observeTimeout(err))
+	}
+
+	return count, nil
+}
+
+// NextID returns the next value of the short code sequence
+func (repo *PostgresRepository) NextID(ctx context.Context) (int64, error) {
+	const op = "storage.postgres.NextID"
+	var id int64
+
+	err := repo.retry.do(ctx, func() error {
+		ctx, cancel := withTimeout(ctx, repo.writeTimeout)
+		defer cancel()
+
+		return repo.primary.QueryRow(ctx, `SELECT nextval('short_code_seq')`).Scan(&id)
+	})
+
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, observeTimeout(err))
+	}
+
+	return id, nil
+}
+
+// ReserveCodes reserves codes which are neither used nor reserved yet,
+// it returns the codes reserved by this call. Reservations older than
+// the reservation ttl are freed first, so codes which were never used
+// or released, e.g. by a crashed instance, don't stay reserved forever.
+func (repo *PostgresRepository) ReserveCodes(ctx context.Context, codes []string) ([]string, error) {
+	const op = "storage.postgres.ReserveCodes"
+	var reserved []string
+
+	err := repo.retry.do(ctx, func() error {
+		ctx, cancel := withTimeout(ctx, repo.writeTimeout)
+		defer cancel()
+
+		if repo.reservationTTL > 0 {
+			if _, err := repo.primary.Exec(ctx,
+				`DELETE FROM reserved_codes
+				 WHERE reserved_at < LOCALTIMESTAMP - make_interval(secs => $1)`,
+				repo.reservationTTL.Seconds()); err != nil {
+				return err
+			}
+		}
+
+		query := `INSERT INTO reserved_codes(short_url)
+			 SELECT code FROM unnest($1::text[]) AS code
+			 WHERE NOT EXISTS (SELECT 1 FROM url_mappings WHERE short_url = code)
+			 ON CONFLICT DO NOTHING
+			 RETURNING short_url`
+		if repo.caseInsensitive {
+			query = `INSERT INTO reserved_codes(short_url)
+			 SELECT code FROM unnest($1::text[]) AS code
+			 WHERE NOT EXISTS (SELECT 1 FROM url_mappings WHERE lower(short_url) = lower(code))
+			 ON CONFLICT DO NOTHING
+			 RETURNING short_url`
+		}
+
+		rows, err := repo.primary.Query(ctx, query, codes)
+		if err != nil {
+			return err
+		}
+
+		reserved, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, observeTimeout(err))
+	}
+
+	return reserved, nil
+}
+
+// ReleaseCodes returns unused reserved codes
+func (repo *PostgresRepository) ReleaseCodes(ctx context.Context, codes []string) error {
+	const op = "storage.postgres.ReleaseCodes"
+
+	err := repo.retry.do(ctx, func() error {
+		ctx, cancel := withTimeout(ctx, repo.writeTimeout)
+		defer cancel()
+
+		_, err := repo.primary.Exec(ctx,
+			`DELETE FROM reserved_codes
+			 WHERE short_url = ANY($1)`,
+			codes)
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, observeTimeout(err))
+	}
+
+	return nil
+}
+
+// SetCanonicalURL changes the canonical url of the url with the id,
+// the url becomes standalone if another url has the same canonical url
+func (repo *PostgresRepository) SetCanonicalURL(ctx context.Context, id int64, canonicalURL string) (bool, error) {
+	const op = "storage.postgres.SetCanonicalURL"
+	var standalone bool
+
+	err := repo.retry.do(ctx, func() error {
+		ctx, cancel := withTimeout(ctx, repo.writeTimeout)
+		defer cancel()
+
+		return repo.primary.QueryRow(ctx,
+			`UPDATE url_mappings m
+			 SET canonical_url = $2,
+			     url_digest = $3,
+			     standalone = m.standalone OR EXISTS (
+			         SELECT 1 FROM url_mappings o
+			         WHERE o.url_digest = $3 AND NOT o.standalone AND o.id <> m.id
+			     )
+			 WHERE id = $1
+			 RETURNING standalone`,
+			id, canonicalURL, urlDigest(canonicalURL)).Scan(&standalone)
+	})
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, fmt.Errorf("%s: %w", op, storage.ErrURLMappingNotFound)
+		}
+		return false, fmt.Errorf("%s: %w", op, observeTimeout(err))
+	}
+
+	repo.recent.add(originalKey(canonicalURL), time.Now())
+	return standalone, nil
+}
+
 // Close closes a connection with the storage
 func (repo *PostgresRepository) Close() {
-	repo.db.Close()
+	repo.stopMonitor()
+	repo.replicas.close()
+	repo.primary.Close()
+}
+
+// urlDigest returns the SHA-256 digest of a canonical url, urls are looked
+// up by it because a btree index on the unbounded url itself breaks on long urls
+func urlDigest(canonicalURL string) []byte {
+	digest := sha256.Sum256([]byte(canonicalURL))
+	return digest[:]
+}
+
+// fold returns the form of a short url by which codes are compared
+func (repo *PostgresRepository) fold(shortURL string) string {
+	if repo.caseInsensitive {
+		return strings.ToLower(shortURL)
+	}
+	return shortURL
+}
+
+// shortKey is a key of a short url in recent writes
+func shortKey(shortURL string) string {
+	return "short:" + shortURL
+}
+
+// originalKey is a key of a canonical url in recent writes
+func originalKey(canonicalURL string) string {
+	return "original:" + canonicalURL
 }
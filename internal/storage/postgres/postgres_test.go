@@ -3,10 +3,15 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/hard-gainer/url-shortener/internal/config"
+	"github.com/hard-gainer/url-shortener/internal/models"
 	"github.com/hard-gainer/url-shortener/internal/storage"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/assert"
@@ -53,15 +58,17 @@ func setupPostgres(t *testing.T) (string, func()) {
 	require.NoError(t, err)
 	defer pool.Close()
 
-	_, err = pool.Exec(ctx, `
-        CREATE TABLE IF NOT EXISTS url_mappings (
-            id SERIAL PRIMARY KEY,
-            short_url TEXT UNIQUE NOT NULL,
-            original_url TEXT NOT NULL,
-            created_at TIMESTAMP DEFAULT NOW()
-        )
-    `)
+	migrations, err := filepath.Glob("../migration/*.up.sql")
 	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for _, path := range migrations {
+		migration, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		_, err = pool.Exec(ctx, string(migration))
+		require.NoError(t, err, "applying %s", path)
+	}
 
 	return connString, cleanup
 }
@@ -86,7 +93,7 @@ func TestRepository(t *testing.T) {
 		shortURL := "abc123test"
 		originalURL := "https://example.com"
 
-		id, err := repo.SaveURL(ctx, shortURL, originalURL)
+		id, err := repo.SaveURL(ctx, models.Url{ShortURL: shortURL, OriginalURL: originalURL})
 		require.NoError(t, err)
 		assert.Greater(t, id, int64(0))
 
@@ -112,10 +119,10 @@ func TestRepository(t *testing.T) {
 		originalURL1 := "https://example1.com"
 		originalURL2 := "https://example2.com"
 
-		_, err := repo.SaveURL(ctx, shortURL, originalURL1)
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: shortURL, OriginalURL: originalURL1})
 		require.NoError(t, err)
 
-		_, err = repo.SaveURL(ctx, shortURL, originalURL2)
+		_, err = repo.SaveURL(ctx, models.Url{ShortURL: shortURL, OriginalURL: originalURL2})
 		require.Error(t, err)
 		assert.ErrorIs(t, err, storage.ErrURLMappingExists)
 	})
@@ -126,10 +133,10 @@ func TestRepository(t *testing.T) {
 		shortURL2 := "original2"
 		originalURL := "https://duplicate-original.com"
 
-		_, err := repo.SaveURL(ctx, shortURL1, originalURL)
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: shortURL1, OriginalURL: originalURL})
 		require.NoError(t, err)
 
-		_, err = repo.SaveURL(ctx, shortURL2, originalURL)
+		_, err = repo.SaveURL(ctx, models.Url{ShortURL: shortURL2, OriginalURL: originalURL})
 		require.Error(t, err)
 		assert.ErrorIs(t, err, storage.ErrOriginalURLExists)
 		assert.Contains(t, err.Error(), shortURL1) // Должен содержать существующий shortURL
@@ -140,7 +147,7 @@ func TestRepository(t *testing.T) {
 		shortURL := "exists_test"
 		originalURL := "https://exists.example.com"
 
-		_, err := repo.SaveURL(ctx, shortURL, originalURL)
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: shortURL, OriginalURL: originalURL})
 		require.NoError(t, err)
 
 		existingShort, exists, err := repo.OriginalURLExists(ctx, originalURL)
@@ -154,12 +161,223 @@ func TestRepository(t *testing.T) {
 		assert.Empty(t, existingShort)
 	})
 
+	t.Run("CanonicalURL", func(t *testing.T) {
+		ctx := context.Background()
+
+		_, err := repo.SaveURL(ctx, models.Url{
+			ShortURL:     "canonical_1",
+			OriginalURL:  "HTTPS://Canonical.example.com",
+			CanonicalURL: "https://canonical.example.com/",
+		})
+		require.NoError(t, err)
+
+		existingShort, exists, err := repo.OriginalURLExists(ctx, "https://canonical.example.com/")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "canonical_1", existingShort)
+
+		_, err = repo.SaveURL(ctx, models.Url{
+			ShortURL:     "canonical_2",
+			OriginalURL:  "https://canonical.example.com:443",
+			CanonicalURL: "https://canonical.example.com/",
+		})
+		assert.ErrorIs(t, err, storage.ErrOriginalURLExists)
+
+		url, err := repo.GetURL(ctx, "canonical_1")
+		require.NoError(t, err)
+		assert.Equal(t, "HTTPS://Canonical.example.com", url.OriginalURL)
+		assert.Equal(t, "https://canonical.example.com/", url.CanonicalURL)
+	})
+
+	t.Run("Standalone", func(t *testing.T) {
+		ctx := context.Background()
+		originalURL := "https://standalone.example.com"
+
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: "standalone_1", OriginalURL: originalURL})
+		require.NoError(t, err)
+
+		_, err = repo.SaveURL(ctx, models.Url{ShortURL: "standalone_2", OriginalURL: originalURL, Standalone: true})
+		require.NoError(t, err)
+
+		existingShort, exists, err := repo.OriginalURLExists(ctx, originalURL)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "standalone_1", existingShort)
+
+		url, err := repo.GetURL(ctx, "standalone_2")
+		require.NoError(t, err)
+		assert.True(t, url.Standalone)
+	})
+
+	t.Run("Long URL", func(t *testing.T) {
+		ctx := context.Background()
+		originalURL := "https://long.example.com/?q=" + strings.Repeat("a", 10000)
+
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: "long_url", OriginalURL: originalURL})
+		require.NoError(t, err)
+
+		existingShort, exists, err := repo.OriginalURLExists(ctx, originalURL)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "long_url", existingShort)
+
+		_, err = repo.SaveURL(ctx, models.Url{ShortURL: "long_url_2", OriginalURL: originalURL})
+		assert.ErrorIs(t, err, storage.ErrOriginalURLExists)
+	})
+
+	t.Run("GetOrCreate Concurrent", func(t *testing.T) {
+		ctx := context.Background()
+		originalURL := "https://concurrent.example.com"
+
+		const workers = 20
+		codes := make([]string, workers)
+		errs := make([]error, workers)
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				saved, _, err := repo.GetOrCreate(ctx, models.Url{
+					ShortURL:    fmt.Sprintf("concurrent_%d", i),
+					OriginalURL: originalURL,
+				})
+				codes[i], errs[i] = saved.ShortURL, err
+			}()
+		}
+		wg.Wait()
+
+		for i := 0; i < workers; i++ {
+			require.NoError(t, errs[i])
+			assert.Equal(t, codes[0], codes[i])
+		}
+
+		_, created, err := repo.GetOrCreate(ctx, models.Url{ShortURL: codes[0], OriginalURL: "https://other.example.com"})
+		assert.False(t, created)
+		assert.ErrorIs(t, err, storage.ErrURLMappingExists)
+	})
+
+	t.Run("NextID", func(t *testing.T) {
+		ctx := context.Background()
+		pgRepo := repo.(*PostgresRepository)
+
+		first, err := pgRepo.NextID(ctx)
+		require.NoError(t, err)
+
+		second, err := pgRepo.NextID(ctx)
+		require.NoError(t, err)
+		assert.Greater(t, second, first)
+	})
+
+	t.Run("ReserveCodes", func(t *testing.T) {
+		ctx := context.Background()
+		pgRepo := repo.(*PostgresRepository)
+
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: "reserved_used", OriginalURL: "https://reserved.example.com"})
+		require.NoError(t, err)
+
+		reserved, err := pgRepo.ReserveCodes(ctx, []string{"reserved_used", "reserved_1", "reserved_2"})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"reserved_1", "reserved_2"}, reserved)
+
+		reserved, err = pgRepo.ReserveCodes(ctx, []string{"reserved_1"})
+		require.NoError(t, err)
+		assert.Empty(t, reserved)
+
+		require.NoError(t, pgRepo.ReleaseCodes(ctx, []string{"reserved_1"}))
+
+		reserved, err = pgRepo.ReserveCodes(ctx, []string{"reserved_1"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"reserved_1"}, reserved)
+	})
+
+	t.Run("CaseInsensitiveCodes", func(t *testing.T) {
+		ctx := context.Background()
+		pgRepo := repo.(*PostgresRepository)
+		pgRepo.caseInsensitive = true
+		defer func() { pgRepo.caseInsensitive = false }()
+
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: "CaseCode", OriginalURL: "https://case.example.com"})
+		require.NoError(t, err)
+
+		url, err := repo.GetURL(ctx, "casecode")
+		require.NoError(t, err)
+		assert.Equal(t, "CaseCode", url.ShortURL)
+
+		_, err = repo.SaveURL(ctx, models.Url{ShortURL: "CASECODE", OriginalURL: "https://case.example.org"})
+		assert.ErrorIs(t, err, storage.ErrURLMappingExists)
+
+		saved, created, err := repo.GetOrCreate(ctx, models.Url{ShortURL: "CASECODE", OriginalURL: "https://case.example.com"})
+		require.NoError(t, err, "the same url submitted again in another case is not a conflict")
+		assert.False(t, created)
+		assert.Equal(t, "CaseCode", saved.ShortURL)
+	})
+
+	t.Run("SaveURLs and ForEachURL", func(t *testing.T) {
+		ctx := context.Background()
+		pgRepo := repo.(*PostgresRepository)
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		errs, err := pgRepo.SaveURLs(ctx, []models.Url{
+			{ShortURL: "import_1", OriginalURL: "https://import.example.com/1", CreatedAt: createdAt},
+			{ShortURL: "import_1", OriginalURL: "https://import.example.com/2"},
+			{ShortURL: "import_3", OriginalURL: "https://import.example.com/1"},
+			{ShortURL: "import_4", OriginalURL: "https://import.example.com/1", Standalone: true},
+		})
+		require.NoError(t, err)
+		require.Len(t, errs, 4)
+		assert.NoError(t, errs[0])
+		assert.ErrorIs(t, errs[1], storage.ErrURLMappingExists)
+		assert.ErrorIs(t, errs[2], storage.ErrOriginalURLExists)
+		assert.NoError(t, errs[3])
+
+		url, err := repo.GetURL(ctx, "import_1")
+		require.NoError(t, err)
+		assert.True(t, createdAt.Equal(url.CreatedAt))
+
+		var exported []string
+		var lastID int64
+		err = pgRepo.ForEachURL(ctx, 0, func(url models.Url) error {
+			assert.Greater(t, url.Id, lastID)
+			lastID = url.Id
+			exported = append(exported, url.ShortURL)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Contains(t, exported, "import_1")
+		assert.Contains(t, exported, "import_4")
+		assert.NotContains(t, exported, "import_3")
+	})
+
+	t.Run("SetCanonicalURL", func(t *testing.T) {
+		ctx := context.Background()
+		pgRepo := repo.(*PostgresRepository)
+
+		firstID, err := repo.SaveURL(ctx, models.Url{ShortURL: "canon_1", OriginalURL: "HTTPS://Canon.example.com", CanonicalURL: "HTTPS://Canon.example.com"})
+		require.NoError(t, err)
+		secondID, err := repo.SaveURL(ctx, models.Url{ShortURL: "canon_2", OriginalURL: "https://canon.example.com:443", CanonicalURL: "https://canon.example.com:443"})
+		require.NoError(t, err)
+
+		standalone, err := pgRepo.SetCanonicalURL(ctx, firstID, "https://canon.example.com")
+		require.NoError(t, err)
+		assert.False(t, standalone)
+
+		standalone, err = pgRepo.SetCanonicalURL(ctx, secondID, "https://canon.example.com")
+		require.NoError(t, err)
+		assert.True(t, standalone)
+
+		existingShort, exists, err := repo.OriginalURLExists(ctx, "https://canon.example.com")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, "canon_1", existingShort)
+	})
+
 	t.Run("Transaction Rollback on Error", func(t *testing.T) {
 		ctx := context.Background()
 		shortURL := "rollback_test"
 		originalURL := "https://rollback.example.com"
 
-		_, err := repo.SaveURL(ctx, shortURL, originalURL)
+		_, err := repo.SaveURL(ctx, models.Url{ShortURL: shortURL, OriginalURL: originalURL})
 		require.NoError(t, err)
 
 		pool, err := pgxpool.New(ctx, cfg.DBConfig.URL)
@@ -171,7 +389,7 @@ func TestRepository(t *testing.T) {
 		)
 		require.NoError(t, err)
 
-		_, err = repo.SaveURL(ctx, "another_short", originalURL)
+		_, err = repo.SaveURL(ctx, models.Url{ShortURL: "another_short", OriginalURL: originalURL})
 		require.Error(t, err)
 
 		existingShort, exists, err := repo.OriginalURLExists(ctx, originalURL)
@@ -0,0 +1,148 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// replica is a read-only connection pool with its health state
+type replica struct {
+	db        qurier
+	downUntil atomic.Int64
+}
+
+// replicaSet balances reads between replicas in round-robin order
+type replicaSet struct {
+	replicas      []*replica
+	next          atomic.Uint64
+	retryInterval time.Duration
+}
+
+// newReplicaSet creates a replicaSet, failed replicas are skipped for retryInterval
+func newReplicaSet(dbs []qurier, retryInterval time.Duration) *replicaSet {
+	rs := &replicaSet{retryInterval: retryInterval}
+	for _, db := range dbs {
+		rs.replicas = append(rs.replicas, &replica{db: db})
+	}
+	return rs
+}
+
+// pick returns the next healthy replica or nil if there is none
+func (rs *replicaSet) pick(now time.Time) *replica {
+	n := len(rs.replicas)
+	if n == 0 {
+		return nil
+	}
+
+	start := rs.next.Add(1)
+	for i := 0; i < n; i++ {
+		r := rs.replicas[(start+uint64(i))%uint64(n)]
+		if now.UnixNano() >= r.downUntil.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// markDown excludes r from balancing for the retry interval
+func (rs *replicaSet) markDown(r *replica, now time.Time) {
+	r.downUntil.Store(now.Add(rs.retryInterval).UnixNano())
+}
+
+// close closes all replica pools
+func (rs *replicaSet) close() {
+	for _, r := range rs.replicas {
+		r.db.Close()
+	}
+}
+
+// recentWrites remembers keys written by this instance during the window,
+// reads of such keys go to the primary so replication lag is not visible
+type recentWrites struct {
+	window    time.Duration
+	mutex     sync.Mutex
+	keys      map[string]time.Time
+	lastPrune time.Time
+}
+
+// newRecentWrites creates a new recentWrites, zero window disables it
+func newRecentWrites(window time.Duration) *recentWrites {
+	return &recentWrites{
+		window: window,
+		keys:   make(map[string]time.Time),
+	}
+}
+
+// add remembers key as written at now
+func (rw *recentWrites) add(key string, now time.Time) {
+	if rw.window <= 0 {
+		return
+	}
+
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	if now.Sub(rw.lastPrune) > rw.window {
+		for k, t := range rw.keys {
+			if now.Sub(t) > rw.window {
+				delete(rw.keys, k)
+			}
+		}
+		rw.lastPrune = now
+	}
+
+	rw.keys[key] = now
+}
+
+// contains checks if key was written within the window before now
+func (rw *recentWrites) contains(key string, now time.Time) bool {
+	if rw.window <= 0 {
+		return false
+	}
+
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	t, exists := rw.keys[key]
+	return exists && now.Sub(t) <= rw.window
+}
+
+// read runs query on a replica, using the primary when key was written
+// recently, when no replica is healthy or when the replica fails.
+// Every attempt gets its own read timeout, a replica which runs out of
+// it while ctx is still alive is failed as well.
+func (repo *PostgresRepository) read(ctx context.Context, key string, query func(ctx context.Context, db qurier) error) error {
+	now := time.Now()
+	if repo.recent.contains(key, now) {
+		return repo.queryWithTimeout(ctx, repo.primary, query)
+	}
+
+	r := repo.replicas.pick(now)
+	if r == nil {
+		return repo.queryWithTimeout(ctx, repo.primary, query)
+	}
+
+	err := repo.queryWithTimeout(ctx, r.db, query)
+	if err == nil || errors.Is(err, pgx.ErrNoRows) || ctx.Err() != nil {
+		return err
+	}
+
+	slog.Warn("replica query failed, falling back to primary", "error", err)
+	repo.replicas.markDown(r, now)
+
+	return repo.queryWithTimeout(ctx, repo.primary, query)
+}
+
+// queryWithTimeout runs query on db limited by the read timeout
+func (repo *PostgresRepository) queryWithTimeout(ctx context.Context, db qurier, query func(ctx context.Context, db qurier) error) error {
+	ctx, cancel := withTimeout(ctx, repo.readTimeout)
+	defer cancel()
+
+	return query(ctx, db)
+}
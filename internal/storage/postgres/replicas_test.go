@@ -0,0 +1,172 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRow is a pgx.Row which returns a fixed error
+type fakeRow struct {
+	err error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	return r.err
+}
+
+// fakeDB is a qurier which counts queries and returns a fixed error,
+// a hanging one blocks until the context is done
+type fakeDB struct {
+	err     error
+	hang    bool
+	queries int
+}
+
+func (db *fakeDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	db.queries++
+	if db.hang {
+		<-ctx.Done()
+		return fakeRow{err: ctx.Err()}
+	}
+	return fakeRow{err: db.err}
+}
+
+func (db *fakeDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	db.queries++
+	return nil, db.err
+}
+
+func (db *fakeDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, db.err
+}
+
+func (db *fakeDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (db *fakeDB) Close() {}
+
+func newTestRepository(primary qurier, replicas ...qurier) *PostgresRepository {
+	return &PostgresRepository{
+		primary:  primary,
+		replicas: newReplicaSet(replicas, time.Minute),
+		recent:   newRecentWrites(time.Minute),
+	}
+}
+
+func queryRow(ctx context.Context, db qurier) error {
+	return db.QueryRow(ctx, "SELECT 1").Scan()
+}
+
+func TestRead_UsesReplica(t *testing.T) {
+	primary, replica := &fakeDB{}, &fakeDB{}
+	repo := newTestRepository(primary, replica)
+	ctx := context.Background()
+
+	err := repo.read(ctx, "short:abc", queryRow)
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, primary.queries)
+	assert.Equal(t, 1, replica.queries)
+}
+
+func TestRead_NotFoundOnReplicaIsNotAFailure(t *testing.T) {
+	primary, replica := &fakeDB{}, &fakeDB{err: pgx.ErrNoRows}
+	repo := newTestRepository(primary, replica)
+	ctx := context.Background()
+
+	err := repo.read(ctx, "short:abc", queryRow)
+
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+	assert.Equal(t, 0, primary.queries)
+	assert.NotNil(t, repo.replicas.pick(time.Now()))
+}
+
+func TestRead_RecentWriteGoesToPrimary(t *testing.T) {
+	primary, replica := &fakeDB{}, &fakeDB{}
+	repo := newTestRepository(primary, replica)
+	ctx := context.Background()
+	repo.recent.add("short:abc", time.Now())
+
+	err := repo.read(ctx, "short:abc", queryRow)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, primary.queries)
+	assert.Equal(t, 0, replica.queries)
+}
+
+func TestRead_FallsBackToPrimaryWhenReplicaFails(t *testing.T) {
+	primary, replica := &fakeDB{}, &fakeDB{err: errors.New("connection refused")}
+	repo := newTestRepository(primary, replica)
+	ctx := context.Background()
+
+	err := repo.read(ctx, "short:abc", queryRow)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, primary.queries)
+	assert.Nil(t, repo.replicas.pick(time.Now()), "failed replica must be skipped")
+
+	err = repo.read(ctx, "short:def", queryRow)
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, primary.queries)
+	assert.Equal(t, 1, replica.queries)
+}
+
+func TestRead_FallsBackToPrimaryWhenReplicaTimesOut(t *testing.T) {
+	primary, replica := &fakeDB{}, &fakeDB{hang: true}
+	repo := newTestRepository(primary, replica)
+	repo.readTimeout = 10 * time.Millisecond
+	ctx := context.Background()
+
+	err := repo.read(ctx, "short:abc", queryRow)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, replica.queries)
+	assert.Equal(t, 1, primary.queries)
+	assert.Nil(t, repo.replicas.pick(time.Now()), "timed out replica must be skipped")
+}
+
+func TestRead_CanceledContextIsNotAReplicaFailure(t *testing.T) {
+	primary, replica := &fakeDB{}, &fakeDB{hang: true}
+	repo := newTestRepository(primary, replica)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := repo.read(ctx, "short:abc", queryRow)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, primary.queries)
+	assert.NotNil(t, repo.replicas.pick(time.Now()))
+}
+
+func TestReplicaSet_PickSkipsDownReplicas(t *testing.T) {
+	first, second := &fakeDB{}, &fakeDB{}
+	rs := newReplicaSet([]qurier{first, second}, time.Minute)
+	now := time.Now()
+
+	rs.markDown(rs.replicas[0], now)
+
+	for i := 0; i < 4; i++ {
+		assert.Same(t, rs.replicas[1], rs.pick(now))
+	}
+	assert.NotNil(t, rs.pick(now.Add(2*time.Minute)))
+}
+
+func TestRecentWrites_Window(t *testing.T) {
+	rw := newRecentWrites(time.Second)
+	now := time.Now()
+
+	rw.add("short:abc", now)
+
+	assert.True(t, rw.contains("short:abc", now.Add(500*time.Millisecond)))
+	assert.False(t, rw.contains("short:abc", now.Add(2*time.Second)))
+	assert.False(t, rw.contains("short:def", now))
+}
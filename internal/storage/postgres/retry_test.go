@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"deadlock", fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: "40P01"}), true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, false},
+		{"no rows", pgx.ErrNoRows, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"unknown", errors.New("boom"), false},
+		{"commit failure", fmt.Errorf("commit: %w", &commitError{&pgconn.PgError{Code: "08006"}}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRetryable(tt.err))
+		})
+	}
+}
+
+func TestRetryPolicy_RetriesTransientErrors(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+	attempts := 0
+
+	err := policy.do(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return &pgconn.PgError{Code: "40001"}
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryPolicy_StopsAfterMaxAttempts(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 2, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+	attempts := 0
+
+	err := policy.do(context.Background(), func() error {
+		attempts++
+		return &pgconn.PgError{Code: "40001"}
+	})
+
+	require.Error(t, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestRetryPolicy_DoesNotRetryPermanentErrors(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 5, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+	attempts := 0
+
+	err := policy.do(context.Background(), func() error {
+		attempts++
+		return &pgconn.PgError{Code: "23505"}
+	})
+
+	require.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryPolicy_RespectsDeadline(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 5, baseDelay: time.Second, maxDelay: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	attempts := 0
+
+	start := time.Now()
+	err := policy.do(ctx, func() error {
+		attempts++
+		return &pgconn.PgError{Code: "40001"}
+	})
+
+	require.Error(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 10, baseDelay: 10 * time.Millisecond, maxDelay: 50 * time.Millisecond}
+
+	for attempt := 1; attempt <= 8; attempt++ {
+		step := min(policy.baseDelay<<(attempt-1), policy.maxDelay)
+		delay := policy.backoff(attempt)
+
+		assert.GreaterOrEqual(t, delay, step/2)
+		assert.LessOrEqual(t, delay, step)
+	}
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+// exportPageSize is a number of urls read by a single query of ForEachURL
+const exportPageSize = 1000
+
+// ForEachURL calls fn for every stored url with an id greater than afterID
+// in the order of ids. Urls are read in pages by id, so a long export
+// doesn't hold a connection or a snapshot.
+func (repo *PostgresRepository) ForEachURL(ctx context.Context, afterID int64, fn func(models.Url) error) error {
+	const op = "storage.postgres.ForEachURL"
+
+	for {
+		var page []models.Url
+		err := repo.retry.do(ctx, func() error {
+			return repo.read(ctx, "", func(ctx context.Context, db qurier) error {
+				rows, err := db.Query(ctx,
+					`SELECT id, short_url, original_url, canonical_url, standalone, created_at
+					 FROM url_mappings
+					 WHERE id > $1
+					 ORDER BY id
+					 LIMIT $2`,
+					afterID, exportPageSize)
+				if err != nil {
+					return err
+				}
+
+				page, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Url, error) {
+					var url models.Url
+					err := row.Scan(&url.Id, &url.ShortURL, &url.OriginalURL, &url.CanonicalURL, &url.Standalone, &url.CreatedAt)
+					return url, err
+				})
+				return err
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, observeTimeout(err))
+		}
+
+		for _, url := range page {
+			if err := fn(url); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		if len(page) < exportPageSize {
+			return nil
+		}
+		afterID = page[len(page)-1].Id
+	}
+}
+
+// SaveURLs saves urls keeping their short urls and creation times.
+// The batch is a single transaction, urls conflicting with the stored
+// ones or with the previous urls of the batch are skipped.
+func (repo *PostgresRepository) SaveURLs(ctx context.Context, urls []models.Url) ([]error, error) {
+	var errs []error
+	err := repo.retry.do(ctx, func() error {
+		var err error
+		errs, err = repo.saveURLs(ctx, urls)
+		return err
+	})
+
+	return errs, err
+}
+
+// saveURLs makes a single attempt to save a batch of urls
+func (repo *PostgresRepository) saveURLs(ctx context.Context, urls []models.Url) ([]error, error) {
+	const op = "storage.postgres.SaveURLs"
+
+	ctx, cancel := withTimeout(ctx, repo.writeTimeout)
+	defer cancel()
+
+	tx, err := repo.primary.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to begin transaction: %w", op, observeTimeout(err))
+	}
+	defer tx.Rollback(ctx)
+
+	errs := make([]error, len(urls))
+	var saved []string
+	for i, url := range urls {
+		if url.CanonicalURL == "" {
+			url.CanonicalURL = url.OriginalURL
+		}
+
+		if repo.caseInsensitive {
+			if err := repo.checkFoldedCode(ctx, tx, url.ShortURL); err != nil {
+				if errors.Is(err, storage.ErrURLMappingExists) {
+					errs[i] = err
+					continue
+				}
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		var createdAt *time.Time
+		if !url.CreatedAt.IsZero() {
+			utc := url.CreatedAt.UTC()
+			createdAt = &utc
+		}
+
+		// the insert does nothing on a conflict with any unique index,
+		// the conflicting index is found afterwards
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO url_mappings(short_url, original_url, canonical_url, url_digest, standalone, created_at)
+			 VALUES($1, $2, $3, $4, $5, COALESCE($6, CURRENT_TIMESTAMP))
+			 ON CONFLICT DO NOTHING`,
+			url.ShortURL, url.OriginalURL, url.CanonicalURL, urlDigest(url.CanonicalURL), url.Standalone, createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, observeTimeout(err))
+		}
+		if tag.RowsAffected() == 1 {
+			saved = append(saved, url.ShortURL)
+			continue
+		}
+
+		var codeExists bool
+		err = tx.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM url_mappings WHERE short_url = $1)`,
+			url.ShortURL).Scan(&codeExists)
+		if err != nil {
+			return nil, fmt.Errorf("%s: checking conflict: %w", op, observeTimeout(err))
+		}
+		if codeExists {
+			errs[i] = storage.ErrURLMappingExists
+		} else {
+			errs[i] = storage.ErrOriginalURLExists
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM reserved_codes
+		 WHERE short_url = ANY($1)`,
+		saved)
+	if err != nil {
+		return nil, fmt.Errorf("%s: releasing reserved codes: %w", op, observeTimeout(err))
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("%s: failed to commit transaction: %w", op, &commitError{observeTimeout(err)})
+	}
+
+	now := time.Now()
+	for i, url := range urls {
+		if errs[i] == nil {
+			repo.recent.add(shortKey(repo.fold(url.ShortURL)), now)
+			repo.recent.add(originalKey(url.CanonicalURL), now)
+		}
+	}
+
+	return errs, nil
+}
@@ -9,10 +9,72 @@ import (
 type Repository interface {
 	// GetUrl retrieves the url from the storage by its short url
 	GetURL(ctx context.Context, shortURL string) (models.Url, error)
-	// SaveUrl saves a new pair of short url and original url into the storage
-	SaveURL(ctx context.Context, shortURL, originalURL string) (int64, error)
-	// OriginalURLExists checks if an original URL already exists in storage
-	OriginalURLExists(ctx context.Context, originalURL string) (string, bool, error)
+	// SaveUrl saves a new pair of short url and original url into the storage,
+	// the canonical url is the original one if it is empty
+	SaveURL(ctx context.Context, url models.Url) (int64, error)
+	// GetOrCreate atomically returns the not standalone url with the canonical
+	// form of url if it exists, otherwise it saves url; the flag reports if
+	// url was created. It fails with ErrURLMappingExists if the short url is used.
+	GetOrCreate(ctx context.Context, url models.Url) (models.Url, bool, error)
+	// OriginalURLExists checks if a not standalone url with the canonical form
+	// already exists in storage
+	OriginalURLExists(ctx context.Context, canonicalURL string) (string, bool, error)
+	// CountURLs returns the number of stored urls, it may be an estimate
+	CountURLs(ctx context.Context) (int64, error)
 	// Close closes a connection with the storage
 	Close()
 }
+
+// Usage is the space used by a storage with limited capacity
+type Usage struct {
+	// Links is the number of stored urls
+	Links int64
+	// Bytes is the approximate memory used by the urls
+	Bytes int64
+	// MaxLinks is the limit of urls, 0 means no limit
+	MaxLinks int64
+	// MaxBytes is the limit of memory, 0 means no limit
+	MaxBytes int64
+	// Policy is the action taken when the storage is full
+	Policy string
+	// Evictions is the number of urls removed to free space
+	Evictions int64
+}
+
+// UsageReporter is implemented by storages with limited capacity
+type UsageReporter interface {
+	// Usage returns the current usage of the storage
+	Usage(ctx context.Context) (Usage, error)
+}
+
+// Snapshotter is implemented by storages which keep urls in memory
+// and can save them to survive restarts
+type Snapshotter interface {
+	// Snapshot saves the stored urls, it returns their number
+	Snapshot(ctx context.Context) (int64, error)
+}
+
+// Exporter is implemented by storages which can list all urls
+type Exporter interface {
+	// ForEachURL calls fn for every stored url with an id greater than afterID
+	// in the order of ids, it stops at the first error of fn
+	ForEachURL(ctx context.Context, afterID int64, fn func(models.Url) error) error
+}
+
+// Importer is implemented by storages which can save urls in batches
+type Importer interface {
+	// SaveURLs saves urls keeping their short urls and creation times,
+	// a zero creation time means now. It returns an error for every url:
+	// nil if it was saved, ErrURLMappingExists or ErrOriginalURLExists
+	// if it conflicts with a stored url.
+	SaveURLs(ctx context.Context, urls []models.Url) ([]error, error)
+}
+
+// Recanonicalizer is implemented by storages which can change the canonical
+// urls of stored urls, e.g. the ones saved before urls were canonicalized
+type Recanonicalizer interface {
+	// SetCanonicalURL changes the canonical url of the url with the id, the url
+	// becomes standalone if another url is already deduplicated by it.
+	// It reports whether the url is standalone.
+	SetCanonicalURL(ctx context.Context, id int64, canonicalURL string) (bool, error)
+}
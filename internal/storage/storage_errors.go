@@ -1,6 +1,9 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
 	// ErrURLMappingNotFound is returned when a short URL doesn't exist
@@ -9,6 +12,26 @@ var (
 	// ErrURLMappingExists is returned when trying to create a short URL that already exists
 	ErrURLMappingExists = errors.New("url mapping already exists")
 
-    // ErrOriginalURLExists is returned when trying to shorten a URL that's already shortened
-    ErrOriginalURLExists = errors.New("original url already exists")
+	// ErrOriginalURLExists is returned when trying to shorten a URL that's already shortened
+	ErrOriginalURLExists = errors.New("original url already exists")
+
+	// ErrStorageUnavailable is returned when the storage is temporarily unavailable
+	ErrStorageUnavailable = errors.New("storage is unavailable")
+
+	// ErrStorageFull is returned when a bounded storage has no space for a new url
+	ErrStorageFull = errors.New("storage is full")
 )
+
+// UnavailableError is returned when the storage is temporarily unavailable,
+// it reports when the request may be retried
+type UnavailableError struct {
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return ErrStorageUnavailable.Error()
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return ErrStorageUnavailable
+}
@@ -0,0 +1,390 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/service"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+)
+
+const (
+	// DefaultBatchSize is a number of urls saved at once
+	DefaultBatchSize = 500
+	// MaxReportedErrors is a number of rows not imported listed in the report
+	MaxReportedErrors = 1000
+	// maxShortURLLength is the length of the short url column
+	maxShortURLLength = 255
+	// maxLineLength limits a line of the JSONL format
+	maxLineLength = 1 << 20
+)
+
+// ErrInvalidFile is returned when the imported file can't be read as a whole
+var ErrInvalidFile = errors.New("invalid file")
+
+// Report is the result of an import
+type Report struct {
+	// Imported is the number of saved urls
+	Imported int64
+	// Conflicts is the number of urls whose short or original url is used
+	Conflicts int64
+	// Invalid is the number of malformed rows
+	Invalid int64
+	// Errors are the first MaxReportedErrors rows which were not imported
+	Errors []RowError
+}
+
+// RowError describes a row which was not imported
+type RowError struct {
+	// Line is the line of the row in the file
+	Line     int64
+	ShortURL string
+	Error    string
+}
+
+// addError records a row which was not imported
+func (r *Report) addError(line int64, shortURL string, err error) {
+	if len(r.Errors) < MaxReportedErrors {
+		r.Errors = append(r.Errors, RowError{Line: line, ShortURL: shortURL, Error: err.Error()})
+	}
+}
+
+// ImportOption configures an import
+type ImportOption func(*importer)
+
+// WithBatchSize sets the number of urls saved at once
+func WithBatchSize(n int) ImportOption {
+	return func(im *importer) {
+		if n > 0 {
+			im.batchSize = n
+		}
+	}
+}
+
+// WithCanonicalizer sets the canonicalizer of urls imported without
+// the canonical url, it must match the one of the service
+func WithCanonicalizer(c service.Canonicalizer) ImportOption {
+	return func(im *importer) {
+		im.canonicalizer = c
+	}
+}
+
+// WithCodeValidator rejects rows whose short url no code policy could
+// issue or is a reserved path, short urls are stored in the form of
+// their policy
+func WithCodeValidator(v *service.CodeValidator) ImportOption {
+	return func(im *importer) {
+		im.validator = v
+	}
+}
+
+// WithBlocklist rejects rows whose short url is blocked
+func WithBlocklist(b *service.Blocklist) ImportOption {
+	return func(im *importer) {
+		im.blocklist = b
+	}
+}
+
+// WithDestinationPolicy rejects rows whose original url may not be shortened
+func WithDestinationPolicy(p *service.DestinationPolicy) ImportOption {
+	return func(im *importer) {
+		im.destinations = p
+	}
+}
+
+// WithOnSaved calls fn with the short url of every saved url
+func WithOnSaved(fn func(shortURL string)) ImportOption {
+	return func(im *importer) {
+		im.onSaved = fn
+	}
+}
+
+// importer saves the rows read from a file in batches
+type importer struct {
+	dst           storage.Importer
+	batchSize     int
+	canonicalizer service.Canonicalizer
+	validator     *service.CodeValidator
+	blocklist     *service.Blocklist
+	destinations  *service.DestinationPolicy
+	onSaved       func(shortURL string)
+
+	report Report
+	urls   []models.Url
+	lines  []int64
+}
+
+// rowReader reads the rows of a file, it returns a rowError for
+// a malformed row and io.EOF at the end of the file
+type rowReader func() (Record, int64, error)
+
+// rowError is a malformed row
+type rowError struct {
+	line int64
+	err  error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+// Import reads urls in format from r and saves them to dst keeping their
+// short urls. Malformed rows and rows conflicting with the stored urls are
+// skipped and listed in the report, other errors stop the import; the urls
+// saved before stay saved.
+func Import(ctx context.Context, dst storage.Importer, r io.Reader, format Format, opts ...ImportOption) (Report, error) {
+	const op = "transfer.Import"
+
+	im := &importer{dst: dst, batchSize: DefaultBatchSize}
+	for _, opt := range opts {
+		opt(im)
+	}
+
+	var next rowReader
+	var err error
+	switch format {
+	case JSONL:
+		next = jsonlReader(r)
+	case CSV:
+		next, err = csvReader(r)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return Report{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for {
+		record, line, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var invalid *rowError
+		if errors.As(err, &invalid) {
+			im.report.Invalid++
+			im.report.addError(invalid.line, "", invalid.err)
+			continue
+		}
+		if err != nil {
+			return im.report, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if err := im.add(ctx, record, line); err != nil {
+			return im.report, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := im.flush(ctx); err != nil {
+		return im.report, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return im.report, nil
+}
+
+// add validates record and adds it to the batch
+func (im *importer) add(ctx context.Context, record Record, line int64) error {
+	url, err := im.toURL(ctx, record)
+	if err != nil {
+		im.report.Invalid++
+		im.report.addError(line, record.ShortURL, err)
+		return nil
+	}
+
+	im.urls = append(im.urls, url)
+	im.lines = append(im.lines, line)
+	if len(im.urls) < im.batchSize {
+		return nil
+	}
+	return im.flush(ctx)
+}
+
+// flush saves the batch
+func (im *importer) flush(ctx context.Context) error {
+	if len(im.urls) == 0 {
+		return nil
+	}
+
+	errs, err := im.dst.SaveURLs(ctx, im.urls)
+	if err != nil {
+		return err
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			im.report.Conflicts++
+			im.report.addError(im.lines[i], im.urls[i].ShortURL, err)
+			continue
+		}
+		im.report.Imported++
+		if im.onSaved != nil {
+			im.onSaved(im.urls[i].ShortURL)
+		}
+	}
+
+	im.urls = im.urls[:0]
+	im.lines = im.lines[:0]
+	return nil
+}
+
+// toURL validates record and converts it to a url
+func (im *importer) toURL(ctx context.Context, record Record) (models.Url, error) {
+	switch {
+	case record.ShortURL == "":
+		return models.Url{}, errors.New("short_url is required")
+	case len(record.ShortURL) > maxShortURLLength:
+		return models.Url{}, fmt.Errorf("short_url is longer than %d", maxShortURLLength)
+	case strings.ContainsAny(record.ShortURL, "/?# \t\r\n"):
+		return models.Url{}, errors.New("short_url contains reserved characters")
+	case record.OriginalURL == "":
+		return models.Url{}, errors.New("original_url is required")
+	}
+
+	if _, err := url.ParseRequestURI(record.OriginalURL); err != nil {
+		return models.Url{}, errors.New("original_url is not a valid url")
+	}
+
+	shortURL := record.ShortURL
+	if im.validator != nil {
+		var possible bool
+		if shortURL, possible = im.validator.Normalize(shortURL); !possible {
+			return models.Url{}, errors.New("short_url matches no code policy")
+		}
+		if im.validator.Reserved(shortURL) {
+			return models.Url{}, errors.New("short_url is reserved")
+		}
+	}
+	if im.blocklist != nil && im.blocklist.Blocked(shortURL) {
+		return models.Url{}, errors.New("short_url is not allowed")
+	}
+	if im.destinations != nil {
+		if err := im.destinations.Check(ctx, record.OriginalURL); err != nil {
+			return models.Url{}, err
+		}
+	}
+
+	canonicalURL := record.CanonicalURL
+	if canonicalURL == "" {
+		var err error
+		if canonicalURL, err = im.canonicalizer.Canonicalize(record.OriginalURL); err != nil {
+			return models.Url{}, errors.New("original_url is not a valid url")
+		}
+	}
+
+	return models.Url{
+		ShortURL:     shortURL,
+		OriginalURL:  record.OriginalURL,
+		CanonicalURL: canonicalURL,
+		Standalone:   record.Standalone,
+		CreatedAt:    record.CreatedAt,
+	}, nil
+}
+
+// jsonlReader reads a Record per line, blank lines are skipped
+func jsonlReader(r io.Reader) rowReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	var line int64
+
+	return func() (Record, int64, error) {
+		for scanner.Scan() {
+			line++
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+
+			var record Record
+			if err := json.Unmarshal(data, &record); err != nil {
+				return Record{}, line, &rowError{line: line, err: err}
+			}
+			return record, line, nil
+		}
+
+		if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+			return Record{}, line, fmt.Errorf("%w: line %d is longer than %d bytes", ErrInvalidFile, line+1, maxLineLength)
+		}
+		if err := scanner.Err(); err != nil {
+			return Record{}, line, err
+		}
+		return Record{}, line, io.EOF
+	}
+}
+
+// csvReader reads a Record per row, the header names the columns,
+// short_url and original_url are required
+func csvReader(r io.Reader) (rowReader, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return func() (Record, int64, error) { return Record{}, 0, io.EOF }, nil
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidFile, parseErr.Err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"short_url", "original_url"} {
+		if _, exists := columns[required]; !exists {
+			return nil, fmt.Errorf("%w: header has no %s column", ErrInvalidFile, required)
+		}
+	}
+
+	field := func(row []string, name string) string {
+		if i, exists := columns[name]; exists {
+			return row[i]
+		}
+		return ""
+	}
+
+	return func() (Record, int64, error) {
+		row, err := reader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return Record{}, int64(parseErr.Line), &rowError{line: int64(parseErr.Line), err: parseErr.Err}
+			}
+			return Record{}, 0, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		record := Record{
+			ShortURL:     field(row, "short_url"),
+			OriginalURL:  field(row, "original_url"),
+			CanonicalURL: field(row, "canonical_url"),
+		}
+
+		if standalone := field(row, "standalone"); standalone != "" {
+			if record.Standalone, err = strconv.ParseBool(standalone); err != nil {
+				return Record{}, int64(line), &rowError{line: int64(line), err: errors.New("standalone is not a boolean")}
+			}
+		}
+		if createdAt := field(row, "created_at"); createdAt != "" {
+			if record.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+				return Record{}, int64(line), &rowError{line: int64(line), err: errors.New("created_at is not an RFC 3339 time")}
+			}
+		}
+
+		return record, int64(line), nil
+	}, nil
+}
@@ -0,0 +1,125 @@
+package transfer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+)
+
+// Format is a format of the export file
+type Format string
+
+const (
+	// JSONL is a JSON object of a Record per line
+	JSONL Format = "jsonl"
+	// CSV is a Record per row after a header with the column names
+	CSV Format = "csv"
+)
+
+// csvColumns are the columns of the CSV format in the order they are exported
+var csvColumns = []string{"short_url", "original_url", "canonical_url", "standalone", "created_at"}
+
+// ParseFormat returns the format by its name, JSONL if name is empty
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", JSONL:
+		return JSONL, nil
+	case CSV:
+		return CSV, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", name)
+	}
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// Record is a url in the export file, CanonicalURL is empty
+// if it equals OriginalURL
+type Record struct {
+	ShortURL     string    `json:"short_url"`
+	OriginalURL  string    `json:"original_url"`
+	CanonicalURL string    `json:"canonical_url,omitempty"`
+	Standalone   bool      `json:"standalone,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// newRecord creates the record of url
+func newRecord(url models.Url) Record {
+	record := Record{
+		ShortURL:    url.ShortURL,
+		OriginalURL: url.OriginalURL,
+		Standalone:  url.Standalone,
+		CreatedAt:   url.CreatedAt,
+	}
+	if url.CanonicalURL != url.OriginalURL {
+		record.CanonicalURL = url.CanonicalURL
+	}
+	return record
+}
+
+// Export writes every url of src to w in format, it returns the number
+// of written urls. Urls are streamed, so the export isn't kept in memory.
+func Export(ctx context.Context, src storage.Exporter, w io.Writer, format Format) (int64, error) {
+	const op = "transfer.Export"
+
+	var write func(Record) error
+	var flush func() error
+
+	switch format {
+	case JSONL:
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		write = func(r Record) error { return encoder.Encode(r) }
+		flush = func() error { return nil }
+	case CSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvColumns); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		write = func(r Record) error {
+			return writer.Write([]string{
+				r.ShortURL,
+				r.OriginalURL,
+				r.CanonicalURL,
+				strconv.FormatBool(r.Standalone),
+				r.CreatedAt.Format(time.RFC3339Nano),
+			})
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	default:
+		return 0, fmt.Errorf("%s: unknown format %q", op, format)
+	}
+
+	var count int64
+	err := src.ForEachURL(ctx, 0, func(url models.Url) error {
+		if err := write(newRecord(url)); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return count, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return count, nil
+}
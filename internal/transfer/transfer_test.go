@@ -0,0 +1,204 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hard-gainer/url-shortener/internal/models"
+	"github.com/hard-gainer/url-shortener/internal/service"
+	"github.com/hard-gainer/url-shortener/internal/storage"
+	"github.com/hard-gainer/url-shortener/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRepo creates a memory repository with urls
+func newRepo(t *testing.T, urls ...models.Url) *memory.MemoryRepository {
+	t.Helper()
+
+	repo, err := memory.NewMemory()
+	require.NoError(t, err)
+	for _, url := range urls {
+		_, err := repo.SaveURL(context.Background(), url)
+		require.NoError(t, err)
+	}
+	return repo.(*memory.MemoryRepository)
+}
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	urls := []models.Url{
+		{ShortURL: "abc123", OriginalURL: "HTTPS://Example.com", CanonicalURL: "https://example.com/", CreatedAt: createdAt},
+		{ShortURL: "def456", OriginalURL: "https://example.org/?q=a,b&x=\"y\"", CreatedAt: createdAt},
+		{ShortURL: "ghi789", OriginalURL: "https://example.org/?q=a,b&x=\"y\"", Standalone: true, CreatedAt: createdAt},
+	}
+
+	for _, format := range []Format{JSONL, CSV} {
+		t.Run(string(format), func(t *testing.T) {
+			ctx := context.Background()
+			src := newRepo(t, urls...)
+
+			var buf bytes.Buffer
+			exported, err := Export(ctx, src, &buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), exported)
+
+			dst := newRepo(t)
+			report, err := Import(ctx, dst, &buf, format, WithBatchSize(2))
+			require.NoError(t, err)
+			assert.Equal(t, Report{Imported: 3}, report)
+
+			for _, expected := range urls {
+				url, err := dst.GetURL(ctx, expected.ShortURL)
+				require.NoError(t, err)
+
+				canonicalURL := expected.CanonicalURL
+				if canonicalURL == "" {
+					canonicalURL = expected.OriginalURL
+				}
+				assert.Equal(t, expected.OriginalURL, url.OriginalURL)
+				assert.Equal(t, canonicalURL, url.CanonicalURL)
+				assert.Equal(t, expected.Standalone, url.Standalone)
+				assert.True(t, expected.CreatedAt.Equal(url.CreatedAt))
+			}
+		})
+	}
+}
+
+func TestExport_JSONL(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := newRepo(t, models.Url{ShortURL: "abc123", OriginalURL: "https://example.com/?a=1&b=2", CreatedAt: createdAt})
+
+	var buf bytes.Buffer
+	_, err := Export(context.Background(), src, &buf, JSONL)
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		`{"short_url":"abc123","original_url":"https://example.com/?a=1&b=2","created_at":"2024-01-02T03:04:05Z"}`+"\n",
+		buf.String())
+}
+
+func TestImport_Report(t *testing.T) {
+	input := strings.Join([]string{
+		`{"short_url":"abc123","original_url":"https://example.com"}`,
+		`{"short_url":"taken1","original_url":"https://example.org"}`,
+		``,
+		`{"short_url":"","original_url":"https://example.net"}`,
+		`not json`,
+		`{"short_url":"dup123","original_url":"HTTPS://EXAMPLE.com/taken"}`,
+		`{"short_url":"a/b","original_url":"https://example.net"}`,
+		`{"short_url":"bad123","original_url":"example"}`,
+	}, "\n")
+
+	dst := newRepo(t,
+		models.Url{ShortURL: "taken1", OriginalURL: "https://example.net"},
+		models.Url{ShortURL: "other1", OriginalURL: "https://example.com/taken"},
+	)
+	report, err := Import(context.Background(), dst, strings.NewReader(input), JSONL)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), report.Imported)
+	assert.Equal(t, int64(2), report.Conflicts)
+	assert.Equal(t, int64(4), report.Invalid)
+
+	lines := make(map[int64]RowError)
+	for _, e := range report.Errors {
+		lines[e.Line] = e
+	}
+	assert.Len(t, lines, 6)
+	assert.Contains(t, lines[2].Error, storage.ErrURLMappingExists.Error())
+	assert.Contains(t, lines[6].Error, storage.ErrOriginalURLExists.Error(), "urls are canonicalized")
+	assert.Equal(t, "short_url is required", lines[4].Error)
+	assert.Contains(t, lines, int64(5))
+	assert.Equal(t, "short_url contains reserved characters", lines[7].Error)
+	assert.Equal(t, "original_url is not a valid url", lines[8].Error)
+}
+
+func TestImport_Policies(t *testing.T) {
+	input := strings.Join([]string{
+		`{"short_url":"Good_Link","original_url":"https://example.com"}`,
+		`{"short_url":"x-y","original_url":"https://example.com/1"}`,
+		`{"short_url":"admin","original_url":"https://example.com/2"}`,
+		`{"short_url":"my_b4d_link","original_url":"https://example.com/3"}`,
+		`{"short_url":"javascript","original_url":"javascript:alert(1)"}`,
+	}, "\n")
+
+	policies, err := service.NewPolicySet(service.DefaultPolicy.CaseFolded())
+	require.NoError(t, err)
+	blocklist := service.NewBlocklist()
+	blocklistFile := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(blocklistFile, []byte("bad\n"), 0o600))
+	require.NoError(t, blocklist.Load(blocklistFile))
+
+	dst := newRepo(t)
+	var saved []string
+	report, err := Import(context.Background(), dst, strings.NewReader(input), JSONL,
+		WithCodeValidator(service.NewCodeValidator(policies, nil)),
+		WithBlocklist(blocklist),
+		WithDestinationPolicy(service.NewDestinationPolicy(service.AllowSchemes("http", "https"))),
+		WithOnSaved(func(shortURL string) { saved = append(saved, shortURL) }),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), report.Imported)
+	assert.Equal(t, []string{"good_link"}, saved)
+	assert.Equal(t, int64(4), report.Invalid)
+
+	lines := make(map[int64]RowError)
+	for _, e := range report.Errors {
+		lines[e.Line] = e
+	}
+	assert.Equal(t, "short_url matches no code policy", lines[2].Error)
+	assert.Equal(t, "short_url is reserved", lines[3].Error)
+	assert.Equal(t, "short_url is not allowed", lines[4].Error)
+	assert.Contains(t, lines[5].Error, service.ErrSchemeNotAllowed.Error())
+
+	url, err := dst.GetURL(context.Background(), "good_link")
+	require.NoError(t, err)
+	assert.Equal(t, "good_link", url.ShortURL, "short urls are stored in the form of their policy")
+}
+
+func TestImport_CSV(t *testing.T) {
+	input := "original_url,short_url,created_at\n" +
+		"https://example.com,abc123,2024-01-02T03:04:05Z\n" +
+		"https://example.org,def456,yesterday\n" +
+		"https://example.net\n"
+
+	dst := newRepo(t)
+	report, err := Import(context.Background(), dst, strings.NewReader(input), CSV)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), report.Imported)
+	assert.Equal(t, int64(2), report.Invalid)
+	require.Len(t, report.Errors, 2)
+	assert.Equal(t, int64(3), report.Errors[0].Line)
+	assert.Equal(t, int64(4), report.Errors[1].Line)
+
+	url, err := dst.GetURL(context.Background(), "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", url.OriginalURL)
+}
+
+func TestImport_CSVWithoutRequiredColumn(t *testing.T) {
+	_, err := Import(context.Background(), newRepo(t), strings.NewReader("short_url,url\nabc123,https://example.com\n"), CSV)
+	assert.ErrorIs(t, err, ErrInvalidFile)
+	assert.ErrorContains(t, err, "original_url")
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, JSONL, format)
+
+	format, err = ParseFormat("csv")
+	require.NoError(t, err)
+	assert.Equal(t, CSV, format)
+
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}